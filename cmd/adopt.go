@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// adoptCmd represents the setup adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Adopt the Stytch organization, connection and IdP application of a lost setup result",
+	Long: `Adopt rebuilds the setup result when the state file was lost or reset. It searches Stytch
+for the organization by OrganizationSlug, its SAML connection by ConnectionDisplayName
+and the IdP application by SAMLAppLabel, then records their IDs and SSO parameters.
+
+The IDs already recorded are kept. Run setup afterwards to create what was not found
+and to reconcile the connection with the IdP metadata. Setup also adopts automatically
+when Stytch reports the organization slug as already used.`,
+	SilenceUsage: true,
+	RunE:         RunAdopt,
+}
+
+func RunAdopt(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	result, err := bootstraper.Adopt(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, found := range []struct{ name, id string }{
+		{"organization", result.StytchResult.OrganizationID},
+		{"connection", result.StytchResult.ConnectionID},
+		{"application", result.OktaResult.ApplicationID},
+	} {
+		if found.id == "" {
+			cmd.Printf("%s: not found, setup will create it\n", found.name)
+			continue
+		}
+		cmd.Printf("%s: %s\n", found.name, found.id)
+	}
+	return nil
+}
+
+func init() {
+	setupCmd.AddCommand(adoptCmd)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xNok/go-stytch-demo/pkg/setup"
+)
+
+const (
+	flagWarnWithin = "warn-within"
+)
+
+// certStatusCmd represents the setup cert-status command
+var certStatusCmd = &cobra.Command{
+	Use:   "cert-status",
+	Short: "Inspect the IdP certificates used by the SAML connection",
+	Long: `Cert-status decodes the certificate published in the IdP metadata and the verification
+certificates trusted by the Stytch connection, and reports their subject, issuer,
+SHA-256 fingerprint, key type and size and validity period, along with the end of validity
+of the metadata document when it sets validUntil. The cacheDuration of the document is not
+reported, the metadata is fetched again on each run.
+
+The command exits with a non-zero status when a certificate or the metadata expires within --warn-within.`,
+	SilenceUsage: true,
+	RunE:         RunCertStatus,
+}
+
+func RunCertStatus(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	statuses, err := bootstraper.CertificateStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	window, _ := cmd.Flags().GetDuration(flagWarnWithin)
+	now := time.Now()
+	warnings := 0
+	for _, status := range statuses {
+		cmd.Printf("%s\n  %s\n", status.Source, status.CertificateInfo)
+		if status.ExpiresWithin(now, window) {
+			warnings++
+			cmd.Printf("  WARNING: expires on %s (in %s)\n", status.NotAfter.Format(time.RFC3339), status.NotAfter.Sub(now).Round(time.Hour))
+		}
+		if !status.MetadataExpiresAt.IsZero() {
+			cmd.Printf("  metadata valid until %s\n", status.MetadataExpiresAt.Format(time.RFC3339))
+			if status.MetadataExpiresAt.Before(now.Add(window)) {
+				warnings++
+				cmd.Printf("  WARNING: the metadata must be fetched again before %s, run setup --from-step %s\n",
+					status.MetadataExpiresAt.Format(time.RFC3339), setup.StepFetchMetadata)
+			}
+		}
+	}
+
+	if warnings > 0 {
+		return fmt.Errorf("%d certificate(s) or metadata expire within %s", warnings, window)
+	}
+	return nil
+}
+
+func init() {
+	setupCmd.AddCommand(certStatusCmd)
+
+	certStatusCmd.Flags().Duration(flagWarnWithin, 30*24*time.Hour, "Warn when a certificate expires within this duration")
+}
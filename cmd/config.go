@@ -6,12 +6,11 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/b2bstytchapi"
-	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
 	"github.com/xNok/go-stytch-demo/pkg/rbac"
 )
 
@@ -39,9 +38,9 @@ func RunConfig(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	v := viper.GetViper()
 
-	clientConf, err := config.NewClientConfig(v)
+	clientConf, err := clientConfig(cmd, v)
 	if err != nil {
-		return fmt.Errorf("error loading client configs, did you forget to set environement varaibles? %s", err)
+		return err
 	}
 
 	// Step 1: Instanciate stytch client
@@ -53,16 +52,28 @@ func RunConfig(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error instantiating API client %s", err)
 	}
 
-	conf, err := config.NewSetupResult(v)
+	unlock, err := lockState(cmd, v)
 	if err != nil {
-		log.Fatalf("error reading config. Did you complete the setup? %s", err)
+		return err
+	}
+	defer unlock()
+
+	providers, err := confProviders(cmd, v)
+	if err != nil {
+		return err
+	}
+	conf, err := providers("").Load()
+	if err != nil {
+		return fmt.Errorf("error reading config. Did you complete the setup? %s", err)
 	}
 
 	stytchRBACConfig := &rbac.StytchRBACConfig{
 		OrganizationID: conf.OrganizationID,
 		ConnectionID:   conf.ConnectionID,
 		Domain:         "devops-family.com",
+		Journal:        journal.New(journalPath(v)),
 	}
+	ctx = journal.WithStep(ctx, "config")
 
 	if flag, _ := cmd.Flags().GetBool(flagOrgImpAss); flag {
 		cmd.Println("ApplyOrganizationImplictAssignement")
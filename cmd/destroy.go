@@ -0,0 +1,42 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// destroyCmd represents the setup destroy command
+var destroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Destroy deletes every resource created by setup",
+	Long: `Destroy reads the setup result and removes, in reverse order,
+the Okta application, the Stytch SAML or OIDC connection and the Stytch organisation.
+IDs are cleared from the state file after each step, so it is safe to re-run after a failure.`,
+	RunE: RunDestroy,
+}
+
+func RunDestroy(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return bootstraper.Destroy(ctx)
+}
+
+func init() {
+	setupCmd.AddCommand(destroyCmd)
+}
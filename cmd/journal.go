@@ -0,0 +1,158 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+const (
+	flagSince = "since"
+	flagUntil = "until"
+	flagJSON  = "json"
+
+	// journalFile is written next to the config file
+	journalFile = "setup.journal.jsonl"
+)
+
+// journalCmd represents the journal command
+var journalCmd = &cobra.Command{
+	Use:   "journal",
+	Short: "Inspect the audit trail of the provisioning actions",
+	Long: `Every mutating API call made by setup and config is appended to ` + journalFile + `,
+next to the config file, with its timestamp, step, target IDs, redacted request and result.`,
+}
+
+// journalShowCmd represents the journal show command
+var journalShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the journal entries",
+	Long: `Show prints the journal entries in the order they were recorded.
+
+--since and --until accept an RFC3339 time (2024-05-01T00:00:00Z) or a duration
+relative to now (24h for the last day).`,
+	SilenceUsage: true,
+	RunE:         RunJournalShow,
+}
+
+func RunJournalShow(cmd *cobra.Command, args []string) error {
+	var filter journal.Filter
+	var err error
+
+	filter.Tenant, _ = cmd.Flags().GetString(flagTenant)
+	now := time.Now()
+	if filter.Since, err = parseJournalTime(cmd, flagSince, now); err != nil {
+		return err
+	}
+	if filter.Until, err = parseJournalTime(cmd, flagUntil, now); err != nil {
+		return err
+	}
+
+	entries, err := journal.Read(journalPath(viper.GetViper()), filter)
+	if err != nil {
+		return err
+	}
+
+	asJSON, _ := cmd.Flags().GetBool(flagJSON)
+	for _, entry := range entries {
+		if asJSON {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			cmd.Println(string(line))
+			continue
+		}
+		cmd.Println(formatEntry(entry))
+	}
+	return nil
+}
+
+// formatEntry renders an entry on a single line, the request is only available with --json
+func formatEntry(entry journal.Entry) string {
+	var b strings.Builder
+	b.WriteString(entry.Time.Local().Format(time.RFC3339))
+	if entry.Tenant != "" {
+		fmt.Fprintf(&b, " [%s]", entry.Tenant)
+	}
+	if entry.Step != "" {
+		fmt.Fprintf(&b, " %s:", entry.Step)
+	}
+	fmt.Fprintf(&b, " %s", entry.API)
+	if len(entry.Targets) > 0 {
+		fmt.Fprintf(&b, " %s", formatIDs(entry.Targets))
+	}
+	if entry.Error != "" {
+		fmt.Fprintf(&b, " FAILED %s", entry.Error)
+		return b.String()
+	}
+	b.WriteString(" OK")
+	if len(entry.Result) > 0 {
+		fmt.Fprintf(&b, " %s", formatIDs(entry.Result))
+	}
+	return b.String()
+}
+
+func formatIDs(ids map[string]string) string {
+	keys := make([]string, 0, len(ids))
+	for key := range ids {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+ids[key])
+	}
+	return strings.Join(pairs, " ")
+}
+
+func parseJournalTime(cmd *cobra.Command, flag string, now time.Time) (time.Time, error) {
+	value, _ := cmd.Flags().GetString(flag)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q, expected an RFC3339 time or a duration", flag, value)
+	}
+	return t, nil
+}
+
+// journalPath returns the journal file next to the config file
+func journalPath(v *viper.Viper) string {
+	return nextToConfig(v, journalFile)
+}
+
+// nextToConfig returns the path of a file in the directory of the config file
+func nextToConfig(v *viper.Viper, name string) string {
+	dir := "."
+	if file := v.ConfigFileUsed(); file != "" {
+		dir = filepath.Dir(file)
+	}
+	return filepath.Join(dir, name)
+}
+
+func init() {
+	rootCmd.AddCommand(journalCmd)
+	journalCmd.AddCommand(journalShowCmd)
+
+	journalShowCmd.Flags().String(flagTenant, "", "Only show the entries of this tenant")
+	journalShowCmd.Flags().String(flagSince, "", "Only show the entries recorded after this time")
+	journalShowCmd.Flags().String(flagUntil, "", "Only show the entries recorded before this time")
+	journalShowCmd.Flags().Bool(flagJSON, false, "Print the raw JSON entries, including the redacted requests")
+}
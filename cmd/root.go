@@ -4,12 +4,22 @@ Copyright © 2024 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/setup"
+)
+
+const (
+	flagState        = "state"
+	flagStateBackend = "state-backend"
+	flagStateKeyFile = "state-key-file"
+	flagVaultPath    = "vault-path"
 )
 
 var cfgFile string
@@ -43,6 +53,11 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./setup.yaml)")
+	rootCmd.PersistentFlags().String(flagState, "", "state file holding the setup results (default is "+config.StateFile+" next to the config file)")
+	rootCmd.PersistentFlags().String(flagStateBackend, "", "remote state shared by several machines, s3://bucket/key for an S3 compatible bucket")
+	rootCmd.PersistentFlags().String(flagVaultPath, "", "path of the Vault KV v2 mount holding the setup input, results and client credentials instead of the config and state files")
+	rootCmd.PersistentFlags().String(flagStateKeyFile, "", "key file encrypting the state and secrets files, instead of the STATE_PASSPHRASE environment variable")
+	rootCmd.MarkFlagsMutuallyExclusive(flagState, flagStateBackend)
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -69,3 +84,160 @@ func initConfig() {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
+
+// stateStore returns the store of the setup results, the config file is only read
+// The state is kept in the --state-backend when given, in the state file otherwise
+func stateStore(cmd *cobra.Command, v *viper.Viper) (config.StateStore, error) {
+	if backend, _ := cmd.Flags().GetString(flagStateBackend); backend != "" {
+		// fails when a key is set, the S3 state would not be encrypted
+		if _, err := fileCipher(cmd, v); err != nil {
+			return nil, err
+		}
+		bucket, key, err := config.ParseS3URL(backend)
+		if err != nil {
+			return nil, err
+		}
+		conf, err := config.NewS3Conf(v)
+		if err != nil {
+			return nil, fmt.Errorf("error loading S3 configs %w", err)
+		}
+		return config.NewS3StateStore(conf, bucket, key), nil
+	}
+
+	path, _ := cmd.Flags().GetString(flagState)
+	if path == "" {
+		path = nextToConfig(v, config.StateFile)
+	}
+
+	cipher, err := fileCipher(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	store := config.NewFileStateStore(path)
+	store.Cipher = cipher
+	return store, nil
+}
+
+// secretStore returns the store of the credentials created by setup
+// They are kept in Vault along with the setup, next to the config file otherwise
+// The S3 state has no place for them, they are refused rather than left on the machine that created them
+func secretStore(cmd *cobra.Command, v *viper.Viper) (config.SecretStore, error) {
+	client, err := vaultClient(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		return config.NewVaultSecretStore(client, client.Conf.Path), nil
+	}
+	if backend, _ := cmd.Flags().GetString(flagStateBackend); backend != "" {
+		return &config.UnsharedSecretStore{Err: fmt.Errorf(
+			"the SCIM bearer tokens and OIDC client secrets cannot be shared through %s, keep the setup in Vault with --%s instead",
+			backend, flagVaultPath)}, nil
+	}
+
+	cipher, err := fileCipher(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	store := config.NewFileSecretStore(nextToConfig(v, secretsFile))
+	store.Cipher = cipher
+	return store, nil
+}
+
+// fileCipher returns the cipher of the state and secrets files, nil when they are not encrypted
+// The key is read from --state-key-file or derived from the STATE_PASSPHRASE environment variable
+// The S3 state is not encrypted, so a key is rejected with --state-backend rather than leaving the state in plaintext
+func fileCipher(cmd *cobra.Command, v *viper.Viper) (*config.FileCipher, error) {
+	v.BindEnv("state_passphrase", "STATE_PASSPHRASE")
+	cipher, err := newFileCipher(cmd, v, flagStateKeyFile, "state_passphrase")
+	if err != nil {
+		return nil, err
+	}
+
+	if backend, _ := cmd.Flags().GetString(flagStateBackend); backend != "" && cipher != nil {
+		return nil, fmt.Errorf("the state kept in %s is not encrypted, unset STATE_PASSPHRASE and --%s and rely on the encryption of the bucket", backend, flagStateKeyFile)
+	}
+	return cipher, nil
+}
+
+func newFileCipher(cmd *cobra.Command, v *viper.Viper, keyFileFlag, passphraseKey string) (*config.FileCipher, error) {
+	keyFile, _ := cmd.Flags().GetString(keyFileFlag)
+	passphrase := v.GetString(passphraseKey)
+
+	switch {
+	case keyFile != "" && passphrase != "":
+		return nil, fmt.Errorf("both --%s and %s are set, use only one of them", keyFileFlag, strings.ToUpper(passphraseKey))
+	case keyFile != "":
+		return config.NewKeyFileCipher(keyFile)
+	case passphrase != "":
+		return config.NewPassphraseCipher(passphrase)
+	default:
+		return nil, nil
+	}
+}
+
+// confProviders returns the function building the provider of the setup input and result of a tenant, "" is the setup without tenants
+// The setup lives in Vault when --vault-path or vault.path is given, in the config and state files otherwise
+func confProviders(cmd *cobra.Command, v *viper.Viper) (func(tenant string) setup.SetupConfig, error) {
+	client, err := vaultClient(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if client != nil {
+		return func(tenant string) setup.SetupConfig {
+			if tenant == "" {
+				return config.NewVaultConfigProvider(client, client.Conf.Path)
+			}
+			return config.NewVaultTenantConfigProvider(client, client.Conf.Path, tenant)
+		}, nil
+	}
+
+	state, err := stateStore(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	return func(tenant string) setup.SetupConfig {
+		if tenant == "" {
+			return config.NewViperConfigProvider(state)
+		}
+		return config.NewViperTenantConfigProvider(state, tenant)
+	}, nil
+}
+
+// vaultClient returns the client of the Vault holding the setup, nil when the setup is not in Vault
+func vaultClient(cmd *cobra.Command, v *viper.Viper) (*config.VaultClient, error) {
+	path, _ := cmd.Flags().GetString(flagVaultPath)
+	if path == "" && v.GetString("vault.path") == "" {
+		return nil, nil
+	}
+
+	conf, err := config.NewVaultConf(v)
+	if err != nil {
+		return nil, fmt.Errorf("error loading Vault configs %w", err)
+	}
+	if path != "" {
+		conf.Path = path
+	}
+	return config.NewVaultClient(conf), nil
+}
+
+// clientConfig reads the credentials of the Stytch and IdP clients from the environment
+// When the setup lives in Vault, the clients secret provides the variables that are not set
+func clientConfig(cmd *cobra.Command, v *viper.Viper) (*config.ClientsConf, error) {
+	client, err := vaultClient(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientConf *config.ClientsConf
+	if client != nil {
+		clientConf, err = config.NewVaultClientConfig(context.Background(), client, client.Conf.Path, v)
+	} else {
+		clientConf, err = config.NewClientConfig(v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading client configs, did you forget to set environement varaibles? %s", err)
+	}
+	return clientConf, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagFinalize      = "finalize"
+	flagValidityYears = "validity-years"
+)
+
+// rotateCertCmd represents the setup rotate-cert command
+var rotateCertCmd = &cobra.Command{
+	Use:   "rotate-cert",
+	Short: "Rotate the signing certificate of the IdP application",
+	Long: `Rotate generates a new signing key on the IdP application recorded in the setup result,
+adds its certificate to the Stytch connection, switches the application to the new key
+and pushes the refreshed metadata to Stytch.
+
+The previous certificate stays trusted by Stytch until you run it again with --finalize.`,
+	RunE: RunRotateCert,
+}
+
+func RunRotateCert(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if finalize, _ := cmd.Flags().GetBool(flagFinalize); finalize {
+		removed, err := bootstraper.FinalizeCertificateRotation(ctx)
+		if err != nil {
+			return err
+		}
+		cmd.Printf("Removed %d previous certificate(s) from the Stytch connection\n", removed)
+		return nil
+	}
+
+	validityYears, _ := cmd.Flags().GetInt(flagValidityYears)
+	kid, err := bootstraper.RotateCertificate(ctx, validityYears)
+	if err != nil {
+		return err
+	}
+
+	cmd.Printf("Signing key %s is now active, run with --%s once logins are confirmed\n", kid, flagFinalize)
+	return nil
+}
+
+func init() {
+	setupCmd.AddCommand(rotateCertCmd)
+
+	rotateCertCmd.Flags().Bool(flagFinalize, false, "Remove the previous certificates from the Stytch connection")
+	rotateCertCmd.Flags().Int(flagValidityYears, 2, "Validity of the new certificate in years (2 to 10)")
+}
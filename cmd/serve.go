@@ -9,7 +9,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/b2bstytchapi"
-	"github.com/xNok/go-stytch-demo/pkg/config"
 	"github.com/xNok/go-stytch-demo/pkg/server"
 )
 
@@ -25,9 +24,9 @@ see https://stytch.com/docs/b2b/guides/sso/backend`,
 func RunServe(cmd *cobra.Command, args []string) error {
 	v := viper.GetViper()
 
-	clientConf, err := config.NewClientConfig(v)
+	clientConf, err := clientConfig(cmd, v)
 	if err != nil {
-		return fmt.Errorf("error loading client configs, did you forget to set environement varaibles? %s", err)
+		return err
 	}
 
 	// Step 1: Instanciate stytch client
@@ -39,15 +38,20 @@ func RunServe(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("error instantiating API client %s", err)
 	}
 
-	conf, err := config.NewSetupResult(v)
+	providers, err := confProviders(cmd, v)
+	if err != nil {
+		return err
+	}
+	conf, err := providers("").Load()
 	if err != nil {
 		return fmt.Errorf("error reading config. Did you complete the setup? %s", err)
 	}
 
 	server.Serve(stytchClient, &server.StytchServerConfig{
-		OrganizationID: conf.OrganizationID,
-		ConnectionID:   conf.ConnectionID,
-		PublicToken:    clientConf.StytchConf.PublicToken,
+		OrganizationID:         conf.OrganizationID,
+		ConnectionID:           conf.ConnectionID,
+		PublicToken:            clientConf.StytchConf.PublicToken,
+		SessionDurationMinutes: conf.Settings.SessionDurationMinutes,
 	})
 
 	return nil
@@ -5,22 +5,50 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/okta/okta-sdk-golang/v4/okta"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/b2bstytchapi"
 	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
 	"github.com/xNok/go-stytch-demo/pkg/setup"
 )
 
+const (
+	flagPlan     = "plan"
+	flagIdP      = "idp"
+	flagProtocol = "protocol"
+
+	flagIdPMetadata = "idp-metadata"
+	flagIdPEntityID = "idp-entity-id"
+
+	flagTenant      = "tenant"
+	flagConcurrency = "concurrency"
+
+	flagFromStep = "from-step"
+	flagOnlyStep = "only-step"
+
+	idpOkta  = "okta"
+	idpEntra = "entra"
+
+	// secretsFile is written next to the config file, it holds the credentials created by setup
+	secretsFile = "setup.secrets.json"
+)
+
 // setupCmd represents the setup command
 var setupCmd = &cobra.Command{
 	Use:   "setup",
 	Short: "A utility script to create the SAML connection between stycth and okta",
 	Long: `This setup will create a new stych organisation and connection,
-Then create a new okta application and and finally proceed with the SAML metadata exchange.`,
+Then create a new okta application and and finally proceed with the SAML metadata exchange.
+
+Use --idp entra to create a Microsoft Entra ID enterprise application instead of an Okta one.
+Use --protocol oidc to create an OIDC connection and an Okta OIDC web application instead.
+Use --idp-metadata <file|url> when you only have the metadata document of the customer's IdP.`,
 	RunE: RunSetup,
 }
 
@@ -28,32 +56,205 @@ func RunSetup(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
 	v := viper.GetViper()
 
-	clientConf, err := config.NewClientConfig(v)
+	bootstraper, providers, err := newRootBootstraper(cmd, v)
+	if err != nil {
+		return err
+	}
+
+	bootstraper.FromStep, _ = cmd.Flags().GetString(flagFromStep)
+	bootstraper.OnlyStep, _ = cmd.Flags().GetString(flagOnlyStep)
+
+	plan, _ := cmd.Flags().GetBool(flagPlan)
+	if !plan {
+		unlock, err := lockState(cmd, v)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	tenants, err := selectedTenants(cmd, v)
+	if err != nil {
+		return err
+	}
+	if len(tenants) == 0 {
+		if plan {
+			return bootstraper.Plan(ctx, cmd.OutOrStdout())
+		}
+		err := bootstraper.Setup(ctx)
+		var stepErr *setup.StepError
+		if errors.As(err, &stepErr) && stepErr.Retryable {
+			cmd.PrintErrf("step %s failed on a transient error, run setup again to resume\n", stepErr.Step)
+		}
+		return err
+	}
+
+	// Multi-tenant bootstrap, every tenant persists its results under tenants.<name>
+	bootstrapers := map[string]*setup.OktaSAMLConnectionBootstraper{}
+	for _, tenant := range tenants {
+		bootstrapers[tenant] = forTenant(bootstraper, providers(tenant), tenant)
+	}
+
+	if plan {
+		for _, tenant := range tenants {
+			cmd.Printf("## tenant %s\n\n", tenant)
+			if err := bootstrapers[tenant].Plan(ctx, cmd.OutOrStdout()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	concurrency, _ := cmd.Flags().GetInt(flagConcurrency)
+	errs := setup.SetupTenants(ctx, bootstrapers, concurrency)
+	for _, tenant := range tenants {
+		if err, failed := errs[tenant]; failed {
+			cmd.Printf("tenant %s: FAILED %s\n", tenant, err)
+			continue
+		}
+		cmd.Printf("tenant %s: OK\n", tenant)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d tenant(s) failed, run setup again to resume them", len(errs), len(tenants))
+	}
+	return nil
+}
+
+// selectedTenants returns the tenants given with --tenant, or every tenant of the config file or of Vault
+func selectedTenants(cmd *cobra.Command, v *viper.Viper) ([]string, error) {
+	if tenants, _ := cmd.Flags().GetStringSlice(flagTenant); len(tenants) > 0 {
+		return tenants, nil
+	}
+
+	client, err := vaultClient(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		return config.VaultTenantNames(context.Background(), client, client.Conf.Path)
+	}
+	return config.TenantNames(v), nil
+}
+
+// newBootstraper instantiate the bootstraper of the setup commands working on a single setup
+// It works on the selected tenant, and fails when several tenants are selected so it never acts on the wrong one
+func newBootstraper(cmd *cobra.Command, v *viper.Viper) (*setup.OktaSAMLConnectionBootstraper, error) {
+	bootstraper, providers, err := newRootBootstraper(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := selectedTenants(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	switch len(tenants) {
+	case 0:
+		return bootstraper, nil
+	case 1:
+		return forTenant(bootstraper, providers(tenants[0]), tenants[0]), nil
+	default:
+		return nil, fmt.Errorf("%s works on a single tenant, select one of %s with --%s",
+			cmd.CommandPath(), strings.Join(tenants, ", "), flagTenant)
+	}
+}
+
+// newRootBootstraper instantiate the Stytch and IdP clients shared by the setup commands
+// The bootstraper works on the setup without tenants, the providers build the one of each tenant
+func newRootBootstraper(cmd *cobra.Command, v *viper.Viper) (*setup.OktaSAMLConnectionBootstraper, func(tenant string) setup.SetupConfig, error) {
+	bootstraper, err := newIdPBootstraper(cmd, v)
 	if err != nil {
-		return fmt.Errorf("error loading client configs, did you forget to set environement varaibles? %s", err)
+		return nil, nil, err
 	}
 
+	providers, err := confProviders(cmd, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	bootstraper.ConfProvider = providers("")
+	bootstraper.Protocol, _ = cmd.Flags().GetString(flagProtocol)
+	bootstraper.Journal = journal.New(journalPath(v))
+	bootstraper.Secrets, err = secretStore(cmd, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bootstraper, providers, nil
+}
+
+// forTenant returns a bootstraper working on the tenant setup given by provider
+func forTenant(bootstraper *setup.OktaSAMLConnectionBootstraper, provider setup.SetupConfig, tenant string) *setup.OktaSAMLConnectionBootstraper {
+	tenantBootstraper := bootstraper.ForTenant(provider)
+	tenantBootstraper.Journal = bootstraper.Journal.WithTenant(tenant)
+	return tenantBootstraper
+}
+
+func newIdPBootstraper(cmd *cobra.Command, v *viper.Viper) (*setup.OktaSAMLConnectionBootstraper, error) {
+	clientConf, err := clientConfig(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+
+	retryConf, err := config.NewRetryConf(v)
+	if err != nil {
+		return nil, err
+	}
+
+	bootstraper, err := newClientsBootstraper(cmd, clientConf)
+	if err != nil {
+		return nil, err
+	}
+	bootstraper.Retry = retryConf
+	return bootstraper, nil
+}
+
+func newClientsBootstraper(cmd *cobra.Command, clientConf *config.ClientsConf) (*setup.OktaSAMLConnectionBootstraper, error) {
+	// Every client shares an HTTP client that lets the retry policy read rate limit headers
+	httpClient := setup.NewHTTPClient()
+
 	// Step 1: Instanciate stytch client
+	// setup never authenticates sessions, so there is no need to fetch the JWKS
 	stytchClient, err := b2bstytchapi.NewClient(
 		clientConf.StytchConf.ProjectID,
 		clientConf.StytchConf.Secret,
+		b2bstytchapi.WithSkipJWKSInitialization(),
+		b2bstytchapi.WithHTTPClient(httpClient),
 	)
 	if err != nil {
-		return fmt.Errorf("error instantiating API client %s", err)
+		return nil, fmt.Errorf("error instantiating API client %s", err)
 	}
 
-	// Step 2: Instanciate Okta client
-	oktaConfig, err := okta.NewConfiguration(
-		okta.WithOrgUrl(clientConf.OktaConf.OrgUrl),
-		okta.WithToken(clientConf.OktaConf.APIToken),
-	)
-	if err != nil {
-		return fmt.Errorf("error instantiating Okta API client %s", err)
+	// Step 2: Instanciate the IdP client
+	// A metadata document replaces any IdP API, the customer's admin configures the application
+	if source, _ := cmd.Flags().GetString(flagIdPMetadata); source != "" {
+		provider := setup.NewMetadataProvider(source, cmd.OutOrStdout())
+		provider.EntityID, _ = cmd.Flags().GetString(flagIdPEntityID)
+		return setup.NewSAMLConnectionBootstraper(stytchClient, provider), nil
 	}
-	oktaClient := okta.NewAPIClient(oktaConfig)
 
-	bootstraper := setup.NewOktaSAMLConnectionBootstraper(stytchClient, oktaClient)
-	return bootstraper.Setup(ctx)
+	idp, _ := cmd.Flags().GetString(flagIdP)
+	switch idp {
+	case idpOkta:
+		oktaConfig, err := okta.NewConfiguration(
+			okta.WithOrgUrl(clientConf.OktaConf.OrgUrl),
+			okta.WithToken(clientConf.OktaConf.APIToken),
+			okta.WithHttpClientPtr(httpClient),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error instantiating Okta API client %s", err)
+		}
+		oktaClient := okta.NewAPIClient(oktaConfig)
+
+		return setup.NewOktaSAMLConnectionBootstraper(stytchClient, oktaClient), nil
+	case idpEntra:
+		if clientConf.EntraConf == nil {
+			return nil, fmt.Errorf("error loading Entra ID configs, did you forget to set ENTRA_TENANT_ID, ENTRA_CLIENT_ID and ENTRA_CLIENT_SECRET?")
+		}
+
+		return setup.NewSAMLConnectionBootstraper(stytchClient, setup.NewEntraProvider(clientConf.EntraConf)), nil
+	default:
+		return nil, fmt.Errorf("unknown identity provider %q, expected %s or %s", idp, idpOkta, idpEntra)
+	}
 }
 
 func init() {
@@ -68,4 +269,14 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// setupCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	setupCmd.PersistentFlags().String(flagIdP, idpOkta, "Identity provider to connect to Stytch (okta or entra)")
+	setupCmd.PersistentFlags().String(flagProtocol, setup.ProtocolSAML, "SSO protocol of the connection (saml or oidc), oidc requires --idp okta")
+	setupCmd.PersistentFlags().String(flagIdPMetadata, "", "IdP metadata XML file or URL, for IdPs without API access (ADFS, PingFederate, Shibboleth...)")
+	setupCmd.PersistentFlags().String(flagIdPEntityID, "", "entityID of the IdP when the metadata document lists several entities")
+	setupCmd.PersistentFlags().StringSlice(flagTenant, nil, "Tenant(s) of the tenants section to work on, setup defaults to all of them")
+	setupCmd.Flags().Int(flagConcurrency, 4, "Number of tenants bootstrapped concurrently")
+	setupCmd.Flags().String(flagFromStep, "", "Re-run this step and all the following ones, one of "+strings.Join(setup.StepNames, ", "))
+	setupCmd.Flags().String(flagOnlyStep, "", "Re-run only this step, the previous steps must be done")
+	setupCmd.MarkFlagsMutuallyExclusive(flagFromStep, flagOnlyStep)
+	setupCmd.Flags().Bool(flagPlan, false, "Print the steps and payloads setup would send without calling any mutating API")
 }
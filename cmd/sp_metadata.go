@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xNok/go-stytch-demo/pkg/setup"
+)
+
+const (
+	flagOutput       = "output"
+	flagNameIDFormat = "name-id-format"
+)
+
+// spMetadataCmd represents the setup sp-metadata command
+var spMetadataCmd = &cobra.Command{
+	Use:   "sp-metadata",
+	Short: "Export the SAML SP metadata of the Stytch connection",
+	Long: `Sp-metadata writes a standard SAML metadata document describing the Stytch connection
+(entity ID, ACS URL, NameID format and requested attributes) for customers whose IdP
+cannot be configured through an API. Hand it to the customer's admin, then run setup
+with --idp-metadata once you receive the metadata of their IdP.
+
+The Stytch connection must exist, the document is written to stdout unless --output is set.`,
+	SilenceUsage: true,
+	RunE:         RunSPMetadata,
+}
+
+func RunSPMetadata(cmd *cobra.Command, args []string) error {
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	nameIDFormat, _ := cmd.Flags().GetString(flagNameIDFormat)
+	metadata, err := bootstraper.SPMetadata(nameIDFormat)
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString(flagOutput)
+	if output == "" || output == "-" {
+		_, err := cmd.OutOrStdout().Write(metadata)
+		return err
+	}
+
+	if err := os.WriteFile(output, metadata, 0644); err != nil {
+		return fmt.Errorf("error writing SP metadata %w", err)
+	}
+	cmd.PrintErrf("SP metadata written to %s\n", output)
+	return nil
+}
+
+func init() {
+	setupCmd.AddCommand(spMetadataCmd)
+
+	spMetadataCmd.Flags().StringP(flagOutput, "o", "", "File to write the metadata to, stdout by default")
+	spMetadataCmd.Flags().String(flagNameIDFormat, setup.NameIDFormatEmail, "NameID format requested from the IdP")
+}
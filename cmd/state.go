@@ -0,0 +1,302 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+const (
+	flagLockTimeout = "lock-timeout"
+	flagForce       = "force"
+	flagNewKeyFile  = "new-key-file"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manage the state file holding the setup results",
+	Long: `The commands changing Stytch or the IdP (setup, setup destroy, setup adopt,
+setup rotate-cert and config) lock the state file while they run, so two runs never
+overwrite each other's results. Use --lock-timeout to wait for the other run instead
+of failing right away.
+
+With --state-backend s3://bucket/key the state is shared by every machine using that
+bucket, the lock is then the key.lock object. With --vault-path the lock is the lock
+secret under that path.`,
+}
+
+// stateUnlockCmd represents the state unlock command
+var stateUnlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Release the lock of a run that is gone",
+	Long: `Unlock prints the run holding the lock of the state file and, with --force, removes the lock.
+
+Only force the unlock when that run crashed or was killed: if it is still running,
+both runs may overwrite each other's results.`,
+	SilenceUsage: true,
+	RunE:         RunStateUnlock,
+}
+
+// stateEncryptCmd represents the state encrypt command
+var stateEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt the state and secrets files",
+	Long: `Encrypt rewrites the state file and setup.secrets.json with AES-GCM.
+
+The key is derived from the STATE_PASSPHRASE environment variable with scrypt, or read
+from the file given with --state-key-file (32 bytes encoded in base64, e.g. generated with
+openssl rand -base64 32). Every command then needs the same passphrase or key file.`,
+	SilenceUsage: true,
+	RunE:         RunStateEncrypt,
+}
+
+// stateDecryptCmd represents the state decrypt command
+var stateDecryptCmd = &cobra.Command{
+	Use:          "decrypt",
+	Short:        "Decrypt the state and secrets files back to plaintext",
+	SilenceUsage: true,
+	RunE:         RunStateDecrypt,
+}
+
+// stateRekeyCmd represents the state rekey command
+var stateRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Encrypt the state and secrets files with a new passphrase or key file",
+	Long: `Rekey decrypts the state file and setup.secrets.json with the current passphrase or key file,
+then encrypts them with the NEW_STATE_PASSPHRASE environment variable or the file given with --new-key-file.`,
+	SilenceUsage: true,
+	RunE:         RunStateRekey,
+}
+
+// stateVersionsCmd represents the state versions command
+var stateVersionsCmd = &cobra.Command{
+	Use:          "versions",
+	Short:        "List the previous states kept by the S3 backend",
+	SilenceUsage: true,
+	RunE:         RunStateVersions,
+}
+
+// stateRollbackCmd represents the state rollback command
+var stateRollbackCmd = &cobra.Command{
+	Use:   "rollback <version>",
+	Short: "Replace the state with one of its previous versions",
+	Long: `Rollback replaces the state kept in S3 with one of the versions listed by state versions,
+under the state lock. The state it replaces is kept as a new version, so a rollback can be undone.
+
+Only the results are rolled back: the resources created in Stytch or the IdP since that version
+are not deleted.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE:         RunStateRollback,
+}
+
+func RunStateVersions(cmd *cobra.Command, args []string) error {
+	store, err := versionedStateStore(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	versions, err := store.Versions()
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		cmd.Printf("state %s has no previous version\n", store.Location())
+	}
+	for _, version := range versions {
+		cmd.Println(version)
+	}
+	return nil
+}
+
+func RunStateRollback(cmd *cobra.Command, args []string) error {
+	v := viper.GetViper()
+	store, err := versionedStateStore(cmd, v)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockState(cmd, v)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := store.Rollback(args[0]); err != nil {
+		return err
+	}
+	cmd.Printf("Rolled back %s to %s\n", store.Location(), args[0])
+	return nil
+}
+
+// versionedStateStore returns the state store, which must keep the previous states
+func versionedStateStore(cmd *cobra.Command, v *viper.Viper) (config.VersionedStateStore, error) {
+	store, err := stateStore(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	versioned, ok := store.(config.VersionedStateStore)
+	if !ok {
+		return nil, fmt.Errorf("only the S3 backend keeps the previous states, not %s", store.Location())
+	}
+	return versioned, nil
+}
+
+func RunStateEncrypt(cmd *cobra.Command, args []string) error {
+	cipher, err := requireFileCipher(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+	return reencryptState(cmd, viper.GetViper(), cipher, cipher, "Encrypted")
+}
+
+func RunStateDecrypt(cmd *cobra.Command, args []string) error {
+	cipher, err := requireFileCipher(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+	return reencryptState(cmd, viper.GetViper(), cipher, nil, "Decrypted")
+}
+
+func RunStateRekey(cmd *cobra.Command, args []string) error {
+	v := viper.GetViper()
+	cipher, err := requireFileCipher(cmd, v)
+	if err != nil {
+		return err
+	}
+
+	v.BindEnv("new_state_passphrase", "NEW_STATE_PASSPHRASE")
+	newCipher, err := newFileCipher(cmd, v, flagNewKeyFile, "new_state_passphrase")
+	if err != nil {
+		return err
+	}
+	if newCipher == nil {
+		return fmt.Errorf("no new key, set NEW_STATE_PASSPHRASE or --%s", flagNewKeyFile)
+	}
+	return reencryptState(cmd, v, cipher, newCipher, "Rekeyed")
+}
+
+// requireFileCipher returns the cipher of the state and secrets files, which must be configured
+func requireFileCipher(cmd *cobra.Command, v *viper.Viper) (*config.FileCipher, error) {
+	cipher, err := fileCipher(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	if cipher == nil {
+		return nil, fmt.Errorf("no key, set STATE_PASSPHRASE or --%s", flagStateKeyFile)
+	}
+	return cipher, nil
+}
+
+// reencryptState rewrites the state and secrets files opened with from and sealed with to, under the state lock
+func reencryptState(cmd *cobra.Command, v *viper.Viper, from, to *config.FileCipher, done string) error {
+	store, err := stateStore(cmd, v)
+	if err != nil {
+		return err
+	}
+	fileStore, ok := store.(*config.FileStateStore)
+	if !ok {
+		return fmt.Errorf("only a local state file can be encrypted, not %s", store.Location())
+	}
+
+	unlock, err := lockState(cmd, v)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, path := range []string{fileStore.Path, nextToConfig(v, secretsFile)} {
+		err := config.ReencryptFile(path, from, to)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		cmd.Printf("%s %s\n", done, path)
+	}
+	return nil
+}
+
+func RunStateUnlock(cmd *cobra.Command, args []string) error {
+	store, err := stateLocker(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	holder, err := store.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		cmd.Printf("state %s is not locked\n", store.Location())
+		return nil
+	}
+
+	if force, _ := cmd.Flags().GetBool(flagForce); !force {
+		return fmt.Errorf("state %s is locked by %s, run again with --%s if that run is gone", store.Location(), holder, flagForce)
+	}
+
+	if _, err := store.ForceUnlock(); err != nil {
+		return err
+	}
+	cmd.Printf("Removed the lock of %s held by %s\n", store.Location(), holder)
+	return nil
+}
+
+// lockState takes the lock of the state file for the running command, the returned function releases it
+func lockState(cmd *cobra.Command, v *viper.Viper) (func(), error) {
+	timeout, _ := cmd.Flags().GetDuration(flagLockTimeout)
+
+	store, err := stateLocker(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := store.Lock(context.Background(), strings.Join(os.Args, " "), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		if err := unlock(); err != nil {
+			cmd.PrintErrf("error releasing the state lock %s\n", err)
+		}
+	}, nil
+}
+
+// stateLocker returns the lock of the setup, kept in Vault when the setup lives there
+func stateLocker(cmd *cobra.Command, v *viper.Viper) (config.StateLocker, error) {
+	client, err := vaultClient(cmd, v)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		return config.NewVaultLock(client, client.Conf.Path), nil
+	}
+	return stateStore(cmd, v)
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateUnlockCmd)
+	stateCmd.AddCommand(stateEncryptCmd)
+	stateCmd.AddCommand(stateDecryptCmd)
+	stateCmd.AddCommand(stateRekeyCmd)
+	stateCmd.AddCommand(stateVersionsCmd)
+	stateCmd.AddCommand(stateRollbackCmd)
+
+	rootCmd.PersistentFlags().Duration(flagLockTimeout, 0, "How long to wait for another run to release the state lock")
+	stateUnlockCmd.Flags().Bool(flagForce, false, "Remove the lock even though its holder may still be running")
+	stateRekeyCmd.Flags().String(flagNewKeyFile, "", "new key file, instead of the NEW_STATE_PASSPHRASE environment variable")
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2024 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// verifyCmd represents the setup verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify reports drift between the Stytch connection and the IdP application",
+	Long: `Verify loads the setup result, fetches the current Stytch SAML connection,
+the IdP application settings and its SAML metadata, then reports field by field drift:
+ACS URL, audience, IdP entity ID, SSO URL, certificate, attribute mapping and attribute statements.
+
+The command exits with a non-zero status when anything differs, so it can run on a schedule.`,
+	SilenceUsage: true,
+	RunE:         RunVerify,
+}
+
+func RunVerify(cmd *cobra.Command, args []string) error {
+	ctx := context.Background()
+
+	bootstraper, err := newBootstraper(cmd, viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	drifts, err := bootstraper.Verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(drifts) == 0 {
+		cmd.Println("No drift detected")
+		return nil
+	}
+
+	for _, drift := range drifts {
+		cmd.Println(drift)
+	}
+
+	return fmt.Errorf("%d drifted field(s) detected", len(drifts))
+}
+
+func init() {
+	setupCmd.AddCommand(verifyCmd)
+}
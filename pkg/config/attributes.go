@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NameID is the special attribute name for the SAML subject, it is not sent as an attribute statement
+const NameID = "NameID"
+
+// Okta namespaces of attribute statements
+const (
+	NamespaceBasic       = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	NamespaceUnspecified = "urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"
+)
+
+// SAMLAttribute describes one attribute sent by the IdP and the Stytch member field it maps to
+// It is either an Okta expression (user.firstName) or a group filter, never both
+type SAMLAttribute struct {
+	// Name of the attribute in the SAML assertion
+	Name string `mapstructure:"name"`
+	// StytchField is the key in the Stytch connection attribute mapping (email, first_name, groups...)
+	StytchField string `mapstructure:"stytch_field"`
+	Namespace   string `mapstructure:"namespace"`
+	// Expression is an Okta expression language value
+	// ref: https://developer.okta.com/docs/reference/okta-expression-language/
+	Expression  string       `mapstructure:"expression"`
+	GroupFilter *GroupFilter `mapstructure:"group_filter"`
+}
+
+// GroupFilter selects the groups sent in a group attribute statement
+type GroupFilter struct {
+	// Type is one of STARTS_WITH, EQUALS, CONTAINS or REGEX
+	Type  string `mapstructure:"type"`
+	Value string `mapstructure:"value"`
+}
+
+var groupFilterTypes = map[string]bool{
+	"STARTS_WITH": true,
+	"EQUALS":      true,
+	"CONTAINS":    true,
+	"REGEX":       true,
+}
+
+// DefaultSAMLAttributes is the mapping used when setup.yaml has no attributes section
+func DefaultSAMLAttributes() []SAMLAttribute {
+	return []SAMLAttribute{
+		{Name: NameID, StytchField: "email"},
+		{Name: "firstName", StytchField: "first_name", Expression: "user.firstName"},
+		{Name: "lastName", StytchField: "last_name", Expression: "user.lastName"},
+		// This allows us to use implicit group assignements
+		// ref: https://stytch.com/docs/b2b/guides/rbac/role-assignment
+		{Name: "groups", StytchField: "groups", GroupFilter: &GroupFilter{Type: "REGEX", Value: ".*billing.*"}},
+	}
+}
+
+// ValidateSAMLAttributes ensures every Stytch mapping has a corresponding IdP statement
+func ValidateSAMLAttributes(attributes []SAMLAttribute) error {
+	var errs []error
+	names := map[string]bool{}
+	fields := map[string]bool{}
+
+	for i, attr := range attributes {
+		switch {
+		case attr.Name == "":
+			errs = append(errs, fmt.Errorf("attributes[%d]: name is required", i))
+			continue
+		case names[attr.Name]:
+			errs = append(errs, fmt.Errorf("attribute %s: defined more than once", attr.Name))
+		}
+		names[attr.Name] = true
+
+		if attr.StytchField != "" {
+			if fields[attr.StytchField] {
+				errs = append(errs, fmt.Errorf("attribute %s: stytch_field %s is already mapped", attr.Name, attr.StytchField))
+			}
+			fields[attr.StytchField] = true
+		}
+
+		if attr.Name == NameID {
+			if attr.Expression != "" || attr.GroupFilter != nil {
+				errs = append(errs, fmt.Errorf("attribute %s: the subject cannot have an expression or group_filter", attr.Name))
+			}
+			continue
+		}
+
+		switch {
+		case attr.Expression == "" && attr.GroupFilter == nil:
+			errs = append(errs, fmt.Errorf("attribute %s: an expression or a group_filter is required", attr.Name))
+		case attr.Expression != "" && attr.GroupFilter != nil:
+			errs = append(errs, fmt.Errorf("attribute %s: expression and group_filter are mutually exclusive", attr.Name))
+		case attr.GroupFilter != nil && !groupFilterTypes[attr.GroupFilter.Type]:
+			errs = append(errs, fmt.Errorf("attribute %s: unknown group_filter type %q", attr.Name, attr.GroupFilter.Type))
+		}
+	}
+
+	if !fields["email"] {
+		errs = append(errs, errors.New("attributes: Stytch requires a mapping for the email field"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// StytchAttributeMapping returns the attribute mapping of the Stytch SAML connection
+func StytchAttributeMapping(attributes []SAMLAttribute) map[string]any {
+	mapping := map[string]any{}
+	for _, attr := range attributes {
+		if attr.StytchField != "" {
+			mapping[attr.StytchField] = attr.Name
+		}
+	}
+	return mapping
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSAMLAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes []SAMLAttribute
+		wantErr    string
+	}{
+		{
+			name:       "default mapping",
+			attributes: DefaultSAMLAttributes(),
+		},
+		{
+			name: "custom expression and group filter",
+			attributes: []SAMLAttribute{
+				{Name: NameID, StytchField: "email"},
+				{Name: "department", StytchField: "department", Expression: "user.department"},
+				{Name: "groups", StytchField: "groups", GroupFilter: &GroupFilter{Type: "STARTS_WITH", Value: "app-"}},
+			},
+		},
+		{
+			name: "stytch mapping without statement",
+			attributes: []SAMLAttribute{
+				{Name: NameID, StytchField: "email"},
+				{Name: "employeeNumber", StytchField: "employee_id"},
+			},
+			wantErr: "attribute employeeNumber: an expression or a group_filter is required",
+		},
+		{
+			name: "invalid entries",
+			attributes: []SAMLAttribute{
+				{Name: "firstName", StytchField: "first_name", Expression: "user.firstName", GroupFilter: &GroupFilter{Type: "REGEX"}},
+				{Name: "groups", StytchField: "first_name", GroupFilter: &GroupFilter{Type: "LIKE"}},
+			},
+			wantErr: "attribute firstName: expression and group_filter are mutually exclusive\n" +
+				"attribute groups: stytch_field first_name is already mapped\n" +
+				"attribute groups: unknown group_filter type \"LIKE\"\n" +
+				"attributes: Stytch requires a mapping for the email field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSAMLAttributes(tt.attributes)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// fileEncryption marks the files written by a FileCipher
+	fileEncryption = "aes-256-gcm"
+	fileKeySize    = 32
+
+	kdfScrypt  = "scrypt"
+	kdfKeyFile = "key-file"
+
+	// scrypt parameters recommended for interactive logins, stored in each file so they can be raised later
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	// bounds of the parameters read from a file, so a tampered file cannot make the derivation exhaust the memory or CPU
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+)
+
+// encryptedFile is the content of a file encrypted by a FileCipher
+type encryptedFile struct {
+	Encryption string `json:"encryption"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt,omitempty"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// FileCipher encrypts the state and secrets files with AES-GCM
+// The key is derived from a passphrase with scrypt, or read from a key file
+type FileCipher struct {
+	passphrase []byte
+	key        []byte
+
+	// the key derived from the passphrase is reused for the files sealed by this process
+	mu          sync.Mutex
+	derivedSalt []byte
+	derivedKey  []byte
+}
+
+func NewPassphraseCipher(passphrase string) (*FileCipher, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	return &FileCipher{passphrase: []byte(passphrase)}, nil
+}
+
+// NewKeyFileCipher reads a key file holding 32 random bytes encoded in base64, e.g. generated with `openssl rand -base64 32`
+func NewKeyFileCipher(path string) (*FileCipher, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw)))
+	if err != nil || len(key) != fileKeySize {
+		return nil, fmt.Errorf("key file %s must hold %d bytes encoded in base64", path, fileKeySize)
+	}
+	return &FileCipher{key: key}, nil
+}
+
+// IsEncrypted tells whether raw is the content of a file encrypted by a FileCipher
+func IsEncrypted(raw []byte) bool {
+	var file encryptedFile
+	return json.Unmarshal(raw, &file) == nil && file.Encryption == fileEncryption
+}
+
+// Seal encrypts the content of a file
+func (c *FileCipher) Seal(plaintext []byte) ([]byte, error) {
+	file := &encryptedFile{Encryption: fileEncryption, KDF: kdfKeyFile}
+	key := c.key
+	if key == nil {
+		salt, derived, err := c.sealKey()
+		if err != nil {
+			return nil, err
+		}
+		file.KDF, file.Salt, file.N, file.R, file.P = kdfScrypt, salt, scryptN, scryptR, scryptP
+		key = derived
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return nil, err
+	}
+	file.Ciphertext = aead.Seal(nil, file.Nonce, plaintext, nil)
+
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+// Open decrypts the content of a file sealed with the same passphrase or key file
+func (c *FileCipher) Open(raw []byte) ([]byte, error) {
+	var file encryptedFile
+	if err := json.Unmarshal(raw, &file); err != nil || file.Encryption != fileEncryption {
+		return nil, errors.New("not an encrypted file")
+	}
+
+	var key []byte
+	switch {
+	case file.KDF == kdfKeyFile && c.key != nil:
+		key = c.key
+	case file.KDF == kdfScrypt && c.passphrase != nil:
+		derived, err := c.openKey(&file)
+		if err != nil {
+			return nil, err
+		}
+		key = derived
+	case file.KDF == kdfKeyFile:
+		return nil, errors.New("encrypted with a key file, not a passphrase")
+	case file.KDF == kdfScrypt:
+		return nil, errors.New("encrypted with a passphrase, not a key file")
+	default:
+		return nil, fmt.Errorf("unknown key derivation %q", file.KDF)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or key file, or the file was tampered with")
+	}
+	return plaintext, nil
+}
+
+// sealKey derives a key from the passphrase with a new salt, once per process
+func (c *FileCipher) sealKey() ([]byte, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.derivedKey != nil {
+		return c.derivedSalt, c.derivedKey, nil
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	key, err := scrypt.Key(c.passphrase, salt, scryptN, scryptR, scryptP, fileKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.derivedSalt, c.derivedKey = salt, key
+	return salt, key, nil
+}
+
+// openKey derives the key of a file, reusing the one of the files sealed by this process
+func (c *FileCipher) openKey(file *encryptedFile) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.derivedKey != nil && bytes.Equal(c.derivedSalt, file.Salt) {
+		return c.derivedKey, nil
+	}
+	if err := checkScryptParameters(file.N, file.R, file.P); err != nil {
+		return nil, err
+	}
+	return scrypt.Key(c.passphrase, file.Salt, file.N, file.R, file.P, fileKeySize)
+}
+
+// checkScryptParameters rejects the parameters beyond what a file sealed by this tool could use
+func checkScryptParameters(n, r, p int) error {
+	if n < 2 || n > maxScryptN || n&(n-1) != 0 || r < 1 || r > maxScryptR || p < 1 || p > maxScryptP ||
+		128*n*r > maxScryptMemory {
+		return fmt.Errorf("unsupported scrypt parameters n=%d r=%d p=%d", n, r, p)
+	}
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openFile returns the plaintext of a file read from path, a plaintext file is returned as is
+// A nil cipher can only read plaintext files
+func openFile(c *FileCipher, path string, raw []byte) ([]byte, error) {
+	if !IsEncrypted(raw) {
+		return raw, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf("%s is encrypted, give its passphrase with STATE_PASSPHRASE or its key file with --state-key-file", path)
+	}
+
+	plaintext, err := c.Open(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// sealFile returns the content to write, plaintext with a nil cipher
+func sealFile(c *FileCipher, plaintext []byte) ([]byte, error) {
+	if c == nil {
+		return plaintext, nil
+	}
+	return c.Seal(plaintext)
+}
+
+// ReencryptFile rewrites the file opened with from and sealed with to, either one is nil for a plaintext file
+// It fails with os.ErrNotExist when the file does not exist
+func ReencryptFile(path string, from, to *FileCipher) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := openFile(from, path, raw)
+	if err != nil {
+		return err
+	}
+	raw, err = sealFile(to, plaintext)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, raw)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCipher(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "state.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(make([]byte, fileKeySize))+"\n"), 0o600))
+	keyCipher, err := NewKeyFileCipher(keyFile)
+	require.NoError(t, err)
+	passphraseCipher, err := NewPassphraseCipher("correct horse battery staple")
+	require.NoError(t, err)
+
+	for _, c := range []*FileCipher{keyCipher, passphraseCipher} {
+		sealed, err := c.Seal([]byte(`{"secret": "value"}`))
+		require.NoError(t, err)
+		require.True(t, IsEncrypted(sealed))
+		require.NotContains(t, string(sealed), "value")
+
+		plaintext, err := c.Open(sealed)
+		require.NoError(t, err)
+		require.Equal(t, `{"secret": "value"}`, string(plaintext))
+	}
+
+	// Another process derives the key again from the salt of the file
+	sealed, err := passphraseCipher.Seal([]byte("state"))
+	require.NoError(t, err)
+	other, err := NewPassphraseCipher("correct horse battery staple")
+	require.NoError(t, err)
+	plaintext, err := other.Open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "state", string(plaintext))
+
+	wrong, err := NewPassphraseCipher("wrong")
+	require.NoError(t, err)
+	_, err = wrong.Open(sealed)
+	require.ErrorContains(t, err, "wrong passphrase")
+	_, err = keyCipher.Open(sealed)
+	require.ErrorContains(t, err, "encrypted with a passphrase")
+
+	// The scrypt parameters of a tampered file are checked before deriving the key
+	var file encryptedFile
+	require.NoError(t, json.Unmarshal(sealed, &file))
+	for _, params := range [][3]int{{1 << 30, 8, 1}, {1<<15 + 1, 8, 1}, {1 << 15, 1 << 10, 1}, {1 << 15, 8, 1 << 10}, {1 << 20, 32, 1}} {
+		file.N, file.R, file.P = params[0], params[1], params[2]
+		tampered, err := json.Marshal(&file)
+		require.NoError(t, err)
+		_, err = other.Open(tampered)
+		require.ErrorContains(t, err, "unsupported scrypt parameters")
+	}
+
+	require.NoError(t, os.WriteFile(keyFile, []byte("too short"), 0o600))
+	_, err = NewKeyFileCipher(keyFile)
+	require.Error(t, err)
+}
+
+func TestFileStateStore_Encrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StateFile)
+	result := &SetupResult{StytchResult: StytchResult{OrganizationID: "organization-test"}}
+
+	// A plaintext state is read then encrypted on the next write
+	require.NoError(t, saveResult(NewFileStateStore(path), "", result))
+	c, err := NewPassphraseCipher("passphrase-test")
+	require.NoError(t, err)
+	store := &FileStateStore{Path: path, Cipher: c}
+	state, err := store.Read()
+	require.NoError(t, err)
+	require.Equal(t, result, state.Result)
+
+	require.NoError(t, saveResult(store, "", result))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, IsEncrypted(raw))
+	require.NotContains(t, string(raw), "organization-test")
+
+	_, err = NewFileStateStore(path).Read()
+	require.ErrorContains(t, err, "is encrypted")
+
+	// Rekey then decrypt
+	rekeyed, err := NewPassphraseCipher("passphrase-test-new")
+	require.NoError(t, err)
+	require.NoError(t, ReencryptFile(path, c, rekeyed))
+	_, err = store.Read()
+	require.ErrorContains(t, err, "wrong passphrase")
+
+	require.NoError(t, ReencryptFile(path, rekeyed, nil))
+	state, err = NewFileStateStore(path).Read()
+	require.NoError(t, err)
+	require.Equal(t, result, state.Result)
+}
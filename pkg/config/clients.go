@@ -1,8 +1,11 @@
 package config
 
+import "time"
+
 type ClientsConf struct {
 	StytchConf *StytchConf `mapstructure:"STYTCH"`
 	OktaConf   *OktaConf   `mapstructure:"OKTA"`
+	EntraConf  *EntraConf  `mapstructure:"ENTRA"`
 }
 
 type StytchConf struct {
@@ -15,3 +18,19 @@ type OktaConf struct {
 	OrgUrl   string `mapstructure:"ORG_URL"`
 	APIToken string `mapstructure:"API_TOKEN"`
 }
+
+type EntraConf struct {
+	TenantID     string `mapstructure:"TENANT_ID"`
+	ClientID     string `mapstructure:"CLIENT_ID"`
+	ClientSecret string `mapstructure:"CLIENT_SECRET"`
+}
+
+// RetryConf is the retry policy of the API calls made during setup, read from the retry section of setup.yaml
+type RetryConf struct {
+	// MaxAttempts includes the first call, 1 disables retries
+	MaxAttempts int `mapstructure:"max_attempts"`
+	// InitialBackoff is doubled after each attempt up to MaxBackoff
+	// A Retry-After or X-Rate-Limit-Reset header sent by the API takes precedence
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
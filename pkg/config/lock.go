@@ -0,0 +1,187 @@
+package config
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is how often Lock checks whether the holder released the lock
+const lockPollInterval = 500 * time.Millisecond
+
+// LockInfo describes the run holding the lock of a state, it is the content of the lock file
+type LockInfo struct {
+	ID      string    `json:"id"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (l *LockInfo) String() string {
+	return fmt.Sprintf("%q on %s (pid %d) since %s", l.Command, l.Host, l.PID, l.Started.Format(time.RFC3339))
+}
+
+// StateLockedError is returned by Lock when another run still holds the lock after the timeout
+type StateLockedError struct {
+	Path string
+	// Holder is nil when the lock file could not be read
+	Holder *LockInfo
+}
+
+func (e *StateLockedError) Error() string {
+	holder := "another run"
+	if e.Holder != nil {
+		holder = e.Holder.String()
+	}
+	return fmt.Sprintf("state %s is locked by %s, wait for it to complete or run `state unlock --force` if it is gone", e.Path, holder)
+}
+
+// LockPath is the lock file of the state, it exists while a run holds the lock
+func (s *FileStateStore) LockPath() string {
+	return s.Path + ".lock"
+}
+
+// Lock takes the advisory lock of the state for command, waiting up to timeout for the holder to release it
+// It returns the function releasing the lock, a lock forced open by someone else is left alone
+func (s *FileStateStore) Lock(ctx context.Context, command string, timeout time.Duration) (func() error, error) {
+	return waitLock(ctx, s, s.unlock, command, timeout, func(raw []byte) (bool, error) {
+		err := s.createLock(raw)
+		if errors.Is(err, os.ErrExist) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// waitLock calls create with the lock content until it takes the lock, create returns false while another run holds it
+// The function returned calls unlock with the ID of the lock
+func waitLock(ctx context.Context, store StateLocker, unlock func(id string) error, command string, timeout time.Duration, create func(raw []byte) (bool, error)) (func() error, error) {
+	info, err := newLockInfo(command)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := create(raw)
+		if err != nil {
+			return nil, fmt.Errorf("error locking state %w", err)
+		}
+		if locked {
+			return func() error { return unlock(info.ID) }, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			holder, _ := store.LockHolder()
+			return nil, &StateLockedError{Path: store.Location(), Holder: holder}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(lockPollInterval, time.Until(deadline))):
+		}
+	}
+}
+
+// LockHolder returns the run holding the lock, nil when the state is not locked
+func (s *FileStateStore) LockHolder() (*LockInfo, error) {
+	raw, err := os.ReadFile(s.LockPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state lock %w", err)
+	}
+
+	return decodeLockInfo(raw, s.LockPath())
+}
+
+// ForceUnlock removes the lock whoever holds it, it returns the holder or nil when the state was not locked
+// Only use it when the holder is gone (crashed, killed), otherwise both runs may overwrite each other
+func (s *FileStateStore) ForceUnlock() (*LockInfo, error) {
+	holder, err := s.LockHolder()
+	if holder == nil && err == nil {
+		return nil, nil
+	}
+
+	if err := os.Remove(s.LockPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return holder, fmt.Errorf("error removing state lock %w", err)
+	}
+	return holder, nil
+}
+
+// createLock fails with os.ErrExist when the lock file is already there
+// The holder is written to a temporary file linked into place, so the lock file is never seen empty or partial
+func (s *FileStateStore) createLock(raw []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.LockPath()), filepath.Base(s.LockPath())+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Link(tmp.Name(), s.LockPath())
+}
+
+func (s *FileStateStore) unlock(id string) error {
+	holder, err := s.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != id {
+		return nil
+	}
+
+	if err := os.Remove(s.LockPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing state lock %w", err)
+	}
+	return nil
+}
+
+func decodeLockInfo(raw []byte, path string) (*LockInfo, error) {
+	var info LockInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("error decoding state lock %s %w", path, err)
+	}
+	return &info, nil
+}
+
+func newLockInfo(command string) (*LockInfo, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &LockInfo{
+		ID:      hex.EncodeToString(id),
+		Host:    host,
+		PID:     os.Getpid(),
+		Command: command,
+		Started: time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
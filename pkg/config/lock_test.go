@@ -0,0 +1,99 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStateStore_Lock(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStateStore(filepath.Join(t.TempDir(), StateFile))
+
+	unlock, err := store.Lock(ctx, "setup", 0)
+	require.NoError(t, err)
+
+	holder, err := store.LockHolder()
+	require.NoError(t, err)
+	require.Equal(t, "setup", holder.Command)
+	require.Equal(t, os.Getpid(), holder.PID)
+
+	// A second run gives up once the timeout is reached
+	_, err = store.Lock(ctx, "setup destroy", 0)
+	var locked *StateLockedError
+	require.True(t, errors.As(err, &locked))
+	require.Equal(t, holder, locked.Holder)
+	require.ErrorContains(t, err, "state unlock --force")
+
+	// or takes the lock as soon as it is released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlock()
+	}()
+	unlockDestroy, err := store.Lock(ctx, "setup destroy", 5*time.Second)
+	require.NoError(t, err)
+
+	// A forced unlock lets another run in, the previous holder does not release its lock
+	forced, err := store.ForceUnlock()
+	require.NoError(t, err)
+	require.Equal(t, "setup destroy", forced.Command)
+	unlockConfig, err := store.Lock(ctx, "config", 0)
+	require.NoError(t, err)
+	require.NoError(t, unlockDestroy())
+
+	holder, err = store.LockHolder()
+	require.NoError(t, err)
+	require.Equal(t, "config", holder.Command)
+
+	require.NoError(t, unlockConfig())
+	holder, err = store.LockHolder()
+	require.NoError(t, err)
+	require.Nil(t, holder)
+
+	forced, err = store.ForceUnlock()
+	require.NoError(t, err)
+	require.Nil(t, forced)
+}
+
+func TestFileStateStore_LockConcurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, StateFile)
+
+	// The runs losing the race always find the holder of the lock, never a lock file being written
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := NewFileStateStore(path).Lock(ctx, "setup", 0)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	taken := 0
+	for err := range errs {
+		if err == nil {
+			taken++
+			continue
+		}
+		var locked *StateLockedError
+		require.True(t, errors.As(err, &locked), err)
+		require.NotNil(t, locked.Holder)
+	}
+	require.Equal(t, 1, taken)
+
+	// No temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// SessionDurationKey is the trusted metadata key of the organization holding SessionDurationMinutes
+const SessionDurationKey = "session_duration_minutes"
+
+// Bounds of the Stytch session_duration_minutes parameter
+const (
+	minSessionDurationMinutes = 5
+	maxSessionDurationMinutes = 525600
+)
+
+// OrganizationSettings are the organization policies applied by setup, empty fields keep the Stytch defaults
+// ref: https://stytch.com/docs/b2b/api/update-organization
+type OrganizationSettings struct {
+	EmailAllowedDomains []string `mapstructure:"email_allowed_domains"`
+	// EmailJITProvisioning is RESTRICTED (to EmailAllowedDomains) or NOT_ALLOWED
+	EmailJITProvisioning string `mapstructure:"email_jit_provisioning"`
+	// AuthMethods is ALL_ALLOWED or RESTRICTED to AllowedAuthMethods
+	AuthMethods        string   `mapstructure:"auth_methods"`
+	AllowedAuthMethods []string `mapstructure:"allowed_auth_methods"`
+	// SSOJITProvisioning is ALL_ALLOWED, RESTRICTED to the connection created by setup or NOT_ALLOWED
+	SSOJITProvisioning string `mapstructure:"sso_jit_provisioning"`
+	// MFAPolicy is OPTIONAL or REQUIRED_FOR_ALL
+	MFAPolicy string `mapstructure:"mfa_policy"`
+	// MFAMethods is ALL_ALLOWED or RESTRICTED to AllowedMFAMethods
+	MFAMethods        string   `mapstructure:"mfa_methods"`
+	AllowedMFAMethods []string `mapstructure:"allowed_mfa_methods"`
+	// SessionDurationMinutes is requested when members log in, Stytch has no organization setting for it
+	// so it is kept in the trusted metadata of the organization under SessionDurationKey
+	SessionDurationMinutes int32 `mapstructure:"session_duration_minutes"`
+}
+
+var (
+	emailJITProvisioningValues = map[string]bool{"RESTRICTED": true, "NOT_ALLOWED": true}
+	ssoJITProvisioningValues   = map[string]bool{"ALL_ALLOWED": true, "RESTRICTED": true, "NOT_ALLOWED": true}
+	restrictionValues          = map[string]bool{"ALL_ALLOWED": true, "RESTRICTED": true}
+	mfaPolicyValues            = map[string]bool{"OPTIONAL": true, "REQUIRED_FOR_ALL": true}
+	authMethodValues           = map[string]bool{"sso": true, "magic_link": true, "password": true, "google_oauth": true, "microsoft_oauth": true}
+	mfaMethodValues            = map[string]bool{"sms_otp": true, "totp": true}
+)
+
+// IsZero tells whether no setting is configured
+func (s *OrganizationSettings) IsZero() bool {
+	return reflect.ValueOf(*s).IsZero()
+}
+
+// ValidateOrganizationSettings checks the values accepted by Stytch before any call is made
+func ValidateOrganizationSettings(s *OrganizationSettings) error {
+	var errs []error
+	check := func(field, value string, allowed map[string]bool) {
+		if value != "" && !allowed[value] {
+			errs = append(errs, fmt.Errorf("settings.%s: unknown value %q", field, value))
+		}
+	}
+
+	check("email_jit_provisioning", s.EmailJITProvisioning, emailJITProvisioningValues)
+	check("auth_methods", s.AuthMethods, restrictionValues)
+	check("sso_jit_provisioning", s.SSOJITProvisioning, ssoJITProvisioningValues)
+	check("mfa_policy", s.MFAPolicy, mfaPolicyValues)
+	check("mfa_methods", s.MFAMethods, restrictionValues)
+	for _, method := range s.AllowedAuthMethods {
+		check("allowed_auth_methods", method, authMethodValues)
+	}
+	for _, method := range s.AllowedMFAMethods {
+		check("allowed_mfa_methods", method, mfaMethodValues)
+	}
+
+	if s.EmailJITProvisioning == "RESTRICTED" && len(s.EmailAllowedDomains) == 0 {
+		errs = append(errs, errors.New("settings.email_jit_provisioning: RESTRICTED requires email_allowed_domains"))
+	}
+	if (s.AuthMethods == "RESTRICTED") != (len(s.AllowedAuthMethods) > 0) {
+		errs = append(errs, errors.New("settings.allowed_auth_methods: required when auth_methods is RESTRICTED, and only then"))
+	}
+	if (s.MFAMethods == "RESTRICTED") != (len(s.AllowedMFAMethods) > 0) {
+		errs = append(errs, errors.New("settings.allowed_mfa_methods: required when mfa_methods is RESTRICTED, and only then"))
+	}
+	if s.SessionDurationMinutes != 0 && (s.SessionDurationMinutes < minSessionDurationMinutes || s.SessionDurationMinutes > maxSessionDurationMinutes) {
+		errs = append(errs, fmt.Errorf("settings.session_duration_minutes: %d is out of [%d, %d]",
+			s.SessionDurationMinutes, minSessionDurationMinutes, maxSessionDurationMinutes))
+	}
+
+	return errors.Join(errs...)
+}
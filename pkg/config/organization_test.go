@@ -0,0 +1,63 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOrganizationSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings OrganizationSettings
+		wantErr  string
+	}{
+		{
+			name: "no settings",
+		},
+		{
+			name: "restricted policies",
+			settings: OrganizationSettings{
+				EmailAllowedDomains:    []string{"acme.com"},
+				EmailJITProvisioning:   "RESTRICTED",
+				AuthMethods:            "RESTRICTED",
+				AllowedAuthMethods:     []string{"sso", "magic_link"},
+				SSOJITProvisioning:     "RESTRICTED",
+				MFAPolicy:              "REQUIRED_FOR_ALL",
+				MFAMethods:             "RESTRICTED",
+				AllowedMFAMethods:      []string{"totp"},
+				SessionDurationMinutes: 480,
+			},
+		},
+		{
+			name:     "unknown values",
+			settings: OrganizationSettings{MFAPolicy: "ALWAYS", AuthMethods: "RESTRICTED", AllowedAuthMethods: []string{"passkey"}},
+			wantErr:  "settings.mfa_policy: unknown value \"ALWAYS\"\nsettings.allowed_auth_methods: unknown value \"passkey\"",
+		},
+		{
+			name:     "email JIT provisioning without domains",
+			settings: OrganizationSettings{EmailJITProvisioning: "RESTRICTED"},
+			wantErr:  "RESTRICTED requires email_allowed_domains",
+		},
+		{
+			name:     "allowed auth methods without restriction",
+			settings: OrganizationSettings{AllowedAuthMethods: []string{"sso"}},
+			wantErr:  "settings.allowed_auth_methods: required when auth_methods is RESTRICTED",
+		},
+		{
+			name:     "session too short",
+			settings: OrganizationSettings{SessionDurationMinutes: 1},
+			wantErr:  "settings.session_duration_minutes: 1 is out of [5, 525600]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOrganizationSettings(&tt.settings)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// s3UpdateAttempts is the number of times Update reads and writes the state when other runs update it concurrently
+const s3UpdateAttempts = 5
+
+// errS3PreconditionFailed is returned by put when the object changed or exists despite the condition
+var errS3PreconditionFailed = errors.New("precondition failed")
+
+// S3Conf configures the S3 compatible service holding the state, read from the s3 section of setup.yaml
+// The credentials are usually given with the standard AWS_* environment variables
+type S3Conf struct {
+	// Endpoint of an S3 compatible service such as MinIO, the bucket is then addressed in the path
+	// Empty for AWS S3, the bucket is then addressed in the host name
+	Endpoint        string `mapstructure:"endpoint"`
+	Region          string `mapstructure:"region"`
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	SessionToken    string `mapstructure:"session_token"`
+}
+
+// NewS3Conf reads the s3 section of the config file and the AWS_* environment variables
+func NewS3Conf(v *viper.Viper) (*S3Conf, error) {
+	// UnmarshalKey would miss the defaults and environment variables of the keys not set in the s3 section
+	var C struct {
+		S3 S3Conf `mapstructure:"s3"`
+	}
+
+	v.BindEnv("s3.endpoint", "AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL")
+	v.BindEnv("s3.region", "AWS_REGION", "AWS_DEFAULT_REGION")
+	v.BindEnv("s3.access_key_id", "AWS_ACCESS_KEY_ID")
+	v.BindEnv("s3.secret_access_key", "AWS_SECRET_ACCESS_KEY")
+	v.BindEnv("s3.session_token", "AWS_SESSION_TOKEN")
+	v.SetDefault("s3.region", "us-east-1")
+
+	if err := v.Unmarshal(&C); err != nil {
+		return &C.S3, err
+	}
+
+	if C.S3.AccessKeyID == "" || C.S3.SecretAccessKey == "" {
+		return &C.S3, errors.New("no S3 credentials, set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return &C.S3, nil
+}
+
+// ParseS3URL splits a s3://bucket/key URL
+func ParseS3URL(raw string) (bucket, key string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	key = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" || strings.HasSuffix(key, "/") {
+		return "", "", fmt.Errorf("invalid S3 state %q, expected s3://bucket/key", raw)
+	}
+	return u.Host, key, nil
+}
+
+// S3StateStore persists the State as a JSON object of an S3 compatible bucket, to share it between machines
+// Updates are conditional writes on the ETag of the object read, a concurrent update is never overwritten
+// After each update the previous state is copied under <key>.versions/, the lock is the <key>.lock object
+type S3StateStore struct {
+	Conf       *S3Conf
+	Bucket     string
+	Key        string
+	HTTPClient *http.Client
+}
+
+func NewS3StateStore(conf *S3Conf, bucket, key string) *S3StateStore {
+	return &S3StateStore{
+		Conf:       conf,
+		Bucket:     bucket,
+		Key:        key,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *S3StateStore) Location() string {
+	return "s3://" + s.Bucket + "/" + s.Key
+}
+
+// LockKey is the object holding the lock of the state, it exists while a run holds the lock
+func (s *S3StateStore) LockKey() string {
+	return s.Key + ".lock"
+}
+
+// VersionsPrefix is the prefix of the copies of the previous states, named after the time they were replaced
+func (s *S3StateStore) VersionsPrefix() string {
+	return s.Key + ".versions/"
+}
+
+func (s *S3StateStore) Read() (*State, error) {
+	state, _, _, err := s.read(context.Background())
+	return state, err
+}
+
+// Update retries the read-modify-write when another run updated the state in between
+// Concurrent updates of the same state within the process are serialized, tenants are saved concurrently
+func (s *S3StateStore) Update(fn func(state *State) error) error {
+	defer lockProcess(s.Location())()
+	ctx := context.Background()
+
+	for attempt := 1; ; attempt++ {
+		state, raw, etag, err := s.read(ctx)
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			state = &State{}
+		}
+
+		if err := fn(state); err != nil {
+			return err
+		}
+
+		err = s.write(ctx, state, raw, etag)
+		if !errors.Is(err, errS3PreconditionFailed) {
+			return err
+		}
+		if attempt == s3UpdateAttempts {
+			return fmt.Errorf("error writing state %s, it kept changing during %d attempts", s.Location(), attempt)
+		}
+	}
+}
+
+// read returns the state along with the object and its ETag, the condition of the next write
+func (s *S3StateStore) read(ctx context.Context) (*State, []byte, string, error) {
+	raw, etag, err := s.get(ctx, s.Key)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error reading state %w", err)
+	}
+	if raw == nil {
+		return nil, nil, "", nil
+	}
+
+	var state State
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, nil, "", fmt.Errorf("error decoding state %s %w", s.Location(), err)
+	}
+	if state.Version > StateSchemaVersion {
+		return nil, nil, "", fmt.Errorf("state %s has schema version %d, this release only supports up to %d", s.Location(), state.Version, StateSchemaVersion)
+	}
+	return &state, raw, etag, nil
+}
+
+// write replaces the previous object if it is still etag, then keeps a copy of it
+// The copy is only made once the write succeeded, a write losing the race leaves no version behind
+func (s *S3StateStore) write(ctx context.Context, state *State, previous []byte, etag string) error {
+	state.Version = StateSchemaVersion
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if etag == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", etag)
+	}
+
+	if err := s.put(ctx, s.Key, append(raw, '\n'), header); err != nil {
+		if errors.Is(err, errS3PreconditionFailed) {
+			return err
+		}
+		return fmt.Errorf("error writing state %w", err)
+	}
+
+	if previous != nil {
+		versionKey := s.VersionsPrefix() + time.Now().UTC().Format("20060102T150405.000000000Z") + ".json"
+		if err := s.put(ctx, versionKey, previous, nil); err != nil {
+			// the state is saved, only its history is incomplete
+			log.Printf("state %s written but the previous state could not be kept under %s: %v", s.Location(), versionKey, err)
+		}
+	}
+	return nil
+}
+
+// Versions lists the copies of the previous states, oldest first
+func (s *S3StateStore) Versions() ([]string, error) {
+	keys, err := s.list(context.Background(), s.VersionsPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("error listing the versions of state %s %w", s.Location(), err)
+	}
+
+	versions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		versions = append(versions, strings.TrimPrefix(key, s.VersionsPrefix()))
+	}
+	// the names are the time the state was replaced
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// Rollback replaces the state with one of its versions, the state replaced is kept as a version too
+func (s *S3StateStore) Rollback(version string) error {
+	raw, _, err := s.get(context.Background(), s.VersionsPrefix()+version)
+	if err != nil {
+		return fmt.Errorf("error reading state version %s %w", version, err)
+	}
+	if raw == nil {
+		return fmt.Errorf("state %s has no version %s, list them with `state versions`", s.Location(), version)
+	}
+
+	var restored State
+	if err := json.Unmarshal(raw, &restored); err != nil {
+		return fmt.Errorf("error decoding state version %s %w", version, err)
+	}
+	if restored.Version > StateSchemaVersion {
+		return fmt.Errorf("state version %s has schema version %d, this release only supports up to %d", version, restored.Version, StateSchemaVersion)
+	}
+
+	return s.Update(func(state *State) error {
+		*state = restored
+		return nil
+	})
+}
+
+// Lock takes the lock of the state for command, waiting up to timeout for the holder to release it
+// The lock object is only created if it does not exist, so two machines never both take it
+func (s *S3StateStore) Lock(ctx context.Context, command string, timeout time.Duration) (func() error, error) {
+	return waitLock(ctx, s, s.unlock, command, timeout, func(raw []byte) (bool, error) {
+		err := s.put(ctx, s.LockKey(), raw, http.Header{"If-None-Match": {"*"}})
+		if errors.Is(err, errS3PreconditionFailed) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// LockHolder returns the run holding the lock, nil when the state is not locked
+func (s *S3StateStore) LockHolder() (*LockInfo, error) {
+	raw, _, err := s.get(context.Background(), s.LockKey())
+	if err != nil {
+		return nil, fmt.Errorf("error reading state lock %w", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	return decodeLockInfo(raw, s.Location()+".lock")
+}
+
+// ForceUnlock removes the lock whoever holds it, see FileStateStore.ForceUnlock
+func (s *S3StateStore) ForceUnlock() (*LockInfo, error) {
+	holder, err := s.LockHolder()
+	if holder == nil && err == nil {
+		return nil, nil
+	}
+
+	if err := s.delete(context.Background(), s.LockKey()); err != nil {
+		return holder, fmt.Errorf("error removing state lock %w", err)
+	}
+	return holder, nil
+}
+
+func (s *S3StateStore) unlock(id string) error {
+	holder, err := s.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != id {
+		return nil
+	}
+
+	if err := s.delete(context.Background(), s.LockKey()); err != nil {
+		return fmt.Errorf("error removing state lock %w", err)
+	}
+	return nil
+}
+
+// get returns the object and its ETag, nil when it does not exist
+func (s *S3StateStore) get(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", newS3Error(resp)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return raw, resp.Header.Get("ETag"), nil
+}
+
+// put fails with errS3PreconditionFailed when the If-Match or If-None-Match condition of header does not hold
+func (s *S3StateStore) put(ctx context.Context, key string, body []byte, header http.Header) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 answers 409 when a concurrent conditional write on the same key is in progress
+	if resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict {
+		return errS3PreconditionFailed
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newS3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3StateStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return newS3Error(resp)
+	}
+	return nil
+}
+
+// list returns the keys starting with prefix
+// ref: https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+func (s *S3StateStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.doURL(ctx, http.MethodGet, s.bucketURL()+"?"+query.Encode(), nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = newS3Error(resp)
+		} else {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, content := range result.Contents {
+			keys = append(keys, content.Key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3StateStore) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	return s.doURL(ctx, method, s.objectURL(key), body, header)
+}
+
+func (s *S3StateStore) doURL(ctx context.Context, method, rawURL string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	signS3Request(req, body, s.Conf, time.Now())
+	return s.HTTPClient.Do(req)
+}
+
+func (s *S3StateStore) bucketURL() string {
+	if s.Conf.Endpoint != "" {
+		return strings.TrimRight(s.Conf.Endpoint, "/") + "/" + s3EscapePath(s.Bucket)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.Bucket, s.Conf.Region)
+}
+
+func (s *S3StateStore) objectURL(key string) string {
+	if s.Conf.Endpoint != "" {
+		return strings.TrimRight(s.Conf.Endpoint, "/") + "/" + s3EscapePath(s.Bucket) + "/" + s3EscapePath(key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Conf.Region, s3EscapePath(key))
+}
+
+// signS3Request adds the AWS Signature Version 4 of the request, every header already set is signed
+// ref: https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3Request(req *http.Request, body []byte, conf *S3Conf, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if conf.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", conf.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + conf.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+conf.SecretAccessKey), date)
+	key = hmacSHA256(key, conf.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		conf.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// s3EscapePath encodes every byte but the unreserved characters and the slashes, as the signature expects
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func newS3Error(resp *http.Response) error {
+	s3Err := &s3Error{StatusCode: resp.StatusCode}
+	xml.NewDecoder(resp.Body).Decode(s3Err)
+	return s3Err
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("S3 returned %d %s %s", e.StatusCode, e.Code, e.Message)
+}
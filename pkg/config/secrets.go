@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SecretStore keeps the credentials created by the setup out of setup.yaml
+type SecretStore interface {
+	// GetSecret returns "" when the key is unknown
+	GetSecret(key string) (string, error)
+	SetSecret(key, value string) error
+	DeleteSecret(key string) error
+}
+
+// UnsharedSecretStore refuses to keep secrets for a state shared by several machines that has no place for them
+// Each operation fails with Err, so a second machine never finds the state without the secrets of its connections
+type UnsharedSecretStore struct {
+	Err error
+}
+
+func (s *UnsharedSecretStore) GetSecret(key string) (string, error) {
+	return "", s.Err
+}
+
+func (s *UnsharedSecretStore) SetSecret(key, value string) error {
+	return s.Err
+}
+
+// DeleteSecret succeeds, no secret is ever kept
+func (s *UnsharedSecretStore) DeleteSecret(key string) error {
+	return nil
+}
+
+// FileSecretStore keeps the secrets in a JSON file only readable by its owner
+type FileSecretStore struct {
+	Path string
+	// Cipher encrypts the file when set, a plaintext file is still read and encrypted on the next write
+	Cipher *FileCipher
+
+	mu sync.Mutex
+}
+
+func NewFileSecretStore(path string) *FileSecretStore {
+	return &FileSecretStore{
+		Path: path,
+	}
+}
+
+func (s *FileSecretStore) GetSecret(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	return secrets[key], err
+}
+
+func (s *FileSecretStore) SetSecret(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	secrets[key] = value
+	return s.write(secrets)
+}
+
+func (s *FileSecretStore) DeleteSecret(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return s.write(secrets)
+}
+
+func (s *FileSecretStore) read() (map[string]string, error) {
+	secrets := map[string]string{}
+
+	raw, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading secrets %w", err)
+	}
+	if raw, err = openFile(s.Cipher, s.Path, raw); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, &secrets); err != nil {
+		return nil, fmt.Errorf("error decoding secrets %s %w", s.Path, err)
+	}
+	return secrets, nil
+}
+
+func (s *FileSecretStore) write(secrets map[string]string) error {
+	raw, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if raw, err = sealFile(s.Cipher, raw); err != nil {
+		return fmt.Errorf("error encrypting secrets %w", err)
+	}
+	if err := writeFileAtomic(s.Path, raw); err != nil {
+		return fmt.Errorf("error writing secrets %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file through a rename so a crash never leaves it truncated
+// CreateTemp restricts the new file to its owner (0600)
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
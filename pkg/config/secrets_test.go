@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSecretStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "setup.secrets.json")
+	store := NewFileSecretStore(path)
+
+	value, err := store.GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	require.NoError(t, store.SetSecret("scim/scim-connection-test/bearer_token", "s3cr3t"))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	value, err = NewFileSecretStore(path).GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Equal(t, "s3cr3t", value)
+
+	require.NoError(t, store.DeleteSecret("scim/scim-connection-test/bearer_token"))
+	require.NoError(t, store.DeleteSecret("scim/scim-connection-test/bearer_token"))
+	value, err = store.GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Empty(t, value)
+}
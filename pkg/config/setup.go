@@ -8,6 +8,12 @@ type SetupConfig struct {
 type SetupInput struct {
 	StytchSetupInput `mapstructure:"stytch"`
 	OktaSetupInput   `mapstructure:"okta"`
+	// Attributes drives both the IdP attribute statements and the Stytch attribute mapping
+	Attributes []SAMLAttribute `mapstructure:"attributes"`
+	// SCIM enables the optional provisioning stage
+	SCIM SCIMSetupInput `mapstructure:"scim"`
+	// OIDC is used instead of the SAML inputs when setup runs with the oidc protocol
+	OIDC OIDCSetupInput `mapstructure:"oidc"`
 }
 
 type OktaSetupInput struct {
@@ -18,34 +24,91 @@ type StytchSetupInput struct {
 	OrganizationName      string
 	OrganizationSlug      string
 	ConnectionDisplayName string
+	// Settings are applied when the organization is created then reconciled on every run
+	Settings OrganizationSettings `mapstructure:"settings"`
+}
+
+// SCIMSetupInput configures the Stytch SCIM connection the IdP provisions members through
+type SCIMSetupInput struct {
+	Enabled               bool   `mapstructure:"enabled"`
+	ConnectionDisplayName string `mapstructure:"connection_display_name"`
+}
+
+// OIDCSetupInput configures the Stytch OIDC connection and the IdP application behind it
+type OIDCSetupInput struct {
+	ConnectionDisplayName string `mapstructure:"connection_display_name"`
+	AppLabel              string `mapstructure:"app_label"`
 }
 
 // SetupResult collection UUID of all created resources in the setup proces
 type SetupResult struct {
-	StytchResult `mapstructure:"stytch"`
-	OktaResult   `mapstructure:"okta"`
+	StytchResult `mapstructure:"stytch" json:"stytch"`
+	OktaResult   `mapstructure:"okta" json:"okta"`
+	// SCIMResult is not embedded, its ConnectionID would shadow the SAML one
+	SCIMResult SCIMResult `mapstructure:"scim" json:"scim"`
+	// OIDCResult is not embedded either, for the same reason
+	OIDCResult OIDCResult `mapstructure:"oidc" json:"oidc"`
+	// Steps records the outcome of the last run of each setup step, by step name
+	Steps map[string]StepStatus `mapstructure:"steps" json:"steps,omitempty"`
+}
+
+// Status of a setup step
+const (
+	StepDone   = "done"
+	StepFailed = "failed"
+)
+
+type StepStatus struct {
+	Status string `mapstructure:"status" json:"status"`
+	// At is the RFC3339 time the step completed or failed
+	At    string `mapstructure:"at" json:"at"`
+	Error string `mapstructure:"error" json:"error,omitempty"`
+}
+
+// StepDone tells whether the step completed during a previous run
+func (r *SetupResult) StepDone(step string) bool {
+	return r.Steps[step].Status == StepDone
 }
 
 type StytchResult struct {
-	OrganizationID string              `mapstructure:"organization_id"`
-	ConnectionID   string              `mapstructure:"connection_id"`
-	SsoParameters  *StychSsoParameters `mapstructure:"sso_parameters"`
+	OrganizationID string              `mapstructure:"organization_id" json:"organization_id"`
+	ConnectionID   string              `mapstructure:"connection_id" json:"connection_id"`
+	SsoParameters  *StychSsoParameters `mapstructure:"sso_parameters" json:"sso_parameters,omitempty"`
 }
 
 type OktaResult struct {
-	ApplicationID string             `mapstructure:"application_id"`
-	SsoParameters *OktaSsoParameters `mapstructure:"sso_parameters"`
+	ApplicationID string             `mapstructure:"application_id" json:"application_id"`
+	SsoParameters *OktaSsoParameters `mapstructure:"sso_parameters" json:"sso_parameters,omitempty"`
+}
+
+// SCIMResult records the SCIM connection, its bearer token is kept in a SecretStore
+type SCIMResult struct {
+	ConnectionID         string `mapstructure:"connection_id" json:"connection_id"`
+	BaseURL              string `mapstructure:"base_url" json:"base_url"`
+	BearerTokenExpiresAt string `mapstructure:"bearer_token_expires_at" json:"bearer_token_expires_at"`
+}
+
+// OIDCResult records the OIDC connection and the IdP application, the client secret is kept in a SecretStore
+type OIDCResult struct {
+	ConnectionID string `mapstructure:"connection_id" json:"connection_id"`
+	// RedirectURL is the Stytch callback registered in the IdP application
+	RedirectURL   string `mapstructure:"redirect_url" json:"redirect_url"`
+	ApplicationID string `mapstructure:"application_id" json:"application_id"`
+	Issuer        string `mapstructure:"issuer" json:"issuer"`
+	ClientID      string `mapstructure:"client_id" json:"client_id"`
 }
 
 // SsoStychParameters represent the metadata needed to configure okta SSO obtained from Stych
 type StychSsoParameters struct {
-	AcsUrl   string
-	Audience string
+	AcsUrl   string `json:"acs_url"`
+	Audience string `json:"audience"`
 }
 
 // SsoOktaParameters  represent the metadata needed to configure okta SSO obtained from Okta
 type OktaSsoParameters struct {
-	IdpEntityID     string
-	IdpSSOURL       string
-	X509Certificate string
+	IdpEntityID     string `json:"idp_entity_id"`
+	IdpSSOURL       string `json:"idp_sso_url"`
+	X509Certificate string `json:"x509_certificate"`
+	// MetadataExpiresAt is the validUntil of the metadata, RFC3339, empty when it does not say
+	MetadataExpiresAt string `json:"metadata_expires_at,omitempty"`
 }
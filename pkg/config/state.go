@@ -0,0 +1,177 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateFile is the default name of the state file, next to the config file
+const StateFile = ".stytch-state.json"
+
+// StateSchemaVersion is the version of the state file format written by this release
+// It is bumped on breaking changes, a newer state file is never overwritten by an older release
+const StateSchemaVersion = 1
+
+// State is the document of the state file, it only holds results, never inputs or credentials
+type State struct {
+	Version int `json:"version"`
+	// Result is the result of a setup without tenants
+	Result *SetupResult `json:"result,omitempty"`
+	// Tenants holds the result of each tenant of the tenants section, by name
+	Tenants map[string]*SetupResult `json:"tenants,omitempty"`
+}
+
+// TenantResult returns the result of the tenant, "" is the setup without tenants
+// It returns nil when the tenant has no result yet
+func (s *State) TenantResult(tenant string) *SetupResult {
+	if tenant == "" {
+		return s.Result
+	}
+	return s.Tenants[tenant]
+}
+
+// SetTenantResult records the result of the tenant, "" is the setup without tenants
+func (s *State) SetTenantResult(tenant string, result *SetupResult) {
+	if tenant == "" {
+		s.Result = result
+		return
+	}
+	if s.Tenants == nil {
+		s.Tenants = map[string]*SetupResult{}
+	}
+	s.Tenants[tenant] = result
+}
+
+// StateStore persists the State, in a local file or in a remote backend shared by several machines
+type StateStore interface {
+	// Read returns the state, nil when it does not exist yet
+	Read() (*State, error)
+	// Update reads the state, applies fn then writes it back without overwriting a concurrent update
+	Update(fn func(state *State) error) error
+
+	StateLocker
+}
+
+// VersionedStateStore is implemented by the stores keeping a copy of the states they replace
+type VersionedStateStore interface {
+	StateStore
+	// Versions lists the names of the previous states, oldest first
+	Versions() ([]string, error)
+	// Rollback replaces the state with the previous state named version
+	Rollback(version string) error
+}
+
+// StateLocker is the lock taken by the commands changing Stytch or the IdP, so two runs never overwrite each other's results
+type StateLocker interface {
+	// Lock takes the lock of the state for the whole run of command, see FileStateStore.Lock
+	Lock(ctx context.Context, command string, timeout time.Duration) (func() error, error)
+	LockHolder() (*LockInfo, error)
+	ForceUnlock() (*LockInfo, error)
+
+	// Location is the path or URL of the state, for messages
+	Location() string
+}
+
+// FileStateStore persists the State in a JSON file, written atomically
+type FileStateStore struct {
+	Path string
+	// Cipher encrypts the file when set, a plaintext file is still read and encrypted on the next write
+	Cipher *FileCipher
+}
+
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{
+		Path: path,
+	}
+}
+
+// stateLocks serializes the updates of a state file within the process, tenants are saved concurrently
+var stateLocks sync.Map
+
+func (s *FileStateStore) lock() func() {
+	path, err := filepath.Abs(s.Path)
+	if err != nil {
+		path = s.Path
+	}
+	return lockProcess(path)
+}
+
+// lockProcess takes the process-wide lock of the state at location
+func lockProcess(location string) func() {
+	mu, _ := stateLocks.LoadOrStore(location, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func (s *FileStateStore) Location() string {
+	return s.Path
+}
+
+// Read returns the state, nil when the state file does not exist yet
+func (s *FileStateStore) Read() (*State, error) {
+	defer s.lock()()
+	return s.read()
+}
+
+// Update reads the state, applies fn then writes it back
+// Concurrent updates of the same file within the process do not overwrite each other
+func (s *FileStateStore) Update(fn func(state *State) error) error {
+	defer s.lock()()
+
+	state, err := s.read()
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &State{}
+	}
+
+	if err := fn(state); err != nil {
+		return err
+	}
+	return s.write(state)
+}
+
+func (s *FileStateStore) read() (*State, error) {
+	raw, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state %w", err)
+	}
+	if raw, err = openFile(s.Cipher, s.Path, raw); err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("error decoding state %s %w", s.Path, err)
+	}
+	if state.Version > StateSchemaVersion {
+		return nil, fmt.Errorf("state %s has schema version %d, this release only supports up to %d", s.Path, state.Version, StateSchemaVersion)
+	}
+	return &state, nil
+}
+
+func (s *FileStateStore) write(state *State) error {
+	state.Version = StateSchemaVersion
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if raw, err = sealFile(s.Cipher, append(raw, '\n')); err != nil {
+		return fmt.Errorf("error encrypting state %w", err)
+	}
+	if err := writeFileAtomic(s.Path, raw); err != nil {
+		return fmt.Errorf("error writing state %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), StateFile)
+	store := NewFileStateStore(path)
+
+	state, err := store.Read()
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	result := &SetupResult{
+		StytchResult: StytchResult{
+			OrganizationID: "organization-test",
+			ConnectionID:   "saml-connection-test",
+			SsoParameters:  &StychSsoParameters{AcsUrl: "https://test.stytch.com/acs", Audience: "https://test.stytch.com/acs"},
+		},
+		Steps: map[string]StepStatus{"create-organization": {Status: StepDone, At: "2024-05-01T00:00:00Z"}},
+	}
+	require.NoError(t, store.Update(func(state *State) error {
+		state.SetTenantResult("", result)
+		state.SetTenantResult("acme", &SetupResult{StytchResult: StytchResult{OrganizationID: "organization-test-acme"}})
+		return nil
+	}))
+
+	state, err = store.Read()
+	require.NoError(t, err)
+	require.Equal(t, StateSchemaVersion, state.Version)
+	require.Equal(t, result, state.TenantResult(""))
+	require.Equal(t, "organization-test-acme", state.TenantResult("acme").OrganizationID)
+	require.Nil(t, state.TenantResult("globex"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// A state written by a newer release is never overwritten
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 99}`), 0o600))
+	err = store.Update(func(state *State) error { return nil })
+	require.ErrorContains(t, err, "schema version 99")
+}
+
+func TestLoadSetupResult_ImportsConfigFile(t *testing.T) {
+	v := viper.New()
+	v.Set("stytch.organization_id", "organization-test-legacy")
+
+	store := NewFileStateStore(filepath.Join(t.TempDir(), StateFile))
+	result, err := LoadSetupResult(store, v)
+	require.NoError(t, err)
+	require.Equal(t, "organization-test-legacy", result.OrganizationID)
+
+	require.NoError(t, saveResult(store, "", &SetupResult{StytchResult: StytchResult{OrganizationID: "organization-test"}}))
+	result, err = LoadSetupResult(store, v)
+	require.NoError(t, err)
+	require.Equal(t, "organization-test", result.OrganizationID)
+}
@@ -0,0 +1,595 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Secrets of a Vault setup, under the path given with --vault-path
+const (
+	vaultInputSecret   = "input"
+	vaultResultSecret  = "result"
+	vaultClientsSecret = "clients"
+	vaultLockSecret    = "lock"
+	vaultSecretsSecret = "secrets"
+)
+
+// VaultConf configures the Vault server holding the setup, read from the vault section of setup.yaml
+// The address and credentials are usually given with the standard VAULT_* environment variables
+type VaultConf struct {
+	Address   string `mapstructure:"address"`
+	Namespace string `mapstructure:"namespace"`
+	// Mount is the mount point of the KV v2 secrets engine
+	Mount string `mapstructure:"mount"`
+	// Path of the setup in the KV mount, it holds the input, result and clients secrets
+	Path string `mapstructure:"path"`
+
+	// Token is used as is, otherwise the client logs in with AppRole
+	Token        string `mapstructure:"token"`
+	RoleID       string `mapstructure:"role_id"`
+	SecretID     string `mapstructure:"secret_id"`
+	AppRoleMount string `mapstructure:"approle_mount"`
+}
+
+// NewVaultConf reads the vault section of the config file and the VAULT_* environment variables
+func NewVaultConf(v *viper.Viper) (*VaultConf, error) {
+	// UnmarshalKey would miss the defaults and environment variables of the keys not set in the vault section
+	var C struct {
+		Vault VaultConf `mapstructure:"vault"`
+	}
+
+	v.BindEnv("vault.address", "VAULT_ADDR")
+	v.BindEnv("vault.namespace", "VAULT_NAMESPACE")
+	v.BindEnv("vault.token", "VAULT_TOKEN")
+	v.BindEnv("vault.role_id", "VAULT_ROLE_ID")
+	v.BindEnv("vault.secret_id", "VAULT_SECRET_ID")
+	v.SetDefault("vault.mount", "secret")
+	v.SetDefault("vault.approle_mount", "approle")
+
+	if err := v.Unmarshal(&C); err != nil {
+		return &C.Vault, err
+	}
+
+	if C.Vault.Address == "" {
+		return &C.Vault, errors.New("no Vault address, set VAULT_ADDR or vault.address")
+	}
+	if C.Vault.Token == "" && (C.Vault.RoleID == "" || C.Vault.SecretID == "") {
+		return &C.Vault, errors.New("no Vault credentials, set VAULT_TOKEN or VAULT_ROLE_ID and VAULT_SECRET_ID")
+	}
+	return &C.Vault, nil
+}
+
+// VaultConflictError is returned by Write when the secret changed since the version given as check-and-set
+type VaultConflictError struct {
+	Path    string
+	Version int
+}
+
+func (e *VaultConflictError) Error() string {
+	return fmt.Sprintf("vault secret %s changed since version %d was read, another run saved it in the meantime", e.Path, e.Version)
+}
+
+// VaultClient is a minimal client of the Vault KV v2 secrets engine
+// ref: https://developer.hashicorp.com/vault/api-docs/secret/kv/kv-v2
+type VaultClient struct {
+	Conf       *VaultConf
+	HTTPClient *http.Client
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func NewVaultClient(conf *VaultConf) *VaultClient {
+	return &VaultClient{
+		Conf:       conf,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Read returns the data and the version of the latest version of the secret
+// The data is nil when the secret does not exist or its latest version is deleted, the version is then the one to check-and-set against
+func (c *VaultClient) Read(ctx context.Context, path string) (map[string]any, int, error) {
+	var out struct {
+		Data struct {
+			Data     map[string]any `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+
+	err := c.do(ctx, http.MethodGet, c.dataPath(path), nil, &out)
+	var vaultErr *vaultError
+	if errors.As(err, &vaultErr) && vaultErr.StatusCode == http.StatusNotFound {
+		// A deleted version is reported as not found along with its metadata
+		json.Unmarshal(vaultErr.body, &out)
+		return nil, out.Data.Metadata.Version, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading vault secret %s %w", path, err)
+	}
+	return out.Data.Data, out.Data.Metadata.Version, nil
+}
+
+// Write creates a new version of the secret if its current version is still cas, 0 when it does not exist yet
+// It returns the version written or a *VaultConflictError
+func (c *VaultClient) Write(ctx context.Context, path string, data map[string]any, cas int) (int, error) {
+	in := map[string]any{
+		"options": map[string]any{"cas": cas},
+		"data":    data,
+	}
+	var out struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+
+	err := c.do(ctx, http.MethodPost, c.dataPath(path), in, &out)
+	var vaultErr *vaultError
+	if errors.As(err, &vaultErr) && vaultErr.StatusCode == http.StatusBadRequest && vaultErr.casMismatch() {
+		return 0, &VaultConflictError{Path: path, Version: cas}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error writing vault secret %s %w", path, err)
+	}
+	return out.Data.Version, nil
+}
+
+// List returns the keys under path, the folders end with a "/". It returns nil when there is none
+func (c *VaultClient) List(ctx context.Context, path string) ([]string, error) {
+	var out struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+
+	err := c.do(ctx, http.MethodGet, "/v1/"+strings.Trim(c.Conf.Mount, "/")+"/metadata/"+strings.Trim(path, "/")+"?list=true", nil, &out)
+	var vaultErr *vaultError
+	if errors.As(err, &vaultErr) && vaultErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing vault secrets under %s %w", path, err)
+	}
+	return out.Data.Keys, nil
+}
+
+func (c *VaultClient) dataPath(path string) string {
+	return "/v1/" + strings.Trim(c.Conf.Mount, "/") + "/data/" + strings.Trim(path, "/")
+}
+
+func (c *VaultClient) do(ctx context.Context, method, path string, in, out any) error {
+	token, err := c.clientToken(ctx)
+	if err != nil {
+		return err
+	}
+	return c.request(ctx, method, path, token, in, out)
+}
+
+func (c *VaultClient) request(ctx context.Context, method, path, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.Conf.Address, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.Conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Conf.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		vaultErr := &vaultError{StatusCode: resp.StatusCode, body: raw}
+		json.Unmarshal(raw, vaultErr)
+		return vaultErr
+	}
+
+	if out == nil || len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// clientToken returns the configured token, or logs in with AppRole when there is none
+func (c *VaultClient) clientToken(ctx context.Context) (string, error) {
+	if c.Conf.Token != "" {
+		return c.Conf.Token, nil
+	}
+
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	if c.token != "" && (c.tokenExpiry.IsZero() || time.Now().Before(c.tokenExpiry)) {
+		return c.token, nil
+	}
+
+	var out struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	in := map[string]string{"role_id": c.Conf.RoleID, "secret_id": c.Conf.SecretID}
+	path := "/v1/auth/" + strings.Trim(c.Conf.AppRoleMount, "/") + "/login"
+	if err := c.request(ctx, http.MethodPost, path, "", in, &out); err != nil {
+		return "", fmt.Errorf("error logging in to Vault with AppRole %w", err)
+	}
+
+	c.token = out.Auth.ClientToken
+	c.tokenExpiry = time.Time{}
+	if out.Auth.LeaseDuration > 0 {
+		// Keep a margin so the token does not expire in the middle of a request
+		c.tokenExpiry = time.Now().Add(time.Duration(out.Auth.LeaseDuration)*time.Second - time.Minute)
+	}
+	return c.token, nil
+}
+
+type vaultError struct {
+	StatusCode int      `json:"-"`
+	Errors     []string `json:"errors"`
+	body       []byte
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("vault returned %d %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+func (e *vaultError) casMismatch() bool {
+	for _, msg := range e.Errors {
+		if strings.Contains(msg, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
+
+// VaultConfigProvider implement the setup.ConfigProvider interface with the Vault KV v2 secrets engine
+// The inputs are read from the <path>/input secret, with the same keys as setup.yaml
+// The result is saved in the <path>/result secret with check-and-set, a run never overwrites a result it did not load
+type VaultConfigProvider struct {
+	Client *VaultClient
+	Path   string
+	// Tenant is the name of the tenant whose setup lives under Path, "" for a setup without tenants
+	Tenant string
+	// Root is the path of the setup the tenant belongs to, its attributes are shared by the tenants
+	Root string
+
+	mu      sync.Mutex
+	data    *SetupConfig
+	version int
+}
+
+func NewVaultConfigProvider(client *VaultClient, path string) *VaultConfigProvider {
+	return &VaultConfigProvider{
+		Client: client,
+		Path:   strings.Trim(path, "/"),
+	}
+}
+
+// NewVaultTenantConfigProvider works on the setup of the tenant, under <path>/tenants/<name>
+func NewVaultTenantConfigProvider(client *VaultClient, path, tenant string) *VaultConfigProvider {
+	return &VaultConfigProvider{
+		Client: client,
+		Path:   strings.Trim(path, "/") + "/" + tenantsKey + "/" + tenant,
+		Tenant: tenant,
+		Root:   strings.Trim(path, "/"),
+	}
+}
+
+// VaultTenantNames lists the tenants having a folder under <path>/tenants, sorted by name
+func VaultTenantNames(ctx context.Context, client *VaultClient, path string) ([]string, error) {
+	keys, err := client.List(ctx, strings.Trim(path, "/")+"/"+tenantsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		if name, ok := strings.CutSuffix(key, "/"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *VaultConfigProvider) Load() (*SetupConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctx := context.Background()
+
+	raw, _, err := c.Client.Read(ctx, c.Path+"/"+vaultInputSecret)
+	if err != nil {
+		return nil, err
+	}
+	sub := viper.New()
+	if err := sub.MergeConfigMap(raw); err != nil {
+		return nil, err
+	}
+
+	name, slug := "Example SAML App", "example-saml-app"
+	var attributes []SAMLAttribute
+	if c.Tenant != "" {
+		name, slug = c.Tenant, c.Tenant
+
+		// Tenants share the root attributes section unless they define their own
+		raw, _, err := c.Client.Read(ctx, c.Root+"/"+vaultInputSecret)
+		if err != nil {
+			return nil, err
+		}
+		root := viper.New()
+		if err := root.MergeConfigMap(raw); err != nil {
+			return nil, err
+		}
+		if err := root.UnmarshalKey("attributes", &attributes); err != nil {
+			return nil, fmt.Errorf("error decoding the attributes of vault secret %s/%s %w", c.Root, vaultInputSecret, err)
+		}
+	}
+	in, err := newSetupInput(sub, name, slug, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, version, err := c.Client.Read(ctx, c.Path+"/"+vaultResultSecret)
+	if err != nil {
+		return nil, err
+	}
+	out := &SetupResult{}
+	if raw != nil {
+		var state State
+		if err := remarshal(raw, &state); err != nil {
+			return nil, fmt.Errorf("error decoding vault secret %s/%s %w", c.Path, vaultResultSecret, err)
+		}
+		if state.Version > StateSchemaVersion {
+			return nil, fmt.Errorf("vault secret %s/%s has schema version %d, this release only supports up to %d",
+				c.Path, vaultResultSecret, state.Version, StateSchemaVersion)
+		}
+		if state.Result != nil {
+			out = state.Result
+		}
+	}
+
+	c.data = &SetupConfig{in, out}
+	c.version = version
+	return c.data, nil
+}
+
+func (c *VaultConfigProvider) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var data map[string]any
+	if err := remarshal(&State{Version: StateSchemaVersion, Result: c.data.SetupResult}, &data); err != nil {
+		return err
+	}
+
+	version, err := c.Client.Write(context.Background(), c.Path+"/"+vaultResultSecret, data, c.version)
+	if err != nil {
+		return err
+	}
+	c.version = version
+	return nil
+}
+
+// VaultLock implements StateLocker with the <path>/lock secret, written with check-and-set
+// The lock is held while the latest version of the secret has data, it is released by writing an empty version
+type VaultLock struct {
+	Client *VaultClient
+	Path   string
+}
+
+func NewVaultLock(client *VaultClient, path string) *VaultLock {
+	return &VaultLock{
+		Client: client,
+		Path:   strings.Trim(path, "/"),
+	}
+}
+
+func (l *VaultLock) secret() string {
+	return l.Path + "/" + vaultLockSecret
+}
+
+// Location is the setup path in the KV mount
+func (l *VaultLock) Location() string {
+	return "vault://" + strings.Trim(l.Client.Conf.Mount, "/") + "/" + l.Path
+}
+
+// Lock takes the lock of the setup for command, waiting up to timeout for the holder to release it
+func (l *VaultLock) Lock(ctx context.Context, command string, timeout time.Duration) (func() error, error) {
+	return waitLock(ctx, l, l.unlock, command, timeout, func(raw []byte) (bool, error) {
+		data, version, err := l.Client.Read(ctx, l.secret())
+		if err != nil {
+			return false, err
+		}
+		if len(data) > 0 {
+			return false, nil
+		}
+
+		var info map[string]any
+		if err := json.Unmarshal(raw, &info); err != nil {
+			return false, err
+		}
+		_, err = l.Client.Write(ctx, l.secret(), info, version)
+		var conflict *VaultConflictError
+		if errors.As(err, &conflict) {
+			// another run took the lock since it was read
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+// LockHolder returns the run holding the lock, nil when the setup is not locked
+func (l *VaultLock) LockHolder() (*LockInfo, error) {
+	holder, _, err := l.holder()
+	return holder, err
+}
+
+func (l *VaultLock) holder() (*LockInfo, int, error) {
+	data, version, err := l.Client.Read(context.Background(), l.secret())
+	if err != nil || len(data) == 0 {
+		return nil, version, err
+	}
+
+	var info LockInfo
+	if err := remarshal(data, &info); err != nil {
+		return nil, version, fmt.Errorf("error decoding state lock %s %w", l.secret(), err)
+	}
+	return &info, version, nil
+}
+
+// ForceUnlock removes the lock whoever holds it, it returns the holder or nil when the setup was not locked
+func (l *VaultLock) ForceUnlock() (*LockInfo, error) {
+	holder, version, err := l.holder()
+	if holder == nil || err != nil {
+		return nil, err
+	}
+
+	if _, err := l.Client.Write(context.Background(), l.secret(), map[string]any{}, version); err != nil {
+		return holder, fmt.Errorf("error removing state lock %w", err)
+	}
+	return holder, nil
+}
+
+func (l *VaultLock) unlock(id string) error {
+	holder, version, err := l.holder()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != id {
+		return nil
+	}
+
+	if _, err := l.Client.Write(context.Background(), l.secret(), map[string]any{}, version); err != nil {
+		return fmt.Errorf("error removing state lock %w", err)
+	}
+	return nil
+}
+
+// VaultSecretStore implements SecretStore with the <path>/secrets secret, so every machine using the setup reads them
+// Each change is written with check-and-set on the version it was applied to
+type VaultSecretStore struct {
+	Client *VaultClient
+	Path   string
+}
+
+func NewVaultSecretStore(client *VaultClient, path string) *VaultSecretStore {
+	return &VaultSecretStore{
+		Client: client,
+		Path:   strings.Trim(path, "/"),
+	}
+}
+
+func (s *VaultSecretStore) secret() string {
+	return s.Path + "/" + vaultSecretsSecret
+}
+
+func (s *VaultSecretStore) GetSecret(key string) (string, error) {
+	data, _, err := s.Client.Read(context.Background(), s.secret())
+	if err != nil {
+		return "", err
+	}
+	value, _ := data[key].(string)
+	return value, nil
+}
+
+func (s *VaultSecretStore) SetSecret(key, value string) error {
+	return s.update(func(data map[string]any) bool {
+		data[key] = value
+		return true
+	})
+}
+
+func (s *VaultSecretStore) DeleteSecret(key string) error {
+	return s.update(func(data map[string]any) bool {
+		if _, ok := data[key]; !ok {
+			return false
+		}
+		delete(data, key)
+		return true
+	})
+}
+
+// update applies fn to the latest version of the secrets then writes them when fn changed them
+// The change is applied again on top of a version written by another run in the meantime
+func (s *VaultSecretStore) update(fn func(data map[string]any) bool) error {
+	ctx := context.Background()
+	for {
+		data, version, err := s.Client.Read(ctx, s.secret())
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			data = map[string]any{}
+		}
+		if !fn(data) {
+			return nil
+		}
+
+		_, err = s.Client.Write(ctx, s.secret(), data, version)
+		var conflict *VaultConflictError
+		if !errors.As(err, &conflict) {
+			return err
+		}
+	}
+}
+
+// NewVaultClientConfig reads the client credentials from the <path>/clients secret, then from the environment like NewClientConfig
+// The secret keys are the names of the environment variables (STYTCH_PROJECT_ID, OKTA_API_TOKEN...), which keep precedence
+func NewVaultClientConfig(ctx context.Context, client *VaultClient, path string, v *viper.Viper) (*ClientsConf, error) {
+	raw, _, err := client.Read(ctx, strings.Trim(path, "/")+"/"+vaultClientsSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range clientKeys {
+		if value, ok := raw[clientEnvName(key)].(string); ok && value != "" {
+			v.SetDefault(key, value)
+		}
+	}
+	return NewClientConfig(v)
+}
+
+// remarshal converts in to out through their JSON representation
+func remarshal(in, out any) error {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
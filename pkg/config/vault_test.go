@@ -0,0 +1,268 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault implements the AppRole login and the KV v2 data endpoints of a "secret" mount
+type fakeVault struct {
+	mu       sync.Mutex
+	versions map[string][]map[string]any
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	f := &fakeVault{versions: map[string][]map[string]any{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/approle/login", func(w http.ResponseWriter, r *http.Request) {
+		var in map[string]string
+		json.NewDecoder(r.Body).Decode(&in)
+		if in["role_id"] != "role-test" || in["secret_id"] != "secret-test" {
+			writeVaultErrors(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": "token-approle", "lease_duration": 3600}})
+	})
+	mux.HandleFunc("/v1/secret/data/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get("X-Vault-Token"); token != "token-test" && token != "token-approle" {
+			writeVaultErrors(w, http.StatusForbidden, "permission denied")
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		path := r.PathValue("path")
+		versions := f.versions[path]
+
+		switch r.Method {
+		case http.MethodGet:
+			if len(versions) == 0 {
+				writeVaultErrors(w, http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data":     versions[len(versions)-1],
+				"metadata": map[string]any{"version": len(versions)},
+			}})
+		case http.MethodPost:
+			var in struct {
+				Options struct {
+					CAS *int `json:"cas"`
+				} `json:"options"`
+				Data map[string]any `json:"data"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			if in.Options.CAS != nil && *in.Options.CAS != len(versions) {
+				writeVaultErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+			f.versions[path] = append(versions, in.Data)
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"version": len(f.versions[path])}})
+		}
+	})
+
+	mux.HandleFunc("GET /v1/secret/metadata/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		prefix := r.PathValue("path") + "/"
+		seen := map[string]bool{}
+		var keys []string
+		for path := range f.versions {
+			rest, ok := strings.CutPrefix(path, prefix)
+			if !ok {
+				continue
+			}
+			if folder, _, nested := strings.Cut(rest, "/"); nested {
+				rest = folder + "/"
+			}
+			if !seen[rest] {
+				seen[rest] = true
+				keys = append(keys, rest)
+			}
+		}
+		if len(keys) == 0 {
+			writeVaultErrors(w, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"keys": keys}})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func (f *fakeVault) put(path string, data map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions[path] = append(f.versions[path], data)
+}
+
+func writeVaultErrors(w http.ResponseWriter, status int, errs ...string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"errors": append([]string{}, errs...)})
+}
+
+func TestVaultConfigProvider(t *testing.T) {
+	fake, server := newFakeVault(t)
+	fake.put("go-stytch-demo/input", map[string]any{
+		"stytch": map[string]any{"organizationname": "Acme Corp", "organizationslug": "acme"},
+	})
+
+	v := viper.New()
+	v.Set("vault.address", server.URL)
+	v.Set("vault.role_id", "role-test")
+	v.Set("vault.secret_id", "secret-test")
+	conf, err := NewVaultConf(v)
+	require.NoError(t, err)
+	client := NewVaultClient(conf)
+
+	provider := NewVaultConfigProvider(client, "/go-stytch-demo/")
+	setupConf, err := provider.Load()
+	require.NoError(t, err)
+	require.Equal(t, "Acme Corp", setupConf.OrganizationName)
+	require.Equal(t, "Okta", setupConf.ConnectionDisplayName)
+	require.Empty(t, setupConf.OrganizationID)
+
+	setupConf.OrganizationID = "organization-test"
+	require.NoError(t, provider.Save())
+	setupConf.ConnectionID = "saml-connection-test"
+	require.NoError(t, provider.Save())
+	require.Len(t, fake.versions["go-stytch-demo/result"], 2)
+
+	// Another run loads the saved result
+	other := NewVaultConfigProvider(client, "go-stytch-demo")
+	otherConf, err := other.Load()
+	require.NoError(t, err)
+	require.Equal(t, "organization-test", otherConf.OrganizationID)
+	require.Equal(t, "saml-connection-test", otherConf.ConnectionID)
+
+	// The first run can no longer overwrite the result saved after it loaded it
+	otherConf.OrganizationID = "organization-test-other"
+	require.NoError(t, other.Save())
+	err = provider.Save()
+	var conflict *VaultConflictError
+	require.True(t, errors.As(err, &conflict))
+	require.Equal(t, 2, conflict.Version)
+}
+
+func TestVaultTenantConfigProvider(t *testing.T) {
+	fake, server := newFakeVault(t)
+	// The root attributes are shared by the tenants
+	fake.put("go-stytch-demo/input", map[string]any{
+		"attributes": []any{map[string]any{"name": "email", "stytch_field": "email", "expression": "user.email"}},
+	})
+	fake.put("go-stytch-demo/tenants/acme/input", map[string]any{})
+	fake.put("go-stytch-demo/tenants/globex/input", map[string]any{})
+
+	client := NewVaultClient(&VaultConf{Address: server.URL, Mount: "secret", Token: "token-test"})
+	names, err := VaultTenantNames(context.Background(), client, "go-stytch-demo")
+	require.NoError(t, err)
+	require.Equal(t, []string{"acme", "globex"}, names)
+
+	provider := NewVaultTenantConfigProvider(client, "go-stytch-demo", "acme")
+	setupConf, err := provider.Load()
+	require.NoError(t, err)
+	require.Equal(t, "acme", setupConf.OrganizationSlug)
+	require.Equal(t, []SAMLAttribute{{Name: "email", StytchField: "email", Expression: "user.email"}}, setupConf.Attributes)
+
+	setupConf.OrganizationID = "organization-test-acme"
+	require.NoError(t, provider.Save())
+	require.Len(t, fake.versions["go-stytch-demo/tenants/acme/result"], 1)
+
+	names, err = VaultTenantNames(context.Background(), client, "other")
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestVaultLock(t *testing.T) {
+	_, server := newFakeVault(t)
+	client := NewVaultClient(&VaultConf{Address: server.URL, Mount: "secret", Token: "token-test"})
+	lock := NewVaultLock(client, "go-stytch-demo")
+	ctx := context.Background()
+
+	unlock, err := lock.Lock(ctx, "go-stytch-demo setup", 0)
+	require.NoError(t, err)
+
+	_, err = NewVaultLock(client, "go-stytch-demo").Lock(ctx, "go-stytch-demo setup destroy", 0)
+	var locked *StateLockedError
+	require.True(t, errors.As(err, &locked))
+	require.Equal(t, "vault://secret/go-stytch-demo", locked.Path)
+	require.Equal(t, "go-stytch-demo setup", locked.Holder.Command)
+
+	require.NoError(t, unlock())
+	holder, err := lock.LockHolder()
+	require.NoError(t, err)
+	require.Nil(t, holder)
+
+	_, err = lock.Lock(ctx, "go-stytch-demo setup", 0)
+	require.NoError(t, err)
+	holder, err = lock.ForceUnlock()
+	require.NoError(t, err)
+	require.Equal(t, "go-stytch-demo setup", holder.Command)
+	holder, err = lock.ForceUnlock()
+	require.NoError(t, err)
+	require.Nil(t, holder)
+}
+
+func TestNewVaultClientConfig(t *testing.T) {
+	fake, server := newFakeVault(t)
+	fake.put("go-stytch-demo/clients", map[string]any{
+		"STYTCH_PROJECT_ID": "project-test-vault",
+		"STYTCH_SECRET":     "secret-test-vault",
+		"OKTA_API_TOKEN":    "okta-token-vault",
+	})
+	t.Setenv("STYTCH_PROJECT_ID", "project-test-env")
+
+	v := viper.New()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	client := NewVaultClient(&VaultConf{Address: server.URL, Mount: "secret", Token: "token-test"})
+	conf, err := NewVaultClientConfig(context.Background(), client, "go-stytch-demo", v)
+	require.NoError(t, err)
+
+	// The environment keeps precedence over Vault
+	require.Equal(t, "project-test-env", conf.StytchConf.ProjectID)
+	require.Equal(t, "secret-test-vault", conf.StytchConf.Secret)
+	require.Equal(t, "okta-token-vault", conf.OktaConf.APIToken)
+	require.Nil(t, conf.EntraConf)
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	_, server := newFakeVault(t)
+	client := NewVaultClient(&VaultConf{Address: server.URL, Mount: "secret", Token: "token-test"})
+	store := NewVaultSecretStore(client, "go-stytch-demo")
+
+	value, err := store.GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Empty(t, value)
+
+	require.NoError(t, store.SetSecret("scim/scim-connection-test/bearer_token", "token-scim"))
+	require.NoError(t, store.SetSecret("oidc/oidc-connection-test/client_secret", "secret-oidc"))
+
+	// Another machine reads the secrets from Vault
+	other := NewVaultSecretStore(NewVaultClient(&VaultConf{Address: server.URL, Mount: "secret", Token: "token-test"}), "go-stytch-demo")
+	value, err = other.GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Equal(t, "token-scim", value)
+
+	require.NoError(t, other.DeleteSecret("scim/scim-connection-test/bearer_token"))
+	require.NoError(t, other.DeleteSecret("scim/scim-connection-test/bearer_token"))
+	value, err = store.GetSecret("scim/scim-connection-test/bearer_token")
+	require.NoError(t, err)
+	require.Empty(t, value)
+	value, err = store.GetSecret("oidc/oidc-connection-test/client_secret")
+	require.NoError(t, err)
+	require.Equal(t, "secret-oidc", value)
+}
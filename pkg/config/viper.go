@@ -1,29 +1,16 @@
 package config
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
 	"github.com/spf13/viper"
 )
 
-// NewSetupConfig is a wrapper that will load both SetupResult and SetupInput
-func NewSetupConfig(v *viper.Viper) (*SetupConfig, error) {
-	in, err := NewSetupInput(v)
-	if err != nil {
-		return nil, err
-	}
-
-	out, err := NewSetupResult(v)
-	if err != nil {
-		return nil, err
-	}
-
-	return &SetupConfig{
-		in, out,
-	}, nil
-}
-
-// NewSetupResult reads the existing configuration files with viper
-// This files would only exist of the setup phase is completed
-// An incpmplete setup would also genrate the file with only the value optained
+// NewSetupResult reads the results saved in the configuration file with viper
+// Earlier releases saved them there, they are now imported from it until the state file exists
 func NewSetupResult(v *viper.Viper) (*SetupResult, error) {
 	var C SetupResult
 	err := v.Unmarshal(&C)
@@ -33,51 +20,213 @@ func NewSetupResult(v *viper.Viper) (*SetupResult, error) {
 // NewSetupInput offers the option to customise the inputs for this tutoral
 // Those have default values but can be overriden via the config file
 func NewSetupInput(v *viper.Viper) (*SetupInput, error) {
+	return newSetupInput(v, "Example SAML App", "example-saml-app", nil)
+}
+
+func newSetupInput(v *viper.Viper, name, slug string, attributes []SAMLAttribute) (*SetupInput, error) {
 	var C SetupInput
 
-	v.SetDefault("okta.SAMLAppLabel", "Example SAML App")
-	v.SetDefault("stytch.OrganizationName", "Example SAML App")
-	v.SetDefault("stytch.OrganizationSlug", "example-saml-app")
+	v.SetDefault("okta.SAMLAppLabel", name)
+	v.SetDefault("stytch.OrganizationName", name)
+	v.SetDefault("stytch.OrganizationSlug", slug)
 	v.SetDefault("stytch.ConnectionDisplayName", "Okta")
+	v.SetDefault("scim.connection_display_name", "Okta SCIM")
+	v.SetDefault("oidc.connection_display_name", "Okta OIDC")
+	v.SetDefault("oidc.app_label", name+" OIDC")
 
-	err := v.Unmarshal(&C)
-	return &C, err
+	if err := v.Unmarshal(&C); err != nil {
+		return &C, err
+	}
+
+	if len(C.Attributes) == 0 {
+		C.Attributes = attributes
+	}
+	if len(C.Attributes) == 0 {
+		C.Attributes = DefaultSAMLAttributes()
+	}
+
+	if err := ValidateSAMLAttributes(C.Attributes); err != nil {
+		return &C, fmt.Errorf("invalid attributes mapping: %w", err)
+	}
+
+	if err := ValidateOrganizationSettings(&C.Settings); err != nil {
+		return &C, fmt.Errorf("invalid organization settings: %w", err)
+	}
+
+	return &C, nil
 }
 
+// viperMu serializes access to the global viper, tenants are loaded and saved concurrently
+var viperMu sync.Mutex
+
 // ViperConfigProvider implement the setup.ConfigProvider interface
-// This allows us to use viper to lead and persiste setup data
+// The inputs are read with viper from the config file, which is never written
+// The result is persisted in the state file
 type ViperConfigProvider struct {
-	data *SetupConfig
+	State StateStore
+	data  *SetupConfig
+}
+
+func NewViperConfigProvider(state StateStore) *ViperConfigProvider {
+	return &ViperConfigProvider{
+		State: state,
+	}
 }
 
 func (c *ViperConfigProvider) Load() (*SetupConfig, error) {
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
 	v := viper.GetViper()
-	data, err := NewSetupConfig(v)
-	c.data = data
-	return data, err
+	in, err := NewSetupInput(v)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := loadResult(c.State, v, "")
+	if err != nil {
+		return nil, err
+	}
+
+	c.data = &SetupConfig{in, out}
+	return c.data, nil
 }
 
 func (c *ViperConfigProvider) Save() error {
+	return saveResult(c.State, "", c.data.SetupResult)
+}
+
+// ViperTenantConfigProvider implement the setup.ConfigProvider interface for one entry of the tenants section
+// Each tenant reads its inputs under tenants.<name> of the config file and persists its result under the same name in the state file
+type ViperTenantConfigProvider struct {
+	Tenant string
+	State  StateStore
+	data   *SetupConfig
+}
+
+func NewViperTenantConfigProvider(state StateStore, tenant string) *ViperTenantConfigProvider {
+	return &ViperTenantConfigProvider{
+		Tenant: tenant,
+		State:  state,
+	}
+}
+
+func (c *ViperTenantConfigProvider) Load() (*SetupConfig, error) {
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
 	v := viper.GetViper()
+	sub, err := tenantViper(v, c.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tenants share the root attributes section unless they define their own
+	var attributes []SAMLAttribute
+	if err := v.UnmarshalKey("attributes", &attributes); err != nil {
+		return nil, err
+	}
+
+	in, err := newSetupInput(sub, c.Tenant, c.Tenant, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", c.Tenant, err)
+	}
+
+	out, err := loadResult(c.State, sub, c.Tenant)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", c.Tenant, err)
+	}
 
-	// This remove secrets from the config written
-	v.Set("stytch.project_id", "<redacted>")
-	v.Set("stytch.secret", "<redacted>")
-	v.Set("stytch.project_public_id", "<redacted>")
-	v.Set("okta.org_url", "<redacted>")
-	v.Set("okta.api_token", "<redacted>")
+	c.data = &SetupConfig{in, out}
+	return c.data, nil
+}
+
+func (c *ViperTenantConfigProvider) Save() error {
+	return saveResult(c.State, c.Tenant, c.data.SetupResult)
+}
+
+// LoadSetupResult reads the result of a setup without tenants, for the commands using the resources created by setup
+func LoadSetupResult(state StateStore, v *viper.Viper) (*SetupResult, error) {
+	return loadResult(state, v, "")
+}
+
+// loadResult reads the result of the tenant from the state
+// Until the tenant has a result in the state, the one saved in the config file by earlier releases is imported
+// The import is per tenant since the tenants are saved one by one
+func loadResult(store StateStore, v *viper.Viper, tenant string) (*SetupResult, error) {
+	state, err := store.Read()
+	if err != nil {
+		return nil, err
+	}
 
-	// Update the config
-	v.Set("stytch.organization_id", c.data.StytchResult.OrganizationID)
-	v.Set("stytch.connection_id", c.data.StytchResult.ConnectionID)
-	v.Set("okta.application_id", c.data.OktaResult.ApplicationID)
-	if c.data.StytchResult.SsoParameters != nil {
-		v.Set("stytch.sso_parameters.acsurl", c.data.StytchResult.SsoParameters.AcsUrl)
-		v.Set("stytch.sso_parameters.audience", c.data.StytchResult.SsoParameters.Audience)
+	if state != nil {
+		if result := state.TenantResult(tenant); result != nil {
+			return result, nil
+		}
 	}
+	return NewSetupResult(v)
+}
 
-	v.SafeWriteConfig()
-	return v.WriteConfig()
+func saveResult(store StateStore, tenant string, result *SetupResult) error {
+	return store.Update(func(state *State) error {
+		state.SetTenantResult(tenant, result)
+		return nil
+	})
+}
+
+// tenantViper builds a viper holding only the tenant section
+// viper.Sub is not used since it ignores the values already saved with Set in that section
+func tenantViper(v *viper.Viper, tenant string) (*viper.Viper, error) {
+	tenants, _ := v.AllSettings()[tenantsKey].(map[string]any)
+	section, ok := tenants[tenant].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("tenant %s not found in the %s section", tenant, tenantsKey)
+	}
+
+	sub := viper.New()
+	if err := sub.MergeConfigMap(section); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// tenantsKey is the section of the config file listing the tenants to bootstrap
+const tenantsKey = "tenants"
+
+// TenantNames lists the tenants of the config file, sorted by name
+func TenantNames(v *viper.Viper) []string {
+	viperMu.Lock()
+	defer viperMu.Unlock()
+
+	tenants := v.GetStringMap(tenantsKey)
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRetryConf reads the retry section of the config file
+func NewRetryConf(v *viper.Viper) (*RetryConf, error) {
+	var C RetryConf
+
+	v.SetDefault("retry.max_attempts", 5)
+	v.SetDefault("retry.initial_backoff", "1s")
+	v.SetDefault("retry.max_backoff", "30s")
+
+	if err := v.UnmarshalKey("retry", &C); err != nil {
+		return &C, err
+	}
+
+	if C.MaxAttempts < 1 {
+		return &C, fmt.Errorf("invalid retry.max_attempts %d, it must be at least 1", C.MaxAttempts)
+	}
+	if C.InitialBackoff <= 0 || C.MaxBackoff < C.InitialBackoff {
+		return &C, fmt.Errorf("invalid retry backoff, expected 0 < initial_backoff (%s) <= max_backoff (%s)", C.InitialBackoff, C.MaxBackoff)
+	}
+
+	return &C, nil
 }
 
 // NewClientConfig uses Viper to read secret from environement varaibles
@@ -85,12 +234,27 @@ func (c *ViperConfigProvider) Save() error {
 func NewClientConfig(v *viper.Viper) (*ClientsConf, error) {
 	var C ClientsConf
 
-	v.BindEnv("stytch.project_id")
-	v.BindEnv("stytch.secret")
-	v.BindEnv("stytch.project_public_id")
-	v.BindEnv("okta.org_url")
-	v.BindEnv("okta.api_token")
+	for _, key := range clientKeys {
+		v.BindEnv(key)
+	}
 
 	err := v.Unmarshal(&C)
 	return &C, err
 }
+
+// clientKeys are the keys of the client credentials, each one is read from the environment variable named after it
+var clientKeys = []string{
+	"stytch.project_id",
+	"stytch.secret",
+	"stytch.project_public_id",
+	"okta.org_url",
+	"okta.api_token",
+	"entra.tenant_id",
+	"entra.client_id",
+	"entra.client_secret",
+}
+
+// clientEnvName is the environment variable of a client key, e.g. STYTCH_PROJECT_ID
+func clientEnvName(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -69,6 +71,9 @@ func TestNewSetupResult(t *testing.T) {
 					ApplicationID: "0oada53uqsswV59o9697",
 					SsoParameters: nil,
 				},
+				SCIMResult{},
+				OIDCResult{},
+				nil,
 			},
 		},
 	}
@@ -103,3 +108,54 @@ func setupViper(t *testing.T) *viper.Viper {
 
 	return v
 }
+
+func TestViperTenantConfigProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "setup.yaml")
+	input := []byte(`
+tenants:
+  acme:
+    stytch:
+      organizationname: Acme Corp
+  globex:
+    stytch:
+      organization_id: organization-test-globex
+`)
+	require.NoError(t, os.WriteFile(path, input, 0o600))
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile(path)
+	require.NoError(t, viper.ReadInConfig())
+
+	require.Equal(t, []string{"acme", "globex"}, TenantNames(viper.GetViper()))
+
+	state := NewFileStateStore(filepath.Join(dir, StateFile))
+	acme := NewViperTenantConfigProvider(state, "acme")
+	conf, err := acme.Load()
+	require.NoError(t, err)
+	require.Equal(t, "Acme Corp", conf.OrganizationName)
+	require.Equal(t, "acme", conf.OrganizationSlug)
+	require.Equal(t, DefaultSAMLAttributes(), conf.Attributes)
+
+	conf.StytchResult.OrganizationID = "organization-test-acme"
+	require.NoError(t, acme.Save())
+
+	// The config file is only read, the result is scoped to the tenant in the state file
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, input, written)
+
+	conf, err = NewViperTenantConfigProvider(state, "acme").Load()
+	require.NoError(t, err)
+	require.Equal(t, "Acme Corp", conf.OrganizationName)
+	require.Equal(t, "organization-test-acme", conf.StytchResult.OrganizationID)
+
+	// The results of the config file are imported until the tenant has its own in the state file
+	conf, err = NewViperTenantConfigProvider(state, "globex").Load()
+	require.NoError(t, err)
+	require.Equal(t, "organization-test-globex", conf.StytchResult.OrganizationID)
+
+	_, err = NewViperTenantConfigProvider(state, "initech").Load()
+	require.EqualError(t, err, "tenant initech not found in the tenants section")
+}
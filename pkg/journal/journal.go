@@ -0,0 +1,214 @@
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces sensitive values in the recorded payloads
+const Redacted = "<redacted>"
+
+// Entry is one mutating API call recorded in the journal
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	Step   string    `json:"step,omitempty"`
+	API    string    `json:"api"`
+	// Targets are the IDs of the resources the call acts on (organization_id, connection_id, application_id...)
+	Targets map[string]string `json:"targets,omitempty"`
+	// Request is the payload sent, sensitive fields are redacted
+	Request any `json:"request,omitempty"`
+	// Result holds the IDs of the created resources
+	Result map[string]string `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// Journal appends entries to a JSONL file, it is never rewritten
+// A nil Journal records nothing so the callers do not need to check whether journaling is enabled
+type Journal struct {
+	Path   string
+	Tenant string
+
+	// mu is shared by the journals of every tenant writing the same file
+	mu *sync.Mutex
+}
+
+func New(path string) *Journal {
+	return &Journal{
+		Path: path,
+		mu:   &sync.Mutex{},
+	}
+}
+
+// WithTenant returns a journal writing to the same file, tagging the entries with the tenant
+func (j *Journal) WithTenant(tenant string) *Journal {
+	if j == nil {
+		return nil
+	}
+	tenantJournal := *j
+	tenantJournal.Tenant = tenant
+	return &tenantJournal
+}
+
+type stepKey struct{}
+
+// WithStep tags the entries recorded with ctx with the name of the operation in progress
+func WithStep(ctx context.Context, step string) context.Context {
+	return context.WithValue(ctx, stepKey{}, step)
+}
+
+// Record appends the outcome of an API call
+// The provisioning already happened when it is called, so a journal failure is only logged
+func (j *Journal) Record(ctx context.Context, api string, targets map[string]string, request any, result map[string]string, err error) {
+	if j == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:    time.Now().UTC(),
+		Tenant:  j.Tenant,
+		API:     api,
+		Targets: targets,
+		Result:  result,
+	}
+	entry.Step, _ = ctx.Value(stepKey{}).(string)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	if request != nil {
+		redacted, redactErr := Redact(request)
+		if redactErr != nil {
+			redacted = fmt.Sprintf("unable to render the request: %s", redactErr)
+		}
+		entry.Request = redacted
+	}
+
+	if err := j.append(entry); err != nil {
+		log.Printf("error writing journal %s: %s", j.Path, err)
+	}
+}
+
+func (j *Journal) append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(j.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter selects the entries returned by Read, zero values match everything
+type Filter struct {
+	Tenant string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f Filter) match(entry Entry) bool {
+	switch {
+	case f.Tenant != "" && entry.Tenant != f.Tenant:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && entry.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+// Read returns the entries of the journal file matching the filter, in the order they were recorded
+// A missing journal has no entries
+func Read(path string, filter Filter) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	// Requests carry certificates, lines are longer than the default 64KB token
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid journal entry: %w", path, n, err)
+		}
+		if filter.match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Redact returns a JSON compatible copy of payload with the sensitive fields masked
+func Redact(payload any) (any, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	return redact(doc), nil
+}
+
+func redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if isSensitiveKey(k) {
+				v[k] = Redacted
+				continue
+			}
+			v[k] = redact(val)
+		}
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"secret", "token", "password", "private_key"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
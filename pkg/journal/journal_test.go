@@ -0,0 +1,74 @@
+package journal
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal_RecordAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "setup.journal.jsonl")
+	j := New(path)
+
+	ctx := WithStep(context.Background(), "create-organization")
+	j.WithTenant("acme").Record(ctx, "Stytch Organizations.Create", nil,
+		map[string]any{"organization_slug": "acme", "client_secret": "s3cr3t"},
+		map[string]string{"organization_id": "organization-test-acme"}, nil)
+	j.WithTenant("globex").Record(ctx, "Stytch Organizations.Create", nil, nil, nil, errors.New("duplicate slug"))
+
+	var nilJournal *Journal
+	nilJournal.Record(ctx, "Stytch Organizations.Create", nil, nil, nil, nil)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{
+			name: "all entries",
+			want: []string{"acme", "globex"},
+		},
+		{
+			name:   "by tenant",
+			filter: Filter{Tenant: "globex"},
+			want:   []string{"globex"},
+		},
+		{
+			name:   "time range excludes everything",
+			filter: Filter{Until: time.Now().Add(-time.Hour)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := Read(path, tt.filter)
+			require.NoError(t, err)
+
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Tenant)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	entries, err := Read(path, Filter{Tenant: "acme"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "create-organization", entries[0].Step)
+	require.Equal(t, map[string]any{"organization_slug": "acme", "client_secret": Redacted}, entries[0].Request)
+	require.Equal(t, "organization-test-acme", entries[0].Result["organization_id"])
+
+	entries, err = Read(path, Filter{Tenant: "globex"})
+	require.NoError(t, err)
+	require.Equal(t, "duplicate slug", entries[0].Error)
+}
+
+func TestRead_MissingJournal(t *testing.T) {
+	entries, err := Read(filepath.Join(t.TempDir(), "missing.jsonl"), Filter{})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
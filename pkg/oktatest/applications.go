@@ -0,0 +1,335 @@
+package oktatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v4/okta"
+)
+
+// application is the JSON object of an application as sent by the client, plus the fields owned by Okta
+type application struct {
+	body        map[string]any
+	keys        []*signingKey
+	externalKey string
+	// provisioning is set through the connections and features APIs
+	provisioning provisioning
+}
+
+// groupFilterTypes are the filters accepted by Okta on GROUP attribute statements
+var groupFilterTypes = []string{"STARTS_WITH", "EQUALS", "CONTAINS", "REGEX"}
+
+func (s *Server) createApplication(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.", err.Error())
+		return
+	}
+	if causes := validate(body); len(causes) > 0 {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: "+strings.Join(causes, ", "), causes...)
+		return
+	}
+
+	key, err := newSigningKey(10)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "E0000009", "Internal Server Error", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	id := newID("0oa", 20)
+	app := &application{body: body, keys: []*signingKey{key}, externalKey: newID("exk", 20)}
+
+	status := "ACTIVE"
+	if r.URL.Query().Get("activate") == "false" {
+		status = "INACTIVE"
+	}
+	body["id"] = id
+	body["name"] = "oktatest_" + slug(lookup(body, "label")) + "_1"
+	body["status"] = status
+	body["created"] = now
+	body["lastUpdated"] = now
+	object(object(body, "credentials"), "signing")["kid"] = key.kid
+	if lookup(body, "signOnMode") == signOnModeOIDC {
+		// The client credentials are generated by Okta, the client ID is the ID of the application
+		oauthClient := object(object(body, "credentials"), "oauthClient")
+		oauthClient["client_id"] = id
+		oauthClient["client_secret"] = newID("", 40)
+	} else {
+		body["_links"] = map[string]any{
+			"metadata": map[string]any{"href": fmt.Sprintf("%s/api/v1/apps/%s/sso/saml/metadata", s.URL, id), "type": "application/xml"},
+		}
+	}
+	s.applications[id] = app
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) getApplication(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, app.body)
+}
+
+// listApplications supports the q parameter, a case insensitive prefix of the name or label
+func (s *Server) listApplications(w http.ResponseWriter, r *http.Request) {
+	q := strings.ToLower(r.URL.Query().Get("q"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	apps := []map[string]any{}
+	for _, app := range s.applications {
+		if strings.HasPrefix(strings.ToLower(lookup(app.body, "label")), q) || strings.HasPrefix(lookup(app.body, "name"), q) {
+			apps = append(apps, app.body)
+		}
+	}
+	slices.SortFunc(apps, func(a, b map[string]any) int {
+		return strings.Compare(lookup(a, "created"), lookup(b, "created"))
+	})
+	writeJSON(w, http.StatusOK, apps)
+}
+
+// replaceApplication replaces the application, except for the fields owned by Okta
+// The signing key can be switched by setting credentials.signing.kid to a generated key
+func (s *Server) replaceApplication(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+
+	causes := validate(body)
+	kid := lookup(body, "credentials", "signing", "kid")
+	if kid == "" {
+		kid = lookup(app.body, "credentials", "signing", "kid")
+	}
+	if app.key(kid) == nil {
+		causes = append(causes, "credentials.signing.kid: Key not found")
+	}
+	if len(causes) > 0 {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: "+strings.Join(causes, ", "), causes...)
+		return
+	}
+
+	for _, field := range []string{"id", "name", "status", "created", "_links"} {
+		body[field] = app.body[field]
+	}
+	body["lastUpdated"] = time.Now().UTC().Format(time.RFC3339)
+	object(object(body, "credentials"), "signing")["kid"] = kid
+	app.body = body
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) activateApplication(w http.ResponseWriter, r *http.Request) {
+	s.setStatus(w, r.PathValue("app_id"), "ACTIVE")
+}
+
+func (s *Server) deactivateApplication(w http.ResponseWriter, r *http.Request) {
+	s.setStatus(w, r.PathValue("app_id"), "INACTIVE")
+}
+
+func (s *Server) setStatus(w http.ResponseWriter, id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, id)
+	if !ok {
+		return
+	}
+	app.body["status"] = status
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+// deleteApplication refuses to delete an active application, like Okta
+func (s *Server) deleteApplication(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+	if app.body["status"] == "ACTIVE" {
+		writeError(w, http.StatusForbidden, "E0000056", "Delete application forbidden.", "The application must be deactivated first.")
+		return
+	}
+
+	delete(s.applications, r.PathValue("app_id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// application looks up an application, writing the Okta 404 when it does not exist
+func (s *Server) application(w http.ResponseWriter, id string) (*application, bool) {
+	app, ok := s.applications[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "E0000007", fmt.Sprintf("Not found: Resource not found: %s (AppInstance)", id))
+	}
+	return app, ok
+}
+
+// Sign on modes supported by the fake
+const (
+	signOnModeSAML = "SAML_2_0"
+	signOnModeOIDC = "OPENID_CONNECT"
+)
+
+// validate checks the fields Okta requires on a SAML or OIDC application, it returns the error causes
+func validate(body map[string]any) []string {
+	var causes []string
+	if lookup(body, "label") == "" {
+		causes = append(causes, "label: The field cannot be left blank")
+	}
+
+	switch mode := lookup(body, "signOnMode"); mode {
+	case signOnModeSAML:
+		return append(causes, validateSAML(body)...)
+	case signOnModeOIDC:
+		return append(causes, validateOIDC(body)...)
+	default:
+		return append(causes, fmt.Sprintf("signOnMode: %q is not supported by oktatest, only %s and %s are", mode, signOnModeSAML, signOnModeOIDC))
+	}
+}
+
+// validateOIDC checks a web application using the authorization code flow
+func validateOIDC(body map[string]any) []string {
+	var causes []string
+	settings, _ := body["settings"].(map[string]any)
+	oauthClient, _ := settings["oauthClient"].(map[string]any)
+	if lookup(oauthClient, "application_type") != "web" {
+		causes = append(causes, "settings.oauthClient.application_type: only web is supported by oktatest")
+	}
+	if uris, _ := oauthClient["redirect_uris"].([]any); len(uris) == 0 {
+		causes = append(causes, "settings.oauthClient.redirect_uris: The field cannot be left blank")
+	}
+	if grants, _ := oauthClient["grant_types"].([]any); !slices.Contains(grants, any("authorization_code")) {
+		causes = append(causes, "settings.oauthClient.grant_types: authorization_code is required for a web application")
+	}
+	return causes
+}
+
+// validateSAML checks the sign on settings and attribute statements of a SAML application
+func validateSAML(body map[string]any) []string {
+	var causes []string
+	for _, field := range []string{"ssoAcsUrl", "audience"} {
+		if lookup(body, "settings", "signOn", field) == "" {
+			causes = append(causes, fmt.Sprintf("settings.signOn.%s: The field cannot be left blank", field))
+		}
+	}
+
+	settings, _ := body["settings"].(map[string]any)
+	signOn, _ := settings["signOn"].(map[string]any)
+	statements, _ := signOn["attributeStatements"].([]any)
+	for i, raw := range statements {
+		statement, _ := raw.(map[string]any)
+		field := fmt.Sprintf("settings.signOn.attributeStatements[%d]", i)
+		if lookup(statement, "name") == "" {
+			causes = append(causes, field+".name: The field cannot be left blank")
+		}
+
+		switch lookup(statement, "type") {
+		case "GROUP":
+			if !slices.Contains(groupFilterTypes, lookup(statement, "filterType")) {
+				causes = append(causes, fmt.Sprintf("%s.filterType: must be one of %s", field, strings.Join(groupFilterTypes, ", ")))
+			}
+			if lookup(statement, "filterValue") == "" {
+				causes = append(causes, field+".filterValue: The field cannot be left blank")
+			} else if lookup(statement, "filterType") == "REGEX" {
+				if _, err := regexp.Compile(lookup(statement, "filterValue")); err != nil {
+					causes = append(causes, fmt.Sprintf("%s.filterValue: %v", field, err))
+				}
+			}
+		case "EXPRESSION":
+			if values, _ := statement["values"].([]any); len(values) == 0 {
+				causes = append(causes, field+".values: The field cannot be left blank")
+			}
+		default:
+			causes = append(causes, field+".type: must be EXPRESSION or GROUP")
+		}
+	}
+	return causes
+}
+
+// Application returns a copy of an application decoded like the SDK does, for assertions
+func (s *Server) Application(id string) (*okta.SamlApplication, bool) {
+	decoded, ok := s.decodedApplication(id)
+	if !ok {
+		return nil, false
+	}
+	return decoded.SamlApplication, decoded.SamlApplication != nil
+}
+
+func (s *Server) decodedApplication(id string) (*okta.ListApplications200ResponseInner, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.applications[id]
+	if !ok {
+		return nil, false
+	}
+
+	raw, err := json.Marshal(app.body)
+	if err != nil {
+		return nil, false
+	}
+	var decoded okta.ListApplications200ResponseInner
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, false
+	}
+	return &decoded, true
+}
+
+// OIDCApplication returns a copy of an OIDC application decoded like the SDK does, for assertions
+func (s *Server) OIDCApplication(id string) (*okta.OpenIdConnectApplication, bool) {
+	decoded, ok := s.decodedApplication(id)
+	if !ok {
+		return nil, false
+	}
+	return decoded.OpenIdConnectApplication, decoded.OpenIdConnectApplication != nil
+}
+
+// lookup returns the string found at path in a JSON object, empty when missing
+func lookup(m map[string]any, path ...string) string {
+	for _, key := range path[:len(path)-1] {
+		m, _ = m[key].(map[string]any)
+	}
+	value, _ := m[path[len(path)-1]].(string)
+	return value
+}
+
+// object returns the JSON object at key, creating it when missing
+func object(m map[string]any, key string) map[string]any {
+	child, ok := m[key].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		m[key] = child
+	}
+	return child
+}
+
+// slug formats a label like the application names generated by Okta
+func slug(label string) string {
+	return strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(label), "_"), "_")
+}
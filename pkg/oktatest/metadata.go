@@ -0,0 +1,175 @@
+package oktatest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// signingKey is an application key credential, its certificate is the one published in the metadata
+type signingKey struct {
+	kid     string
+	key     *rsa.PrivateKey
+	der     []byte
+	created time.Time
+	expires time.Time
+}
+
+// newSigningKey generates a key and its self-signed certificate, subject mimics the Okta ones
+func newSigningKey(validityYears int) (*signingKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("error generating the signing key %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("error generating the certificate serial %w", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Country:            []string{"US"},
+			Province:           []string{"California"},
+			Locality:           []string{"San Francisco"},
+			Organization:       []string{"Okta"},
+			OrganizationalUnit: []string{"SSOProvider"},
+			CommonName:         "oktatest",
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(validityYears, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("error signing the certificate %w", err)
+	}
+
+	return &signingKey{kid: newKid(), key: key, der: der, created: now, expires: template.NotAfter}, nil
+}
+
+func (k *signingKey) certificate() string {
+	return base64.StdEncoding.EncodeToString(k.der)
+}
+
+// jwk renders the key as returned by the application credentials API
+func (k *signingKey) jwk() map[string]any {
+	thumbprint := sha256.Sum256(k.der)
+	return map[string]any{
+		"kid":         k.kid,
+		"kty":         "RSA",
+		"use":         "sig",
+		"alg":         "RS256",
+		"e":           base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+		"n":           base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+		"x5c":         []string{k.certificate()},
+		"x5t#S256":    base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		"created":     k.created.Format(time.RFC3339),
+		"lastUpdated": k.created.Format(time.RFC3339),
+		"expiresAt":   k.expires.Format(time.RFC3339),
+	}
+}
+
+func (app *application) key(kid string) *signingKey {
+	for _, k := range app.keys {
+		if k.kid == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+// generateKey adds a key credential to the application, the application keeps signing with its current key
+// ref: https://developer.okta.com/docs/api/openapi/okta-management/management/tag/ApplicationSSOCredentialKey/
+func (s *Server) generateKey(w http.ResponseWriter, r *http.Request) {
+	validityYears, err := strconv.Atoi(r.URL.Query().Get("validityYears"))
+	if err != nil || validityYears < 2 || validityYears > 10 {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: validityYears", "validityYears: must be between 2 and 10")
+		return
+	}
+
+	key, err := newSigningKey(validityYears)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "E0000009", "Internal Server Error", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+	app.keys = append(app.keys, key)
+
+	writeJSON(w, http.StatusCreated, key.jwk())
+}
+
+// metadata serves the IdP metadata of the application with the certificate of its signing key
+// Like Okta, a kid query parameter previews the metadata for another key of the application
+func (s *Server) metadata(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+
+	kid := r.URL.Query().Get("kid")
+	if kid == "" {
+		kid = lookup(app.body, "credentials", "signing", "kid")
+	}
+	key := app.key(kid)
+	if key == nil {
+		writeError(w, http.StatusNotFound, "E0000007", fmt.Sprintf("Not found: Resource not found: %s (AppInstanceKeyCredential)", kid))
+		return
+	}
+
+	ssoURL := fmt.Sprintf("%s/app/%s/%s/sso/saml", s.URL, lookup(app.body, "name"), app.externalKey)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="http://www.okta.com/%s">`+
+		`<md:IDPSSODescriptor WantAuthnRequestsSigned="false" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">`+
+		`<md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`+
+		`<md:NameIDFormat>%s</md:NameIDFormat>`+
+		`<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="%s"/>`+
+		`<md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%s"/>`+
+		`</md:IDPSSODescriptor></md:EntityDescriptor>`,
+		app.externalKey, key.certificate(), nameIDFormat(app.body), ssoURL, ssoURL)
+}
+
+// SigningCertificate returns the base64 DER certificate the application currently signs with, for assertions
+func (s *Server) SigningCertificate(appID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.applications[appID]
+	if !ok {
+		return "", false
+	}
+	key := app.key(lookup(app.body, "credentials", "signing", "kid"))
+	if key == nil {
+		return "", false
+	}
+	return key.certificate(), true
+}
+
+func nameIDFormat(body map[string]any) string {
+	if format := lookup(body, "settings", "signOn", "subjectNameIdFormat"); format != "" {
+		return format
+	}
+	return "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+}
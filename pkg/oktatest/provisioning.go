@@ -0,0 +1,124 @@
+package oktatest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Provisioning is the SCIM provisioning configured on an application
+type Provisioning struct {
+	BaseURL string
+	Token   string
+	// Status of the provisioning connection, ENABLED once activated
+	Status string
+	// Capabilities of the USER_PROVISIONING feature
+	CreateUsers     bool
+	UpdateProfiles  bool
+	DeactivateUsers bool
+}
+
+// provisioning is the state of the provisioning connection and features of an application
+type provisioning struct {
+	connection map[string]any
+	status     string
+	feature    map[string]any
+}
+
+// setProvisioningConnection only accepts the TOKEN auth scheme, the one of SCIM bearer tokens
+func (s *Server) setProvisioningConnection(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.", err.Error())
+		return
+	}
+
+	var causes []string
+	if lookup(body, "profile", "authScheme") != "TOKEN" {
+		causes = append(causes, "profile.authScheme: only TOKEN is supported")
+	}
+	if lookup(body, "profile", "token") == "" {
+		causes = append(causes, "profile.token: The field cannot be left blank")
+	}
+	if baseURL := lookup(body, "baseUrl"); !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		causes = append(causes, "baseUrl: The URL is not valid")
+	}
+	if len(causes) > 0 {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: "+strings.Join(causes, ", "), causes...)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+
+	status := "DISABLED"
+	if r.URL.Query().Get("activate") == "true" {
+		status = "ENABLED"
+	}
+	app.provisioning.connection = body
+	app.provisioning.status = status
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"authScheme": "TOKEN",
+		"status":     status,
+	})
+}
+
+// updateFeature requires the provisioning connection, like Okta
+func (s *Server) updateFeature(w http.ResponseWriter, r *http.Request) {
+	if name := r.PathValue("feature_name"); name != "USER_PROVISIONING" {
+		writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+name+" (AppFeature)")
+		return
+	}
+
+	capabilities := map[string]any{}
+	if err := json.NewDecoder(r.Body).Decode(&capabilities); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.application(w, r.PathValue("app_id"))
+	if !ok {
+		return
+	}
+	if app.provisioning.status != "ENABLED" {
+		writeError(w, http.StatusBadRequest, "E0000001", "Api validation failed: provisioning connection is not enabled")
+		return
+	}
+
+	app.provisioning.feature = capabilities
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":         "USER_PROVISIONING",
+		"status":       "ENABLED",
+		"capabilities": capabilities,
+	})
+}
+
+// Provisioning returns the provisioning set on an application, for assertions
+func (s *Server) Provisioning(appID string) (Provisioning, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app, ok := s.applications[appID]
+	if !ok || app.provisioning.connection == nil {
+		return Provisioning{}, false
+	}
+
+	feature := app.provisioning.feature
+	return Provisioning{
+		BaseURL:         lookup(app.provisioning.connection, "baseUrl"),
+		Token:           lookup(app.provisioning.connection, "profile", "token"),
+		Status:          app.provisioning.status,
+		CreateUsers:     lookup(feature, "create", "lifecycleCreate", "status") == "ENABLED",
+		UpdateProfiles:  lookup(feature, "update", "profile", "status") == "ENABLED",
+		DeactivateUsers: lookup(feature, "update", "lifecycleDeactivate", "status") == "ENABLED",
+	}, true
+}
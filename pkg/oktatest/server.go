@@ -0,0 +1,145 @@
+// Package oktatest provides an in-memory fake of the Okta management API for offline tests
+//
+// The fake implements the application endpoints used by the Okta provider of the setup package:
+// creating, listing, reading, replacing, deactivating and deleting SAML and OIDC web applications, generating signing keys,
+// serving the SAML metadata of an application, which carries a test certificate generated for it,
+// and configuring SCIM provisioning through the connections and features APIs.
+package oktatest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/okta/okta-sdk-golang/v4/okta"
+)
+
+// Token is the API token accepted by the fake, Client uses it
+const Token = "okta-test-token"
+
+// Server is a fake Okta management API backed by an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	applications map[string]*application
+
+	requestsMu sync.Mutex
+	requests   []string
+}
+
+func NewServer() *Server {
+	s := &Server{
+		applications: map[string]*application{},
+	}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// Client returns an Okta client pointed at the fake, opts are applied after the defaults
+func (s *Server) Client(opts ...okta.ConfigSetter) (*okta.APIClient, error) {
+	opts = append([]okta.ConfigSetter{
+		okta.WithOrgUrl(s.URL),
+		okta.WithToken(Token),
+		okta.WithCache(false),
+	}, opts...)
+
+	cfg, err := okta.NewConfiguration(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SDK only keeps the hostname of the org URL, the fake listens on a random port
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Host = u.Host
+
+	return okta.NewAPIClient(cfg), nil
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /api/v1/apps", s.createApplication)
+	mux.HandleFunc("GET /api/v1/apps", s.listApplications)
+	mux.HandleFunc("GET /api/v1/apps/{app_id}", s.getApplication)
+	mux.HandleFunc("PUT /api/v1/apps/{app_id}", s.replaceApplication)
+	mux.HandleFunc("DELETE /api/v1/apps/{app_id}", s.deleteApplication)
+	mux.HandleFunc("POST /api/v1/apps/{app_id}/lifecycle/activate", s.activateApplication)
+	mux.HandleFunc("POST /api/v1/apps/{app_id}/lifecycle/deactivate", s.deactivateApplication)
+	mux.HandleFunc("POST /api/v1/apps/{app_id}/credentials/keys/generate", s.generateKey)
+	mux.HandleFunc("GET /api/v1/apps/{app_id}/sso/saml/metadata", s.metadata)
+	mux.HandleFunc("POST /api/v1/apps/{app_id}/connections/default", s.setProvisioningConnection)
+	mux.HandleFunc("PUT /api/v1/apps/{app_id}/features/{feature_name}", s.updateFeature)
+
+	return s.authenticated(mux)
+}
+
+// authenticated checks the API token like Okta
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "SSWS "+Token {
+			writeError(w, http.StatusUnauthorized, "E0000011", "Invalid token provided")
+			return
+		}
+		s.requestsMu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.requestsMu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requests returns the authenticated requests received so far, as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in the Okta error format
+// ref: https://developer.okta.com/docs/reference/error-codes/
+func writeError(w http.ResponseWriter, status int, code, summary string, causes ...string) {
+	errorCauses := []map[string]string{}
+	for _, cause := range causes {
+		errorCauses = append(errorCauses, map[string]string{"errorSummary": cause})
+	}
+
+	writeJSON(w, status, map[string]any{
+		"errorCode":    code,
+		"errorSummary": summary,
+		"errorLink":    code,
+		"errorId":      newID("oae", 22),
+		"errorCauses":  errorCauses,
+	})
+}
+
+// newID returns a random ID formatted like the Okta ones (prefix followed by alphanumerics)
+func newID(prefix string, length int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	b := make([]byte, length-len(prefix))
+	rand.Read(b)
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return prefix + string(b)
+}
+
+// newKid returns a key ID formatted like the Okta ones (base64url)
+func newKid() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
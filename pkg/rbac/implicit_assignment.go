@@ -7,6 +7,7 @@ import (
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
 )
 
 // ref https://stytch.com/docs/b2b/guides/rbac/role-assignment#implicit-assignment
@@ -15,11 +16,13 @@ type StytchRBACConfig struct {
 	OrganizationID string
 	ConnectionID   string
 	Domain         string
+	// Journal records the updates, nil disables it
+	Journal *journal.Journal
 }
 
 // By email domain: everyone with the stytch.com email domain gets the “developer” Role.
 func ApplyOrganizationImplictAssignement(ctx context.Context, stytchClient *b2bstytchapi.API, conf *StytchRBACConfig) error {
-	_, err := stytchClient.Organizations.Update(ctx, &organizations.UpdateParams{
+	params := &organizations.UpdateParams{
 		OrganizationID: conf.OrganizationID,
 		RBACEmailImplicitRoleAssignments: []*organizations.EmailImplicitRoleAssignment{
 			{
@@ -27,7 +30,9 @@ func ApplyOrganizationImplictAssignement(ctx context.Context, stytchClient *b2bs
 				RoleID: "developer",
 			},
 		},
-	})
+	}
+	_, err := stytchClient.Organizations.Update(ctx, params)
+	conf.Journal.Record(ctx, "Stytch Organizations.Update", map[string]string{"organization_id": conf.OrganizationID}, params, nil, err)
 
 	if err != nil {
 		return err
@@ -38,7 +43,7 @@ func ApplyOrganizationImplictAssignement(ctx context.Context, stytchClient *b2bs
 
 // By SSO Connection: everyone who authenticates via a specific SSO Connection gets the “employee” Role.
 func ApplyConnectionImplictAssignement(ctx context.Context, stytchClient *b2bstytchapi.API, conf *StytchRBACConfig) error {
-	_, err := stytchClient.SSO.SAML.UpdateConnection(ctx, &saml.UpdateConnectionParams{
+	params := &saml.UpdateConnectionParams{
 		OrganizationID: conf.OrganizationID,
 		ConnectionID:   conf.ConnectionID,
 		SAMLConnectionImplicitRoleAssignments: []*sso.SAMLConnectionImplicitRoleAssignment{
@@ -46,7 +51,9 @@ func ApplyConnectionImplictAssignement(ctx context.Context, stytchClient *b2bsty
 				RoleID: "employee",
 			},
 		},
-	})
+	}
+	_, err := stytchClient.SSO.SAML.UpdateConnection(ctx, params)
+	conf.Journal.Record(ctx, "Stytch SSO.SAML.UpdateConnection", conf.targets(), params, nil, err)
 
 	if err != nil {
 		return err
@@ -57,7 +64,7 @@ func ApplyConnectionImplictAssignement(ctx context.Context, stytchClient *b2bsty
 
 // By SSO Connection IdP Group: everyone who authenticates via a specific SSO Connection and is a part of the “engineering” IdP group gets the “developer” Role.
 func ApplyConnectionSAMLGroupImplictAssignement(ctx context.Context, stytchClient *b2bstytchapi.API, conf *StytchRBACConfig) error {
-	_, err := stytchClient.SSO.SAML.UpdateConnection(ctx, &saml.UpdateConnectionParams{
+	params := &saml.UpdateConnectionParams{
 		OrganizationID: conf.OrganizationID,
 		ConnectionID:   conf.ConnectionID,
 		SAMLGroupImplicitRoleAssignments: []*sso.SAMLGroupImplicitRoleAssignment{
@@ -66,7 +73,9 @@ func ApplyConnectionSAMLGroupImplictAssignement(ctx context.Context, stytchClien
 				Group:  "billing",
 			},
 		},
-	})
+	}
+	_, err := stytchClient.SSO.SAML.UpdateConnection(ctx, params)
+	conf.Journal.Record(ctx, "Stytch SSO.SAML.UpdateConnection", conf.targets(), params, nil, err)
 
 	if err != nil {
 		return err
@@ -74,3 +83,10 @@ func ApplyConnectionSAMLGroupImplictAssignement(ctx context.Context, stytchClien
 
 	return nil
 }
+
+func (conf *StytchRBACConfig) targets() map[string]string {
+	return map[string]string{
+		"organization_id": conf.OrganizationID,
+		"connection_id":   conf.ConnectionID,
+	}
+}
@@ -0,0 +1,72 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+	"github.com/xNok/go-stytch-demo/pkg/stytchtest"
+)
+
+func TestImplicitAssignments_FakeStytch(t *testing.T) {
+	fake := stytchtest.NewServer()
+	defer fake.Close()
+
+	client, err := fake.Client()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	org, err := client.Organizations.Create(ctx, &organizations.CreateParams{OrganizationName: "Acme Corp", OrganizationSlug: "acme"})
+	require.NoError(t, err)
+	conn, err := client.SSO.SAML.CreateConnection(ctx, &saml.CreateConnectionParams{OrganizationID: org.Organization.OrganizationID})
+	require.NoError(t, err)
+	_, err = client.SSO.SAML.UpdateConnection(ctx, &saml.UpdateConnectionParams{
+		OrganizationID:  org.Organization.OrganizationID,
+		ConnectionID:    conn.Connection.ConnectionID,
+		IdpEntityID:     "http://www.okta.com/exk0000000000000000",
+		IdpSSOURL:       "https://dev-000000.okta.com/app/exk0000000000000000/sso/saml",
+		X509Certificate: "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----",
+	})
+	require.NoError(t, err)
+
+	conf := &StytchRBACConfig{
+		OrganizationID: org.Organization.OrganizationID,
+		ConnectionID:   conn.Connection.ConnectionID,
+		Domain:         "acme.com",
+	}
+	require.NoError(t, ApplyOrganizationImplictAssignement(ctx, client, conf))
+	require.NoError(t, ApplyConnectionImplictAssignement(ctx, client, conf))
+	require.NoError(t, ApplyConnectionSAMLGroupImplictAssignement(ctx, client, conf))
+
+	tests := []struct {
+		name   string
+		email  string
+		groups []string
+		want   []string
+	}{
+		{
+			name:  "email domain and connection",
+			email: "jane@acme.com",
+			want:  []string{stytchtest.StytchMemberRole, "developer", "employee"},
+		},
+		{
+			name:   "connection and group",
+			email:  "john@contractor.com",
+			groups: []string{"billing"},
+			want:   []string{stytchtest.StytchMemberRole, "employee", "billing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := fake.SSOLogin(conf.ConnectionID, tt.email, tt.groups...)
+			require.NoError(t, err)
+
+			resp, err := client.SSO.Authenticate(ctx, &sso.AuthenticateParams{SSOToken: token})
+			require.NoError(t, err)
+			require.Equal(t, tt.want, resp.MemberSession.Roles)
+		})
+	}
+}
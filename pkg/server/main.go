@@ -16,9 +16,19 @@ type StytchServerConfig struct {
 	OrganizationID string
 	ConnectionID   string
 	PublicToken    string
+	// SessionDurationMinutes is requested on login, Stytch uses 60 minutes when it is 0
+	SessionDurationMinutes int32
 }
 
 func Serve(stytchClient *b2bstytchapi.API, conf *StytchServerConfig) {
+	router := NewRouter(stytchClient, conf)
+
+	// Start the server
+	http.ListenAndServe(":8010", router)
+}
+
+// NewRouter registers the SSO and authorization routes
+func NewRouter(stytchClient *b2bstytchapi.API, conf *StytchServerConfig) *mux.Router {
 	// create the router
 	router := mux.NewRouter()
 
@@ -29,8 +39,7 @@ func Serve(stytchClient *b2bstytchapi.API, conf *StytchServerConfig) {
 	router.HandleFunc("/authenticate", stytch.authenticate).Methods("GET")
 	router.HandleFunc("/can-i", stytch.canI).Methods("GET")
 
-	// Start the server
-	http.ListenAndServe(":8010", router)
+	return router
 }
 
 // StytchHandler implement the Backend Integration of SSO
@@ -87,7 +96,8 @@ func (h *StytchHandler) home(w http.ResponseWriter, r *http.Request) {
 // The route to this handler must match the configured RedirectURL
 func (h *StytchHandler) authenticate(w http.ResponseWriter, r *http.Request) {
 	resp, err := h.StytchClient.SSO.Authenticate(r.Context(), &sso.AuthenticateParams{
-		SSOToken: r.URL.Query().Get("token"),
+		SSOToken:               r.URL.Query().Get("token"),
+		SessionDurationMinutes: h.Configs.SessionDurationMinutes,
 	})
 
 	if err != nil {
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/rbac"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+	"github.com/xNok/go-stytch-demo/pkg/stytchtest"
+)
+
+func TestRouter_FakeStytch(t *testing.T) {
+	fake := stytchtest.NewServer()
+	defer fake.Close()
+	fake.Policy = &rbac.Policy{
+		Roles: []rbac.PolicyRole{
+			{RoleID: stytchtest.StytchMemberRole},
+			{RoleID: "employee", Permissions: []rbac.PolicyRolePermission{{ResourceID: "documents", Actions: []string{"read"}}}},
+		},
+	}
+
+	client, err := fake.Client()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	org, err := client.Organizations.Create(ctx, &organizations.CreateParams{OrganizationName: "Acme Corp", OrganizationSlug: "acme"})
+	require.NoError(t, err)
+	conn, err := client.SSO.SAML.CreateConnection(ctx, &saml.CreateConnectionParams{OrganizationID: org.Organization.OrganizationID})
+	require.NoError(t, err)
+	_, err = client.SSO.SAML.UpdateConnection(ctx, &saml.UpdateConnectionParams{
+		OrganizationID:                        org.Organization.OrganizationID,
+		ConnectionID:                          conn.Connection.ConnectionID,
+		IdpEntityID:                           "http://www.okta.com/exk0000000000000000",
+		IdpSSOURL:                             "https://dev-000000.okta.com/app/exk0000000000000000/sso/saml",
+		X509Certificate:                       "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----",
+		SAMLConnectionImplicitRoleAssignments: []*sso.SAMLConnectionImplicitRoleAssignment{{RoleID: "employee"}},
+	})
+	require.NoError(t, err)
+
+	app := httptest.NewServer(NewRouter(client, &StytchServerConfig{
+		OrganizationID: org.Organization.OrganizationID,
+		ConnectionID:   conn.Connection.ConnectionID,
+	}))
+	defer app.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	// The IdP redirects to /authenticate, which sets the session cookie and redirects to the home page
+	token, err := fake.SSOLogin(conn.Connection.ConnectionID, "jane@acme.com")
+	require.NoError(t, err)
+	resp, err := browser.Get(app.URL + "/authenticate?token=" + token)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var member organizations.Member
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&member))
+	require.Equal(t, "jane@acme.com", member.EmailAddress)
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+	}{
+		{
+			name:     "granted by the connection role",
+			query:    "?resource=documents&action=read",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "action not granted",
+			query:    "?resource=documents&action=delete",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "missing action",
+			query:    "?resource=documents",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := browser.Get(app.URL + "/can-i" + tt.query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			require.Equal(t, tt.wantCode, resp.StatusCode, string(body))
+		})
+	}
+
+	// A used SSO token is rejected
+	resp, err = browser.Get(app.URL + "/authenticate?token=" + token)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
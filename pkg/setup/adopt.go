@@ -0,0 +1,221 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/stytchauth/stytch-go/v12/stytch/stytcherror"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// SAMLApplicationFinder is implemented by providers able to look up an application by its label
+// It is used by Adopt to recover the application of a lost SetupResult
+type SAMLApplicationFinder interface {
+	// FindSAMLApplication returns the ID of the application with this exact label, or "" when there is none
+	FindSAMLApplication(ctx context.Context, label string) (string, error)
+}
+
+// Adopt searches the resources of a previous setup run by name and records them in the SetupResult
+// The organization is found by OrganizationSlug, the connection by ConnectionDisplayName
+// and the IdP application by SAMLAppLabel. The IDs already recorded are kept.
+// The update-connection step is left to run again so Setup reconciles the connection with the IdP.
+func (s *OktaSAMLConnectionBootstraper) Adopt(ctx context.Context) (*config.SetupResult, error) {
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	if err := s.adopt(ctx, conf); err != nil {
+		return nil, err
+	}
+
+	if err := s.ConfProvider.Save(); err != nil {
+		return nil, fmt.Errorf("error saving Configuration %w", err)
+	}
+
+	return conf.SetupResult, nil
+}
+
+func (s *OktaSAMLConnectionBootstraper) adopt(ctx context.Context, conf *config.SetupConfig) error {
+	if conf.Steps == nil {
+		conf.Steps = map[string]config.StepStatus{}
+	}
+
+	if conf.StytchResult.OrganizationID == "" {
+		organizationID, err := s.findStytchOrganisation(ctx, conf.StytchSetupInput.OrganizationSlug)
+		if err != nil {
+			return fmt.Errorf("error searching Organizations %w", err)
+		}
+		if organizationID == "" {
+			return nil
+		}
+		conf.StytchResult.OrganizationID = organizationID
+		markStepDone(conf, StepCreateOrganization)
+	}
+
+	if conf.StytchResult.ConnectionID == "" {
+		connection, err := s.findStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchSetupInput.ConnectionDisplayName)
+		if err != nil {
+			return fmt.Errorf("error searching SSO SAML Connections %w", err)
+		}
+		if connection == nil {
+			return nil
+		}
+		conf.StytchResult.ConnectionID = connection.ConnectionID
+		conf.StytchResult.SsoParameters = &config.StychSsoParameters{
+			AcsUrl:   connection.AcsURL,
+			Audience: connection.AudienceURI,
+		}
+		markStepDone(conf, StepCreateConnection)
+	}
+
+	// A connection recorded without its sso_parameters, e.g. by hand, is read back from Stytch
+	if conf.StytchResult.SsoParameters == nil {
+		connection, err := s.getStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchResult.ConnectionID)
+		if err != nil {
+			return fmt.Errorf("error fetching SSO SAML Connection %w", err)
+		}
+		conf.StytchResult.SsoParameters = &config.StychSsoParameters{
+			AcsUrl:   connection.AcsURL,
+			Audience: connection.AudienceURI,
+		}
+	}
+
+	finder, ok := s.IdP.(SAMLApplicationFinder)
+	if !ok || conf.OktaResult.ApplicationID != "" {
+		return nil
+	}
+
+	appID, err := finder.FindSAMLApplication(ctx, conf.OktaSetupInput.SAMLAppLabel)
+	if err != nil {
+		return fmt.Errorf("error searching %s Applications %w", s.IdP.Name(), err)
+	}
+	if appID == "" {
+		return nil
+	}
+
+	// An application with the same label may belong to another connection, it must point to this one
+	if reader, ok := s.IdP.(SAMLApplicationReader); ok {
+		app, err := reader.GetSAMLApplication(ctx, appID)
+		if err != nil {
+			return fmt.Errorf("error fetching %s Application %w", s.IdP.Name(), err)
+		}
+		if app.AcsUrl != conf.StytchResult.SsoParameters.AcsUrl {
+			return fmt.Errorf("%s application %s points to %s instead of the ACS URL of connection %s",
+				s.IdP.Name(), appID, app.AcsUrl, conf.StytchResult.ConnectionID)
+		}
+	}
+
+	conf.OktaResult.ApplicationID = appID
+	markStepDone(conf, StepCreateApplication)
+
+	conf.OktaResult.SsoParameters, err = s.IdP.FetchMetadata(ctx, appID)
+	if err != nil {
+		return fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+	markStepDone(conf, StepFetchMetadata)
+
+	return nil
+}
+
+// findStytchOrganisation returns the ID of the organization using the slug, or "" when there is none
+func (s *OktaSAMLConnectionBootstraper) findStytchOrganisation(ctx context.Context, slug string) (string, error) {
+	if slug == "" {
+		return "", errors.New("no OrganizationSlug to search for")
+	}
+
+	resp, err := s.StytchClient.Organizations.Search(ctx, &organizations.SearchParams{
+		Query: &organizations.SearchQuery{
+			Operator: organizations.SearchQueryOperatorAND,
+			Operands: []map[string]any{
+				{"filter_name": "organization_slugs", "filter_value": []string{slug}},
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// slugs are unique within a project
+	if len(resp.Organizations) == 0 {
+		return "", nil
+	}
+	return resp.Organizations[0].OrganizationID, nil
+}
+
+// findStytchConnection returns the SAML connection of the organization with this display name, or nil when there is none
+func (s *OktaSAMLConnectionBootstraper) findStytchConnection(ctx context.Context, organizationID, displayName string) (*sso.SAMLConnection, error) {
+	resp, err := s.StytchClient.SSO.GetConnections(ctx, &sso.GetConnectionsParams{
+		OrganizationID: organizationID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*sso.SAMLConnection
+	var ids []string
+	for i := range resp.SAMLConnections {
+		if resp.SAMLConnections[i].DisplayName == displayName {
+			found = append(found, &resp.SAMLConnections[i])
+			ids = append(ids, resp.SAMLConnections[i].ConnectionID)
+		}
+	}
+
+	id, err := uniqueMatch("SAML connections", displayName, ids)
+	if err != nil || id == "" {
+		return nil, err
+	}
+	return found[0], nil
+}
+
+// findStytchOIDCConnection returns the OIDC connection of the organization with this display name, or nil when there is none
+func (s *OktaSAMLConnectionBootstraper) findStytchOIDCConnection(ctx context.Context, organizationID, displayName string) (*sso.OIDCConnection, error) {
+	resp, err := s.StytchClient.SSO.GetConnections(ctx, &sso.GetConnectionsParams{
+		OrganizationID: organizationID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*sso.OIDCConnection
+	var ids []string
+	for i := range resp.OIDCConnections {
+		if resp.OIDCConnections[i].DisplayName == displayName {
+			found = append(found, &resp.OIDCConnections[i])
+			ids = append(ids, resp.OIDCConnections[i].ConnectionID)
+		}
+	}
+
+	id, err := uniqueMatch("OIDC connections", displayName, ids)
+	if err != nil || id == "" {
+		return nil, err
+	}
+	return found[0], nil
+}
+
+// uniqueMatch refuses to guess when several resources share the same name
+func uniqueMatch(kind, name string, ids []string) (string, error) {
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("found %d %s named %q (%s), remove the duplicates first", len(ids), kind, name, strings.Join(ids, ", "))
+	}
+}
+
+// isStytchDuplicateSlug reports whether Organizations.Create failed because the slug is taken
+func isStytchDuplicateSlug(err error) bool {
+	var stytchErr stytcherror.Error
+	return errors.As(err, &stytchErr) && stytchErr.ErrorType == "organization_slug_already_used"
+}
+
+func markStepDone(conf *config.SetupConfig, step string) {
+	conf.Steps[step] = config.StepStatus{Status: config.StepDone, At: time.Now().UTC().Format(time.RFC3339)}
+}
@@ -0,0 +1,85 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+func TestAdopt_FindsTheResourcesOfAPreviousRun(t *testing.T) {
+	s, first, _, fakeOkta := newFakeSetup(t)
+	stytchClient := s.StytchClient
+	oktaClient, err := fakeOkta.Client()
+	require.NoError(t, err)
+	newProvider := func() *memoryConfig {
+		return &memoryConfig{conf: &config.SetupConfig{SetupInput: newAcmeInput(), SetupResult: &config.SetupResult{}}}
+	}
+	ctx := context.Background()
+
+	// Nothing recorded yet, adopt finds every resource of the first run
+	adopted := newProvider()
+	s.ConfProvider = adopted
+	result, err := s.Adopt(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first.conf.StytchResult, result.StytchResult)
+	require.Equal(t, first.conf.OktaResult.ApplicationID, result.OktaResult.ApplicationID)
+	require.Equal(t, first.conf.OktaResult.SsoParameters, result.OktaResult.SsoParameters)
+	require.False(t, result.StepDone(StepUpdateConnection))
+
+	// The setup result is lost, setup adopts on the duplicate slug instead of failing
+	lost := newProvider()
+	s.ConfProvider = lost
+	require.NoError(t, s.Setup(ctx))
+	require.Equal(t, first.conf.StytchResult, lost.conf.StytchResult)
+	require.Equal(t, first.conf.OktaResult.ApplicationID, lost.conf.OktaResult.ApplicationID)
+	require.True(t, lost.conf.StepDone(StepUpdateConnection))
+
+	// No duplicated connection nor application
+	connections, err := stytchClient.SSO.GetConnections(ctx, &sso.GetConnectionsParams{OrganizationID: lost.conf.OrganizationID})
+	require.NoError(t, err)
+	require.Len(t, connections.SAMLConnections, 1)
+	apps, _, err := oktaClient.ApplicationAPI.ListApplications(ctx).Execute()
+	require.NoError(t, err)
+	require.Len(t, apps, 1)
+
+	drifts, err := s.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	// The connection is recorded without its sso_parameters, they are read back before checking the application
+	partial := newProvider()
+	partial.conf.StytchResult.OrganizationID = first.conf.StytchResult.OrganizationID
+	partial.conf.StytchResult.ConnectionID = first.conf.StytchResult.ConnectionID
+	s.ConfProvider = partial
+	result, err = s.Adopt(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first.conf.StytchResult.SsoParameters, result.StytchResult.SsoParameters)
+	require.Equal(t, first.conf.OktaResult.ApplicationID, result.OktaResult.ApplicationID)
+}
+
+func TestUniqueMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		want    string
+		wantErr string
+	}{
+		{name: "not found"},
+		{name: "found", ids: []string{"0oa1"}, want: "0oa1"},
+		{name: "ambiguous", ids: []string{"0oa1", "0oa2"}, wantErr: `found 2 applications named "Acme" (0oa1, 0oa2)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uniqueMatch("applications", "Acme", tt.ids)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
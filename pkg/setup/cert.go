@@ -0,0 +1,135 @@
+package setup
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minRSAKeySize is the smallest RSA key we accept for an IdP signing certificate
+const minRSAKeySize = 2048
+
+// CertificateInfo is what we report about an IdP X.509 certificate
+type CertificateInfo struct {
+	Subject           string
+	Issuer            string
+	FingerprintSHA256 string
+	KeyType           string
+	KeySize           int
+	NotBefore         time.Time
+	NotAfter          time.Time
+}
+
+// InspectCertificate decodes a base64 DER or PEM certificate as found in SAML metadata
+func InspectCertificate(cert string) (*CertificateInfo, error) {
+	der, err := decodeCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X.509 certificate: %w", err)
+	}
+
+	info := &CertificateInfo{
+		Subject:           parsed.Subject.String(),
+		Issuer:            parsed.Issuer.String(),
+		FingerprintSHA256: fingerprint(der),
+		NotBefore:         parsed.NotBefore,
+		NotAfter:          parsed.NotAfter,
+	}
+
+	switch key := parsed.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeyType, info.KeySize = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeyType, info.KeySize = "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		info.KeyType, info.KeySize = "Ed25519", 256
+	default:
+		info.KeyType = parsed.PublicKeyAlgorithm.String()
+	}
+
+	return info, nil
+}
+
+// ExpiresWithin reports whether the certificate is expired or expires before now + window
+func (c *CertificateInfo) ExpiresWithin(now time.Time, window time.Duration) bool {
+	return !c.NotAfter.After(now.Add(window))
+}
+
+// Validate refuses certificates that are obviously unusable to verify SAML assertions
+func (c *CertificateInfo) Validate(now time.Time) error {
+	switch {
+	case now.Before(c.NotBefore):
+		return fmt.Errorf("certificate %s is not valid before %s", c.Subject, c.NotBefore.Format(time.RFC3339))
+	case !now.Before(c.NotAfter):
+		return fmt.Errorf("certificate %s expired on %s", c.Subject, c.NotAfter.Format(time.RFC3339))
+	case c.KeyType == "RSA" && c.KeySize < minRSAKeySize:
+		return fmt.Errorf("certificate %s uses a %d bits RSA key, at least %d bits are required", c.Subject, c.KeySize, minRSAKeySize)
+	}
+	return nil
+}
+
+func (c *CertificateInfo) String() string {
+	return fmt.Sprintf("subject=%q issuer=%q sha256=%s key=%s/%d not_before=%s not_after=%s",
+		c.Subject, c.Issuer, c.FingerprintSHA256, c.KeyType, c.KeySize,
+		c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+}
+
+// validateCertificate is called before a certificate is sent to Stytch
+func validateCertificate(cert string, now time.Time) error {
+	info, err := InspectCertificate(cert)
+	if err != nil {
+		return err
+	}
+	return info.Validate(now)
+}
+
+func decodeCertificate(cert string) ([]byte, error) {
+	if block, _ := pem.Decode([]byte(strings.TrimSpace(cert))); block != nil {
+		return block.Bytes, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(normalizeCertificate(cert))
+	if err != nil {
+		return nil, fmt.Errorf("invalid X.509 certificate encoding: %w", err)
+	}
+	return der, nil
+}
+
+func normalizeCertificate(cert string) string {
+	cert = strings.TrimPrefix(strings.TrimSpace(cert), "-----BEGIN CERTIFICATE-----")
+	cert = strings.TrimSuffix(strings.TrimSpace(cert), "-----END CERTIFICATE-----")
+	return strings.Join(strings.Fields(cert), "")
+}
+
+// fingerprint is the colon separated SHA-256 of the DER certificate, as shown by the IdP dashboards
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		parts = append(parts, hexSum[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// certificateFingerprint is shorter to report than the certificate itself
+func certificateFingerprint(cert string) string {
+	der, err := decodeCertificate(cert)
+	if err != nil {
+		return "invalid certificate"
+	}
+	return fingerprint(der)
+}
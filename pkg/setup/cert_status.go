@@ -0,0 +1,61 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CertificateStatus is a certificate in use by the SAML connection and where it was found
+type CertificateStatus struct {
+	Source string
+	*CertificateInfo
+	// MetadataExpiresAt is the end of validity of the metadata the certificate was published in, zero when it does not say
+	MetadataExpiresAt time.Time
+}
+
+// CertificateStatus inspects the certificate published in the IdP metadata
+// and every verification certificate trusted by the Stytch connection
+func (s *OktaSAMLConnectionBootstraper) CertificateStatus(ctx context.Context) ([]CertificateStatus, error) {
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	if conf.StytchResult.OrganizationID == "" || conf.StytchResult.ConnectionID == "" {
+		return nil, fmt.Errorf("no connection to inspect, did you complete the setup?")
+	}
+
+	var statuses []CertificateStatus
+
+	idpSso, err := s.IdP.FetchMetadata(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+	info, err := InspectCertificate(idpSso.X509Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("%s metadata certificate: %w", s.IdP.Name(), err)
+	}
+	status := CertificateStatus{Source: s.IdP.Name() + " metadata", CertificateInfo: info}
+	if idpSso.MetadataExpiresAt != "" {
+		status.MetadataExpiresAt, err = time.Parse(time.RFC3339, idpSso.MetadataExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s metadata expiry: %w", s.IdP.Name(), err)
+		}
+	}
+	statuses = append(statuses, status)
+
+	connection, err := s.getStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchResult.ConnectionID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching SSO SAML Connection %w", err)
+	}
+	for _, cert := range connection.VerificationCertificates {
+		info, err := InspectCertificate(cert.Certificate)
+		if err != nil {
+			return nil, fmt.Errorf("stytch verification certificate %s: %w", cert.CertificateID, err)
+		}
+		statuses = append(statuses, CertificateStatus{Source: "Stytch verification certificate " + cert.CertificateID, CertificateInfo: info})
+	}
+
+	return statuses, nil
+}
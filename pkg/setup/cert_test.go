@@ -0,0 +1,106 @@
+package setup
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectCertificate(t *testing.T) {
+	metadata, err := os.ReadFile("testdata/idp_metadata.xml")
+	require.NoError(t, err)
+	sso, err := ssoParametersFromMetadata(string(metadata), "")
+	require.NoError(t, err)
+
+	info, err := InspectCertificate(sso.X509Certificate)
+	require.NoError(t, err)
+	require.Equal(t, "CN=dev-000000,OU=SSOProvider,O=Okta,L=San Francisco,ST=California,C=US", info.Subject)
+	require.Equal(t, "RSA", info.KeyType)
+	require.Equal(t, 2048, info.KeySize)
+	require.Len(t, info.FingerprintSHA256, 95)
+	require.NoError(t, info.Validate(time.Now()))
+
+	_, err = InspectCertificate("bm90IGEgY2VydGlmaWNhdGU=")
+	require.ErrorContains(t, err, "invalid X.509 certificate")
+}
+
+func TestCertificateInfo_Validate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		bits    int
+		from    time.Time
+		to      time.Time
+		wantErr string
+		warn    bool
+	}{
+		{
+			name: "valid",
+			bits: 2048,
+			from: now.AddDate(-1, 0, 0),
+			to:   now.AddDate(1, 0, 0),
+		},
+		{
+			name: "expires soon",
+			bits: 2048,
+			from: now.AddDate(-1, 0, 0),
+			to:   now.AddDate(0, 0, 10),
+			warn: true,
+		},
+		{
+			name:    "expired",
+			bits:    2048,
+			from:    now.AddDate(-1, 0, 0),
+			to:      now.AddDate(0, 0, -1),
+			wantErr: "certificate CN=test expired on 2024-05-31T00:00:00Z",
+			warn:    true,
+		},
+		{
+			name:    "weak key",
+			bits:    1024,
+			from:    now.AddDate(-1, 0, 0),
+			to:      now.AddDate(1, 0, 0),
+			wantErr: "certificate CN=test uses a 1024 bits RSA key, at least 2048 bits are required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := InspectCertificate(selfSignedCertificate(t, tt.bits, tt.from, tt.to))
+			require.NoError(t, err)
+
+			require.Equal(t, tt.warn, info.ExpiresWithin(now, 30*24*time.Hour))
+			if tt.wantErr == "" {
+				require.NoError(t, info.Validate(now))
+				return
+			}
+			require.EqualError(t, info.Validate(now), tt.wantErr)
+		})
+	}
+}
+
+func selfSignedCertificate(t *testing.T, bits int, notBefore, notAfter time.Time) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(der)
+}
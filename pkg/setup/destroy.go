@@ -0,0 +1,109 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+// Destroy tears down every resource recorded in the SetupResult, in the reverse order of Setup
+// After each successful step the matching IDs are cleared and the configuration is persisted
+// This means that if a step fails, running Destroy again only deletes what is left
+func (s *OktaSAMLConnectionBootstraper) Destroy(ctx context.Context) error {
+	ctx = journal.WithStep(ctx, "destroy")
+
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	// Step 1: Delete the IdP Application
+	if conf.OktaResult.ApplicationID != "" {
+		err := s.IdP.DeleteApplication(ctx, conf.OktaResult.ApplicationID)
+		s.Journal.Record(ctx, s.IdP.Name()+" DeleteApplication", map[string]string{"application_id": conf.OktaResult.ApplicationID}, nil, nil, err)
+		if err != nil {
+			return fmt.Errorf("error deleting %s Application %w", s.IdP.Name(), err)
+		}
+
+		conf.OktaResult.ApplicationID = ""
+		conf.OktaResult.SsoParameters = nil
+		resetSteps(conf, StepCreateApplication)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	// Step 2: Delete the IdP OIDC Application
+	if conf.OIDCResult.ApplicationID != "" {
+		err := s.IdP.DeleteApplication(ctx, conf.OIDCResult.ApplicationID)
+		s.Journal.Record(ctx, s.IdP.Name()+" DeleteApplication", map[string]string{"application_id": conf.OIDCResult.ApplicationID}, nil, nil, err)
+		if err != nil {
+			return fmt.Errorf("error deleting %s OIDC Application %w", s.IdP.Name(), err)
+		}
+
+		conf.OIDCResult.ApplicationID = ""
+		conf.OIDCResult.Issuer = ""
+		conf.OIDCResult.ClientID = ""
+		resetSteps(conf, StepCreateOIDCApplication)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	// Step 3: Delete the SCIM connection and its bearer token
+	if conf.SCIMResult.ConnectionID != "" {
+		if err := s.deleteStytchSCIMConnection(ctx, conf.StytchResult.OrganizationID, conf.SCIMResult.ConnectionID); err != nil {
+			return fmt.Errorf("error deleting SCIM Connection %w", err)
+		}
+
+		conf.SCIMResult = config.SCIMResult{}
+		resetSteps(conf, StepCreateSCIM)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	// Step 4: Delete the OIDC connection and its client secret
+	if conf.OIDCResult.ConnectionID != "" {
+		if err := s.deleteStytchOIDCConnection(ctx, conf.StytchResult.OrganizationID, conf.OIDCResult.ConnectionID); err != nil {
+			return fmt.Errorf("error deleting SSO OIDC Connection %w", err)
+		}
+
+		conf.OIDCResult = config.OIDCResult{}
+		resetSteps(conf, StepCreateOIDCConnection)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	// Step 5: Delete the SAML connection
+	if conf.StytchResult.ConnectionID != "" {
+		if err := s.deleteStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchResult.ConnectionID); err != nil {
+			return fmt.Errorf("error deleting SSO SAML Connection %w", err)
+		}
+
+		conf.StytchResult.ConnectionID = ""
+		conf.StytchResult.SsoParameters = nil
+		resetSteps(conf, StepCreateConnection)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	// Step 6: Delete the Organisation
+	if conf.StytchResult.OrganizationID != "" {
+		if err := s.deleteStytchOrganisation(ctx, conf.StytchResult.OrganizationID); err != nil {
+			return fmt.Errorf("error deleting Organization %w", err)
+		}
+
+		conf.StytchResult.OrganizationID = ""
+		resetSteps(conf, StepCreateOrganization)
+		if err := s.ConfProvider.Save(); err != nil {
+			return fmt.Errorf("error saving Configuration %w", err)
+		}
+	}
+
+	return nil
+}
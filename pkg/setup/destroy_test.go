@@ -0,0 +1,81 @@
+package setup
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+// snapshotConfig keeps a copy of the result at each save
+type snapshotConfig struct {
+	*memoryConfig
+	saved []config.SetupResult
+}
+
+func (c *snapshotConfig) Save() error {
+	c.saved = append(c.saved, *c.conf.SetupResult)
+	return c.memoryConfig.Save()
+}
+
+func TestDestroy_DeletesInReverseOrderAndSavesAfterEachStep(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeBootstraper(t)
+	provider.conf.SCIM = config.SCIMSetupInput{Enabled: true, ConnectionDisplayName: "Okta SCIM"}
+	journalPath := filepath.Join(t.TempDir(), "setup.journal.jsonl")
+	s.Journal = journal.New(journalPath)
+	ctx := context.Background()
+	require.NoError(t, s.Setup(ctx))
+	created := *provider.conf.SetupResult
+
+	snapshots := &snapshotConfig{memoryConfig: provider}
+	s.ConfProvider = snapshots
+	recorded, err := journal.Read(journalPath, journal.Filter{})
+	require.NoError(t, err)
+	require.NoError(t, s.Destroy(ctx))
+
+	entries, err := journal.Read(journalPath, journal.Filter{})
+	require.NoError(t, err)
+	var apis []string
+	for _, entry := range entries[len(recorded):] {
+		apis = append(apis, entry.API)
+	}
+	require.Equal(t, []string{"Okta DeleteApplication", "Stytch SCIM.DeleteConnection", "Stytch SSO.DeleteConnection", "Stytch Organizations.Delete"}, apis)
+
+	// Each deletion is saved before the next one, a failed destroy resumes with what is left
+	require.Len(t, snapshots.saved, 4)
+	require.Empty(t, snapshots.saved[0].OktaResult.ApplicationID)
+	require.Equal(t, created.SCIMResult.ConnectionID, snapshots.saved[0].SCIMResult.ConnectionID)
+	require.Empty(t, snapshots.saved[1].SCIMResult.ConnectionID)
+	require.Equal(t, created.StytchResult.ConnectionID, snapshots.saved[1].StytchResult.ConnectionID)
+	require.Empty(t, snapshots.saved[2].StytchResult.ConnectionID)
+	require.Equal(t, created.StytchResult.OrganizationID, snapshots.saved[2].StytchResult.OrganizationID)
+	require.Empty(t, snapshots.saved[3].StytchResult.OrganizationID)
+
+	_, ok := fakeOkta.Application(created.OktaResult.ApplicationID)
+	require.False(t, ok)
+	_, ok = fakeStytch.Organization(created.StytchResult.OrganizationID)
+	require.False(t, ok)
+
+	// Nothing is left to delete
+	require.NoError(t, s.Destroy(ctx))
+	require.Len(t, snapshots.saved, 4)
+}
+
+func TestDestroy_TreatsMissingResourcesAsDeleted(t *testing.T) {
+	s, provider, _, _ := newFakeSetup(t)
+	ctx := context.Background()
+	created := *provider.conf.SetupResult
+
+	// Deleted out of band, e.g. by hand or by a destroy that failed before saving
+	require.NoError(t, s.IdP.DeleteApplication(ctx, created.OktaResult.ApplicationID))
+	require.NoError(t, s.deleteStytchConnection(ctx, created.StytchResult.OrganizationID, created.StytchResult.ConnectionID))
+	require.NoError(t, s.deleteStytchOrganisation(ctx, created.StytchResult.OrganizationID))
+
+	require.NoError(t, s.Destroy(ctx))
+	require.Empty(t, provider.conf.OktaResult.ApplicationID)
+	require.Empty(t, provider.conf.StytchResult.ConnectionID)
+	require.Empty(t, provider.conf.StytchResult.OrganizationID)
+}
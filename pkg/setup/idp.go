@@ -0,0 +1,68 @@
+package setup
+
+import (
+	"context"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// IdentityProvider is the IdP side of the SAML connection driven by the bootstraper
+// Whatever the provider, the results are recorded in the same OktaResult section of SetupResult
+type IdentityProvider interface {
+	// Name is used in logs and error messages
+	Name() string
+	// CreateSAMLApplication creates an application pointing to Stytch ACS URL and Audience and returns its ID
+	CreateSAMLApplication(ctx context.Context, input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, error)
+	// SAMLApplicationPayload describes the request CreateSAMLApplication would send, used by Plan
+	SAMLApplicationPayload(input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, any)
+	// FetchMetadata reads the IdP metadata needed to configure the Stytch connection
+	FetchMetadata(ctx context.Context, appID string) (*config.OktaSsoParameters, error)
+	// AttributeMapping maps the attributes sent by the IdP to Stytch member fields
+	AttributeMapping(input *config.SetupInput) map[string]any
+	// DeleteApplication removes the application, a missing application is not an error
+	DeleteApplication(ctx context.Context, appID string) error
+}
+
+// SAMLApplicationReader is implemented by providers able to read back the application they created
+// It is used by Verify to detect manual changes made in the IdP dashboard
+type SAMLApplicationReader interface {
+	GetSAMLApplication(ctx context.Context, appID string) (*SAMLApplication, error)
+}
+
+// SAMLApplication is the IdP side of the connection as currently configured
+type SAMLApplication struct {
+	AcsUrl   string
+	Audience string
+	// Attributes are the attribute statements sent by the IdP, nil when the provider does not expose them
+	Attributes []config.SAMLAttribute
+}
+
+// SigningKeyRotator is implemented by providers able to rotate the signing certificate of the application
+type SigningKeyRotator interface {
+	// GenerateSigningKey creates a new signing key without using it yet, it returns its ID and base64 certificate
+	GenerateSigningKey(ctx context.Context, appID string, validityYears int) (kid string, cert string, err error)
+	// ActivateSigningKey makes the application sign assertions with the given key
+	ActivateSigningKey(ctx context.Context, appID string, kid string) error
+}
+
+// SCIMProvisioner is implemented by providers able to push members to a Stytch SCIM connection
+type SCIMProvisioner interface {
+	// EnableProvisioning points the application to the SCIM base URL and turns on create, update and deactivate
+	EnableProvisioning(ctx context.Context, appID, baseURL, bearerToken string) error
+}
+
+// OIDCApplicationCreator is implemented by providers able to create an OIDC web application, it is required by the oidc protocol
+type OIDCApplicationCreator interface {
+	// CreateOIDCApplication creates a web application redirecting to the Stytch callback and returns its credentials
+	CreateOIDCApplication(ctx context.Context, label, redirectURL string) (*OIDCApplication, error)
+	// OIDCApplicationPayload describes the request CreateOIDCApplication would send, used by Plan
+	OIDCApplicationPayload(label, redirectURL string) (string, any)
+}
+
+// OIDCApplication is what Stytch needs to authenticate members with the IdP application
+type OIDCApplication struct {
+	ID           string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
@@ -2,18 +2,53 @@ package setup
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// SAML bindings of the SingleSignOnService, by order of preference
+const (
+	BindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	BindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+)
+
+// KeyDescriptor use attribute, a key without use can be used for both
+const (
+	KeyUseSigning    = "signing"
+	KeyUseEncryption = "encryption"
 )
 
 // Define XML structures corresponding to the XML document structure
+type EntitiesDescriptor struct {
+	XMLName             xml.Name             `xml:"EntitiesDescriptor"`
+	Name                string               `xml:"Name,attr"`
+	ValidUntil          string               `xml:"validUntil,attr"`
+	CacheDuration       string               `xml:"cacheDuration,attr"`
+	EntitiesDescriptors []EntitiesDescriptor `xml:"EntitiesDescriptor"`
+	EntityDescriptors   []EntityDescriptor   `xml:"EntityDescriptor"`
+}
+
 type EntityDescriptor struct {
-	XMLName          xml.Name         `xml:"EntityDescriptor"`
-	EntityID         string           `xml:"entityID,attr"`
-	IDPSSODescriptor IDPSSODescriptor `xml:"IDPSSODescriptor"`
+	XMLName          xml.Name          `xml:"EntityDescriptor"`
+	Xmlns            string            `xml:"xmlns,attr,omitempty"`
+	EntityID         string            `xml:"entityID,attr"`
+	ValidUntil       string            `xml:"validUntil,attr,omitempty"`
+	CacheDuration    string            `xml:"cacheDuration,attr,omitempty"`
+	IDPSSODescriptor *IDPSSODescriptor `xml:"IDPSSODescriptor"`
+	SPSSODescriptor  *SPSSODescriptor  `xml:"SPSSODescriptor"`
 }
 
 type IDPSSODescriptor struct {
 	WantAuthnRequestsSigned    string                `xml:"WantAuthnRequestsSigned,attr"`
 	ProtocolSupportEnumeration string                `xml:"protocolSupportEnumeration,attr"`
+	ValidUntil                 string                `xml:"validUntil,attr"`
+	CacheDuration              string                `xml:"cacheDuration,attr"`
 	KeyDescriptors             []KeyDescriptor       `xml:"KeyDescriptor"`
 	NameIDFormat               string                `xml:"NameIDFormat"`
 	SingleSignOnServices       []SingleSignOnService `xml:"SingleSignOnService"`
@@ -37,6 +72,84 @@ type SingleSignOnService struct {
 	Location string `xml:"Location,attr"`
 }
 
+type SPSSODescriptor struct {
+	AuthnRequestsSigned        string                      `xml:"AuthnRequestsSigned,attr,omitempty"`
+	WantAssertionsSigned       string                      `xml:"WantAssertionsSigned,attr,omitempty"`
+	ProtocolSupportEnumeration string                      `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormats              []string                    `xml:"NameIDFormat"`
+	AssertionConsumerServices  []AssertionConsumerService  `xml:"AssertionConsumerService"`
+	AttributeConsumingServices []AttributeConsumingService `xml:"AttributeConsumingService"`
+}
+
+type AssertionConsumerService struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr,omitempty"`
+}
+
+type AttributeConsumingService struct {
+	Index               int                  `xml:"index,attr"`
+	ServiceNames        []LocalizedName      `xml:"ServiceName"`
+	RequestedAttributes []RequestedAttribute `xml:"RequestedAttribute"`
+}
+
+type LocalizedName struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+type RequestedAttribute struct {
+	Name         string `xml:"Name,attr"`
+	NameFormat   string `xml:"NameFormat,attr,omitempty"`
+	FriendlyName string `xml:"FriendlyName,attr,omitempty"`
+	IsRequired   bool   `xml:"isRequired,attr,omitempty"`
+}
+
+// IdPMetadata is the result of parsing a metadata document for a single IdP entity
+type IdPMetadata struct {
+	*EntityDescriptor
+	// ExpiresAt is the earliest validUntil found along the document, zero if none
+	ExpiresAt time.Time
+	// CacheExpiresAt is the earliest now + cacheDuration found along the document, zero if none
+	// It only tells how long a copy may be cached, the document fetched again is valid for as long
+	CacheExpiresAt time.Time
+
+	// parents are the EntitiesDescriptor enclosing the entity, they bound its validity
+	parents []*EntitiesDescriptor
+}
+
+// SingleSignOnURL returns the Location of the first SingleSignOnService matching the bindings, in order of preference
+func (d *IDPSSODescriptor) SingleSignOnURL(bindings ...string) (string, error) {
+	for _, binding := range bindings {
+		for _, service := range d.SingleSignOnServices {
+			if service.Binding == binding && service.Location != "" {
+				return service.Location, nil
+			}
+		}
+	}
+
+	found := make([]string, 0, len(d.SingleSignOnServices))
+	for _, service := range d.SingleSignOnServices {
+		found = append(found, service.Binding)
+	}
+	return "", fmt.Errorf("no SingleSignOnService with binding %s, found [%s]",
+		strings.Join(bindings, " or "), strings.Join(found, ", "))
+}
+
+// Certificates returns the base64 certificates usable for the given use, keys without use apply to both
+func (d *IDPSSODescriptor) Certificates(use string) []string {
+	var certs []string
+	for _, key := range d.KeyDescriptors {
+		cert := strings.Join(strings.Fields(key.KeyInfo.X509Data.X509Certificate), "")
+		if cert == "" || (key.Use != "" && key.Use != use) {
+			continue
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
 func parseXML(xmlData string) (*EntityDescriptor, error) {
 	var descriptor EntityDescriptor
 
@@ -44,3 +157,204 @@ func parseXML(xmlData string) (*EntityDescriptor, error) {
 
 	return &descriptor, err
 }
+
+// parseMetadata parses an EntityDescriptor or an EntitiesDescriptor document
+// When the document holds several entities, entityID selects the one to use
+// An expired document (validUntil in the past) is rejected
+func parseMetadata(xmlData string, entityID string, now time.Time) (*IdPMetadata, error) {
+	root, err := rootElement(xmlData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML metadata: %w", err)
+	}
+
+	var candidates []*IdPMetadata
+	switch root {
+	case "EntityDescriptor":
+		descriptor, err := parseXML(xmlData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAML metadata: %w", err)
+		}
+		candidates = append(candidates, &IdPMetadata{EntityDescriptor: descriptor})
+	case "EntitiesDescriptor":
+		var entities EntitiesDescriptor
+		if err := xml.Unmarshal([]byte(xmlData), &entities); err != nil {
+			return nil, fmt.Errorf("invalid SAML metadata: %w", err)
+		}
+		candidates = flattenEntities(&entities, nil)
+
+		// Federations also publish SP entities, only keep the IdPs when no entity was requested
+		if entityID == "" {
+			var idps []*IdPMetadata
+			for _, candidate := range candidates {
+				if candidate.IDPSSODescriptor != nil {
+					idps = append(idps, candidate)
+				}
+			}
+			candidates = idps
+		}
+	default:
+		return nil, fmt.Errorf("invalid SAML metadata: unexpected root element %s, expected EntityDescriptor or EntitiesDescriptor", root)
+	}
+
+	metadata, err := selectEntity(candidates, entityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.IDPSSODescriptor == nil {
+		return nil, fmt.Errorf("entity %s has no IDPSSODescriptor, is it an IdP metadata document?", metadata.EntityID)
+	}
+
+	// The validity of the entity is bound by the one of its parents
+	for _, parent := range metadata.parents {
+		if err := metadata.applyValidity(now, parent.ValidUntil, parent.CacheDuration); err != nil {
+			return nil, err
+		}
+	}
+	if err := metadata.applyValidity(now, metadata.ValidUntil, metadata.CacheDuration); err != nil {
+		return nil, err
+	}
+	if err := metadata.applyValidity(now, metadata.IDPSSODescriptor.ValidUntil, metadata.IDPSSODescriptor.CacheDuration); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// flattenEntities lists the entities of nested EntitiesDescriptor, carrying the parents validity
+func flattenEntities(entities *EntitiesDescriptor, parents []*EntitiesDescriptor) []*IdPMetadata {
+	parents = append(parents, entities)
+
+	var result []*IdPMetadata
+	for i := range entities.EntityDescriptors {
+		result = append(result, &IdPMetadata{
+			EntityDescriptor: &entities.EntityDescriptors[i],
+			parents:          append([]*EntitiesDescriptor(nil), parents...),
+		})
+	}
+	for i := range entities.EntitiesDescriptors {
+		result = append(result, flattenEntities(&entities.EntitiesDescriptors[i], parents)...)
+	}
+	return result
+}
+
+func selectEntity(candidates []*IdPMetadata, entityID string) (*IdPMetadata, error) {
+	if len(candidates) == 0 {
+		return nil, errors.New("SAML metadata does not contain any EntityDescriptor")
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.EntityID == entityID || (entityID == "" && len(candidates) == 1) {
+			return candidate, nil
+		}
+		ids = append(ids, candidate.EntityID)
+	}
+
+	if entityID == "" {
+		return nil, fmt.Errorf("SAML metadata contains %d entities, select one by entityID: [%s]", len(candidates), strings.Join(ids, ", "))
+	}
+	return nil, fmt.Errorf("entityID %s not found in SAML metadata: [%s]", entityID, strings.Join(ids, ", "))
+}
+
+func (m *IdPMetadata) applyValidity(now time.Time, validUntil, cacheDuration string) error {
+	if validUntil != "" {
+		until, err := time.Parse(time.RFC3339, validUntil)
+		if err != nil {
+			return fmt.Errorf("invalid validUntil %q: %w", validUntil, err)
+		}
+		if !until.After(now) {
+			return fmt.Errorf("SAML metadata for %s expired on %s", m.EntityID, until.Format(time.RFC3339))
+		}
+		m.ExpiresAt = earliest(m.ExpiresAt, until)
+	}
+
+	if cacheDuration != "" {
+		duration, err := parseXSDuration(cacheDuration)
+		if err != nil {
+			return fmt.Errorf("invalid cacheDuration %q: %w", cacheDuration, err)
+		}
+		m.CacheExpiresAt = earliest(m.CacheExpiresAt, now.Add(duration))
+	}
+
+	return nil
+}
+
+// earliest returns the earliest of current and t, a zero current is unset
+func earliest(current, t time.Time) time.Time {
+	if current.IsZero() || t.Before(current) {
+		return t
+	}
+	return current
+}
+
+// rootElement returns the local name of the first element of the document
+func rootElement(xmlData string) (string, error) {
+	decoder := xml.NewDecoder(strings.NewReader(xmlData))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+var xsDuration = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseXSDuration parses an xs:duration such as PT6H or P1DT12H, years and months are approximated
+func parseXSDuration(s string) (time.Duration, error) {
+	match := xsDuration.FindStringSubmatch(s)
+	if match == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("not an xs:duration")
+	}
+
+	units := []time.Duration{0, 365 * 24 * time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var total time.Duration
+	for i := 2; i < len(match); i++ {
+		if match[i] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[i], 64)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(value * float64(units[i-1]))
+	}
+
+	if match[1] == "-" {
+		total = -total
+	}
+	return total, nil
+}
+
+// ssoParametersFromMetadata extracts from an IdP metadata document what Stytch needs for the connection
+// entityID is only required when the document describes several entities
+func ssoParametersFromMetadata(xmlData string, entityID string) (*config.OktaSsoParameters, error) {
+	SAML, err := parseMetadata(xmlData, entityID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	ssoURL, err := SAML.IDPSSODescriptor.SingleSignOnURL(BindingHTTPPost, BindingHTTPRedirect)
+	if err != nil {
+		return nil, fmt.Errorf("entity %s: %w", SAML.EntityID, err)
+	}
+
+	certs := SAML.IDPSSODescriptor.Certificates(KeyUseSigning)
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("entity %s: no signing certificate in KeyDescriptor", SAML.EntityID)
+	}
+
+	params := &config.OktaSsoParameters{
+		IdpEntityID:     SAML.EntityID,
+		IdpSSOURL:       ssoURL,
+		X509Certificate: certs[0],
+	}
+	if !SAML.ExpiresAt.IsZero() {
+		params.MetadataExpiresAt = SAML.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return params, nil
+}
@@ -0,0 +1,116 @@
+package setup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+const (
+	mdEntity = `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="%s"%s>%s</md:EntityDescriptor>`
+	mdIdP    = `<md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">%s</md:IDPSSODescriptor>`
+	mdKey    = `<md:KeyDescriptor%s><ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>`
+	mdSSO    = `<md:SingleSignOnService Binding="%s" Location="%s"/>`
+)
+
+func TestSsoParametersFromMetadata(t *testing.T) {
+	now := time.Now()
+	future := now.Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	past := now.Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+
+	idp := fmt.Sprintf(mdIdP,
+		fmt.Sprintf(mdKey, ` use="encryption"`, "ENC")+
+			fmt.Sprintf(mdKey, ` use="signing"`, "SIGN\n  ED")+
+			fmt.Sprintf(mdSSO, "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect", "https://idp/redirect")+
+			fmt.Sprintf(mdSSO, BindingHTTPPost, "https://idp/post"),
+	)
+
+	tests := []struct {
+		name     string
+		xml      string
+		entityID string
+		want     *config.OktaSsoParameters
+		wantErr  string
+	}{
+		{
+			name: "prefers HTTP-POST and signing key",
+			xml:  fmt.Sprintf(mdEntity, "idp", "", idp),
+			want: &config.OktaSsoParameters{IdpEntityID: "idp", IdpSSOURL: "https://idp/post", X509Certificate: "SIGNED"},
+		},
+		{
+			name: "falls back to HTTP-Redirect and key without use",
+			xml: fmt.Sprintf(mdEntity, "idp", "", fmt.Sprintf(mdIdP,
+				fmt.Sprintf(mdKey, "", "BOTH")+fmt.Sprintf(mdSSO, BindingHTTPRedirect, "https://idp/redirect"))),
+			want: &config.OktaSsoParameters{IdpEntityID: "idp", IdpSSOURL: "https://idp/redirect", X509Certificate: "BOTH"},
+		},
+		{
+			name: "selects entity in EntitiesDescriptor",
+			xml: `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" validUntil="` + future + `">` +
+				fmt.Sprintf(mdEntity, "first", "", idp) + fmt.Sprintf(mdEntity, "second", "", idp) + `</md:EntitiesDescriptor>`,
+			entityID: "second",
+			want:     &config.OktaSsoParameters{IdpEntityID: "second", IdpSSOURL: "https://idp/post", X509Certificate: "SIGNED", MetadataExpiresAt: future},
+		},
+		{
+			name: "ambiguous EntitiesDescriptor",
+			xml: `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata">` +
+				fmt.Sprintf(mdEntity, "first", "", idp) + fmt.Sprintf(mdEntity, "second", "", idp) + `</md:EntitiesDescriptor>`,
+			wantErr: "SAML metadata contains 2 entities, select one by entityID: [first, second]",
+		},
+		{
+			name: "expired parent",
+			xml: `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" validUntil="` + past + `">` +
+				fmt.Sprintf(mdEntity, "idp", "", idp) + `</md:EntitiesDescriptor>`,
+			wantErr: "SAML metadata for idp expired on " + past,
+		},
+		{
+			name:    "no usable binding",
+			xml:     fmt.Sprintf(mdEntity, "idp", "", fmt.Sprintf(mdIdP, fmt.Sprintf(mdKey, "", "C")+fmt.Sprintf(mdSSO, "urn:oasis:names:tc:SAML:2.0:bindings:SOAP", "https://idp/soap"))),
+			wantErr: "entity idp: no SingleSignOnService with binding " + BindingHTTPPost + " or " + BindingHTTPRedirect + ", found [urn:oasis:names:tc:SAML:2.0:bindings:SOAP]",
+		},
+		{
+			name:    "no signing certificate",
+			xml:     fmt.Sprintf(mdEntity, "idp", "", fmt.Sprintf(mdIdP, fmt.Sprintf(mdKey, ` use="encryption"`, "ENC")+fmt.Sprintf(mdSSO, BindingHTTPPost, "https://idp/post"))),
+			wantErr: "entity idp: no signing certificate in KeyDescriptor",
+		},
+		{
+			name:    "not an IdP",
+			xml:     fmt.Sprintf(mdEntity, "sp", "", ""),
+			wantErr: "entity sp has no IDPSSODescriptor, is it an IdP metadata document?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ssoParametersFromMetadata(tt.xml, tt.entityID)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseMetadata_CacheDuration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	xml := fmt.Sprintf(mdEntity, "idp", ` validUntil="2024-01-10T00:00:00Z" cacheDuration="P1DT12H"`,
+		fmt.Sprintf(mdIdP, fmt.Sprintf(mdSSO, BindingHTTPPost, "https://idp/post")))
+
+	got, err := parseMetadata(xml, "", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), got.ExpiresAt)
+	require.Equal(t, now.Add(36*time.Hour), got.CacheExpiresAt)
+}
+
+func TestSsoParametersFromMetadata_ShortCacheDuration(t *testing.T) {
+	// A short cacheDuration only asks to fetch the metadata again, it does not end its validity
+	xml := fmt.Sprintf(mdEntity, "idp", ` cacheDuration="PT6H"`,
+		fmt.Sprintf(mdIdP, fmt.Sprintf(mdKey, "", "CERT")+fmt.Sprintf(mdSSO, BindingHTTPPost, "https://idp/post")))
+
+	got, err := ssoParametersFromMetadata(xml, "")
+	require.NoError(t, err)
+	require.Empty(t, got.MetadataExpiresAt)
+}
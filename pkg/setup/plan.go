@@ -0,0 +1,215 @@
+package setup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+// knownAfterApply is the placeholder used in a plan for values only known once a previous step ran
+const knownAfterApply = "(known after apply)"
+
+// Plan loads the current configuration and prints the steps Setup would execute
+// along with the request payloads it would send. No mutating API is called.
+func (s *OktaSAMLConnectionBootstraper) Plan(ctx context.Context, w io.Writer) error {
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	steps := s.steps()
+	selected, err := s.selectSteps(steps, conf)
+	if err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	for _, step := range steps {
+		done[step.name] = step.done(conf)
+	}
+
+	organizationID := conf.StytchResult.OrganizationID
+	connectionID := conf.StytchResult.ConnectionID
+	stytchSso := conf.StytchResult.SsoParameters
+	applicationID := conf.OktaResult.ApplicationID
+	oktaSso := conf.OktaResult.SsoParameters
+
+	// Step 0. Create a New Organisation
+	if selected[StepCreateOrganization] {
+		if err := printStep(w, StepCreateOrganization, "Stytch Organizations.Create", stytchOrganisationParams(&conf.StytchSetupInput)); err != nil {
+			return err
+		}
+		organizationID = knownAfterApply
+	} else {
+		skipStep(w, StepCreateOrganization, organizationID, done[StepCreateOrganization])
+	}
+
+	// Step 1. Create a new SAML connection
+	if selected[StepCreateConnection] {
+		if err := printStep(w, StepCreateConnection, "Stytch SSO.SAML.CreateConnection", stytchConnectionParams(&conf.StytchSetupInput, organizationID)); err != nil {
+			return err
+		}
+		connectionID = knownAfterApply
+		stytchSso = &config.StychSsoParameters{AcsUrl: knownAfterApply, Audience: knownAfterApply}
+	} else {
+		skipStep(w, StepCreateConnection, connectionID, done[StepCreateConnection])
+	}
+
+	// Step 2: Create and configure a new IdP Application
+	if selected[StepCreateApplication] {
+		if stytchSso == nil {
+			stytchSso = &config.StychSsoParameters{}
+		}
+		api, payload := s.IdP.SAMLApplicationPayload(conf.SetupInput, stytchSso)
+		if err := printStep(w, StepCreateApplication, api, payload); err != nil {
+			return err
+		}
+		applicationID = knownAfterApply
+	} else {
+		skipStep(w, StepCreateApplication, applicationID, done[StepCreateApplication])
+	}
+
+	// Step 3: Fetch IdP SAML Metdata
+	if selected[StepFetchMetadata] {
+		fmt.Fprintf(w, "# %s: will run (read only)\n%s application %s\n\n", StepFetchMetadata, s.IdP.Name(), applicationID)
+		oktaSso = &config.OktaSsoParameters{
+			IdpEntityID:     knownAfterApply,
+			IdpSSOURL:       knownAfterApply,
+			X509Certificate: knownAfterApply,
+		}
+	} else {
+		entityID := ""
+		if oktaSso != nil {
+			entityID = oktaSso.IdpEntityID
+		}
+		skipStep(w, StepFetchMetadata, entityID, done[StepFetchMetadata])
+	}
+
+	// Step 4: Update Stych SSO Connactions
+	if selected[StepUpdateConnection] {
+		if err := printStep(w, StepUpdateConnection, "Stytch SSO.SAML.UpdateConnection", stytchUpdateConnectionParams(organizationID, connectionID, oktaSso, s.IdP.AttributeMapping(conf.SetupInput))); err != nil {
+			return err
+		}
+	} else {
+		skipStep(w, StepUpdateConnection, connectionID, done[StepUpdateConnection])
+	}
+
+	// Step 5: Create a new OIDC connection
+	oidcResult := conf.OIDCResult
+	if selected[StepCreateOIDCConnection] {
+		if err := printStep(w, StepCreateOIDCConnection, "Stytch SSO.OIDC.CreateConnection", stytchOIDCConnectionParams(organizationID, conf.OIDC.ConnectionDisplayName)); err != nil {
+			return err
+		}
+		oidcResult = config.OIDCResult{ConnectionID: knownAfterApply, RedirectURL: knownAfterApply}
+	} else {
+		skipStep(w, StepCreateOIDCConnection, oidcResult.ConnectionID, done[StepCreateOIDCConnection])
+	}
+
+	// Step 6: Create an IdP OIDC web application
+	if selected[StepCreateOIDCApplication] {
+		creator, ok := s.IdP.(OIDCApplicationCreator)
+		if !ok {
+			return fmt.Errorf("%s OIDC applications cannot be created through its API", s.IdP.Name())
+		}
+		api, payload := creator.OIDCApplicationPayload(conf.OIDC.AppLabel, oidcResult.RedirectURL)
+		if err := printStep(w, StepCreateOIDCApplication, api, payload); err != nil {
+			return err
+		}
+		oidcResult.ApplicationID = knownAfterApply
+		oidcResult.Issuer = knownAfterApply
+		oidcResult.ClientID = knownAfterApply
+	} else {
+		skipStep(w, StepCreateOIDCApplication, oidcResult.ApplicationID, done[StepCreateOIDCApplication])
+	}
+
+	// Step 7: Update the Stytch OIDC connection, the client secret is read from the secret store
+	if selected[StepUpdateOIDCConnection] {
+		if err := printStep(w, StepUpdateOIDCConnection, "Stytch SSO.OIDC.UpdateConnection",
+			stytchUpdateOIDCConnectionParams(organizationID, &oidcResult, oidcClientSecretKey(oidcResult.ConnectionID))); err != nil {
+			return err
+		}
+	} else {
+		skipStep(w, StepUpdateOIDCConnection, oidcResult.ConnectionID, done[StepUpdateOIDCConnection])
+	}
+
+	// The following steps use the connection and application of the protocol in use
+	if s.Protocol == ProtocolOIDC {
+		connectionID = oidcResult.ConnectionID
+		applicationID = oidcResult.ApplicationID
+	}
+
+	// Step 8: Create the SCIM connection
+	scim := conf.SCIMResult
+	if selected[StepCreateSCIM] {
+		if err := printStep(w, StepCreateSCIM, "Stytch SCIM.CreateConnection", map[string]string{"display_name": conf.SCIM.ConnectionDisplayName}); err != nil {
+			return err
+		}
+		scim = config.SCIMResult{ConnectionID: knownAfterApply, BaseURL: knownAfterApply}
+	} else {
+		skipStep(w, StepCreateSCIM, scim.ConnectionID, done[StepCreateSCIM])
+	}
+
+	// Step 9: Enable provisioning, the bearer token is read from the secret store
+	if selected[StepEnableProvisioning] {
+		if err := printStep(w, StepEnableProvisioning, s.IdP.Name()+" EnableProvisioning", map[string]string{
+			"application_id": applicationID,
+			"base_url":       scim.BaseURL,
+			"bearer_token":   scimTokenKey(scim.ConnectionID),
+		}); err != nil {
+			return err
+		}
+	} else {
+		skipStep(w, StepEnableProvisioning, applicationID, done[StepEnableProvisioning])
+	}
+
+	// Step 10: Reconcile the Organization settings, only the settings differing in Stytch are sent
+	if !selected[StepUpdateOrganization] {
+		skipStep(w, StepUpdateOrganization, organizationID, done[StepUpdateOrganization])
+		return nil
+	}
+	// Compared with an empty organization, the update holds every configured setting
+	return printStep(w, StepUpdateOrganization, "Stytch Organizations.Update (drifted settings only)",
+		stytchOrganisationSettingsDrift(&organizations.Organization{OrganizationID: organizationID}, connectionID, &conf.StytchSetupInput.Settings))
+}
+
+func skipStep(w io.Writer, step, id string, done bool) {
+	if !done {
+		fmt.Fprintf(w, "# %s: not selected, skipped\n\n", step)
+		return
+	}
+	fmt.Fprintf(w, "# %s: already done (%s), skipped\n\n", step, id)
+}
+
+func printStep(w io.Writer, step, api string, payload any) error {
+	body, err := redactedJSON(payload)
+	if err != nil {
+		return fmt.Errorf("error rendering %s payload %w", step, err)
+	}
+
+	fmt.Fprintf(w, "# %s: will run\n%s\n%s\n\n", step, api, body)
+	return nil
+}
+
+// redactedJSON renders a payload as indented JSON with sensitive fields masked
+func redactedJSON(payload any) (string, error) {
+	doc, err := journal.Redact(payload)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep placeholders such as <redacted> readable instead of \u003c escapes
+	var out strings.Builder
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(out.String(), "\n"), nil
+}
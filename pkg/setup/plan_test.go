@@ -0,0 +1,103 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// mutatingRequests returns the requests recorded by a fake that are not reads
+func mutatingRequests(requests []string) []string {
+	var mutating []string
+	for _, request := range requests {
+		if !strings.HasPrefix(request, http.MethodGet+" ") {
+			mutating = append(mutating, request)
+		}
+	}
+	return mutating
+}
+
+func TestPlan_FromScratch(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeBootstraper(t)
+
+	var out bytes.Buffer
+	require.NoError(t, s.Plan(context.Background(), &out))
+	plan := out.String()
+
+	// Every SAML step runs, the IDs created by a step are only known once it ran
+	require.Contains(t, plan, "# create-organization: will run\nStytch Organizations.Create\n{\n  \"organization_name\": \"Acme Corp\",\n  \"organization_slug\": \"acme\"\n}")
+	require.Contains(t, plan, "# create-connection: will run\nStytch SSO.SAML.CreateConnection\n{\n  \"display_name\": \"Okta\",\n  \"organization_id\": \"(known after apply)\"\n}")
+	require.Contains(t, plan, "# create-application: will run\nOkta ApplicationAPI.CreateApplication\n")
+	require.Contains(t, plan, `"ssoAcsUrl": "(known after apply)"`)
+	require.Contains(t, plan, "# fetch-metadata: will run (read only)\nOkta application (known after apply)")
+	require.Contains(t, plan, `"x509_certificate": "(known after apply)"`)
+	require.Contains(t, plan, "# create-scim-connection: not selected, skipped")
+	require.Contains(t, plan, "# create-oidc-connection: not selected, skipped")
+
+	require.Empty(t, mutatingRequests(fakeStytch.Requests()))
+	require.Empty(t, mutatingRequests(fakeOkta.Requests()))
+	require.Empty(t, provider.conf.StytchResult.OrganizationID)
+	require.Zero(t, provider.saves)
+}
+
+func TestPlan_ResumesAfterTheDoneSteps(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeSetup(t)
+	delete(provider.conf.Steps, StepFetchMetadata)
+	require.NotEmpty(t, mutatingRequests(fakeStytch.Requests()), "the setup calls are recorded")
+	stytchRequests, oktaRequests, saves := len(fakeStytch.Requests()), len(fakeOkta.Requests()), provider.saves
+
+	var out bytes.Buffer
+	require.NoError(t, s.Plan(context.Background(), &out))
+	plan := out.String()
+
+	// The recorded IDs are used, the connection is updated again once the metadata is fetched
+	require.Contains(t, plan, "# create-organization: already done ("+provider.conf.StytchResult.OrganizationID+"), skipped")
+	require.Contains(t, plan, "# create-application: already done ("+provider.conf.OktaResult.ApplicationID+"), skipped")
+	require.Contains(t, plan, "# fetch-metadata: will run (read only)\nOkta application "+provider.conf.OktaResult.ApplicationID)
+	require.Contains(t, plan, "# update-connection: will run\nStytch SSO.SAML.UpdateConnection\n")
+	require.Contains(t, plan, `"connection_id": "`+provider.conf.StytchResult.ConnectionID+`"`)
+	require.Contains(t, plan, `"idp_entity_id": "(known after apply)"`)
+
+	require.Empty(t, mutatingRequests(fakeStytch.Requests()[stytchRequests:]))
+	require.Empty(t, mutatingRequests(fakeOkta.Requests()[oktaRequests:]))
+	require.Equal(t, saves, provider.saves)
+}
+
+func TestRedactedJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload any
+		want    string
+	}{
+		{
+			name: "nested secrets are masked",
+			payload: map[string]any{
+				"display_name": "Okta",
+				"oidc": map[string]any{
+					"client_id":     "abc",
+					"client_secret": "s3cr3t",
+				},
+				"tokens": []any{"t1"},
+			},
+			want: `{
+  "display_name": "Okta",
+  "oidc": {
+    "client_id": "abc",
+    "client_secret": "<redacted>"
+  },
+  "tokens": "<redacted>"
+}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := redactedJSON(tt.payload)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
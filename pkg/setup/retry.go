@@ -0,0 +1,282 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/stytcherror"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// DefaultRetryConf is used when the bootstraper has no retry policy
+var DefaultRetryConf = config.RetryConf{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+// NewHTTPClient returns the client to give to the Stytch and IdP SDKs
+// It lets the retry policy see the status and rate limit headers of the responses
+// since the SDK errors do not expose them
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: &recordingTransport{Base: http.DefaultTransport}}
+}
+
+// recordingTransport stores the last response of a call in the attempt found in the request context
+type recordingTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if a, ok := req.Context().Value(attemptKey{}).(*attempt); ok && resp != nil {
+		a.record(resp)
+	}
+	return resp, err
+}
+
+type attemptKey struct{}
+
+// attempt is the last HTTP response seen during one try of a call
+type attempt struct {
+	mu     sync.Mutex
+	status int
+	header http.Header
+	// sent is set once a request was written to the connection, the API may have applied it
+	sent bool
+}
+
+func (a *attempt) record(resp *http.Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status = resp.StatusCode
+	a.header = resp.Header.Clone()
+}
+
+// withAttempt returns the context of one try, recording its responses and whether a request was sent
+func withAttempt(ctx context.Context, a *attempt) context.Context {
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				a.mu.Lock()
+				a.sent = true
+				a.mu.Unlock()
+			}
+		},
+	})
+	return context.WithValue(ctx, attemptKey{}, a)
+}
+
+// retry calls op until it succeeds, fails with a fatal error or the attempts of the policy are exhausted
+// op must be idempotent, creates use retryCreate
+func (s *OktaSAMLConnectionBootstraper) retry(ctx context.Context, name string, op func(ctx context.Context) error) error {
+	return s.retryCall(ctx, name, false, op, nil)
+}
+
+// retryCreate calls op like retry but only retries when the resource cannot have been created:
+// the API rate limited the request or the request was never sent.
+// Otherwise find looks the resource up, it is recorded by find when the create went through despite the error
+// and created again only when it is missing. A nil find gives up on these errors.
+func (s *OktaSAMLConnectionBootstraper) retryCreate(ctx context.Context, name string, op func(ctx context.Context) error, find func(ctx context.Context) (bool, error)) error {
+	return s.retryCall(ctx, name, true, op, find)
+}
+
+func (s *OktaSAMLConnectionBootstraper) retryCall(ctx context.Context, name string, create bool, op func(ctx context.Context) error, find func(ctx context.Context) (bool, error)) error {
+	policy := s.Retry
+	if policy == nil {
+		policy = &DefaultRetryConf
+	}
+
+	for n := 1; ; n++ {
+		a := &attempt{}
+		err := op(withAttempt(ctx, a))
+		if err == nil {
+			return nil
+		}
+
+		retryable, wait := classify(err, a, time.Now())
+		if !retryable {
+			return err
+		}
+
+		if create && mayBeApplied(err, a) {
+			if find == nil {
+				return err
+			}
+
+			var found bool
+			findErr := s.retry(ctx, name, func(ctx context.Context) (err error) {
+				found, err = find(ctx)
+				return err
+			})
+			if findErr != nil {
+				return errors.Join(err, fmt.Errorf("error looking up the resource it may have created %w", findErr))
+			}
+			if found {
+				log.Printf("%s failed but the resource was created, keeping it: %v", name, err)
+				return nil
+			}
+		}
+		if n >= policy.MaxAttempts {
+			return &transientError{err: err, attempts: n}
+		}
+		if wait == 0 {
+			wait = backoff(policy, n)
+		}
+
+		log.Printf("%s failed (attempt %d/%d), retrying in %s: %v", name, n, policy.MaxAttempts, wait.Round(time.Millisecond), err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// transientError is a retryable error that persisted after every attempt of the policy
+type transientError struct {
+	err      error
+	attempts int
+}
+
+func (e *transientError) Error() string {
+	return fmt.Sprintf("%v (gave up after %d attempts)", e.err, e.attempts)
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// isTransient tells whether err was still retryable when the retry policy gave up
+func isTransient(err error) bool {
+	var transient *transientError
+	return errors.As(err, &transient)
+}
+
+// backoff is an exponential backoff with jitter, so concurrent tenants do not retry in lockstep
+func backoff(policy *config.RetryConf, n int) time.Duration {
+	wait := policy.InitialBackoff
+	for i := 1; i < n && wait < policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, policy.MaxBackoff)
+
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// classify tells whether err is worth retrying
+// the returned duration is the wait requested by the API, zero when it did not ask for one
+func classify(err error, a *attempt, now time.Time) (bool, time.Duration) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, 0
+	}
+	if isConnectionError(err) {
+		return true, 0
+	}
+
+	status, header := errorStatus(err, a)
+	if !retryableStatus(status) {
+		return false, 0
+	}
+	return true, retryAfter(status, header, now)
+}
+
+// mayBeApplied tells whether the API may have applied a failed call
+// A rate limited request was rejected before being processed, anything else after the request was sent is ambiguous
+func mayBeApplied(err error, a *attempt) bool {
+	a.mu.Lock()
+	sent := a.sent
+	a.mu.Unlock()
+
+	if !sent {
+		return false
+	}
+	status, _ := errorStatus(err, a)
+	return status != http.StatusTooManyRequests
+}
+
+// errorStatus is the HTTP status of a failed call
+// The status of the API error is preferred to the last response seen
+// Okta and Graph calls can do several requests, the last one is the one that failed
+func errorStatus(err error, a *attempt) (int, http.Header) {
+	a.mu.Lock()
+	status, header := a.status, a.header
+	a.mu.Unlock()
+
+	var stytchErr stytcherror.Error
+	var graphErr *graphError
+	switch {
+	case errors.As(err, &stytchErr):
+		status = stytchErr.StatusCode
+	case errors.As(err, &graphErr):
+		status = graphErr.StatusCode
+	case isConnectionError(err):
+		// The connection was reset or timed out before we got an answer
+		status = 0
+	}
+	return status, header
+}
+
+func isConnectionError(err error) bool {
+	var urlErr *url.Error
+	var opErr *net.OpError
+	return errors.As(err, &urlErr) && urlErr.Timeout() || errors.As(err, &opErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryableStatus lists the transient errors, any other 4xx is a problem with our request
+// e.g. Stytch duplicate_slug or Okta E0000001 validation errors would fail the same way again
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter reads the wait requested by the API
+// Retry-After is either seconds or an HTTP date, Okta sends the epoch second its rate limit resets
+// ref: https://developer.okta.com/docs/reference/rl-best-practices/
+func retryAfter(status int, header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil && date.After(now) {
+			return date.Sub(now)
+		}
+	}
+
+	// Okta sends X-Rate-Limit-Reset on every response, it only matters once the limit is hit
+	if status == http.StatusTooManyRequests {
+		if reset, err := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+			if at := time.Unix(reset, 0); at.After(now) {
+				// Okta resets on the second, leave it one more to be on the safe side
+				return at.Sub(now) + time.Second
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,204 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/stytcherror"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+func TestClassify(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		err       error
+		status    int
+		header    http.Header
+		retryable bool
+		wait      time.Duration
+	}{
+		{
+			name:      "stytch rate limited",
+			err:       stytcherror.Error{StatusCode: http.StatusTooManyRequests, ErrorType: "too_many_requests"},
+			retryable: true,
+		},
+		{
+			name:      "stytch internal error wrapped",
+			err:       fmt.Errorf("error creating Organizations %w", stytcherror.Error{StatusCode: http.StatusInternalServerError}),
+			retryable: true,
+		},
+		{
+			name: "stytch duplicate slug",
+			err:  stytcherror.Error{StatusCode: http.StatusBadRequest, ErrorType: "organization_slug_already_used"},
+		},
+		{
+			name:      "retry-after seconds",
+			err:       fmt.Errorf("unexpected status 503 Service Unavailable"),
+			status:    http.StatusServiceUnavailable,
+			header:    http.Header{"Retry-After": {"7"}},
+			retryable: true,
+			wait:      7 * time.Second,
+		},
+		{
+			name:      "retry-after date",
+			err:       fmt.Errorf("unexpected status 429 Too Many Requests"),
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"Retry-After": {now.Add(time.Minute).Format(http.TimeFormat)}},
+			retryable: true,
+			wait:      time.Minute,
+		},
+		{
+			name:      "okta rate limit reset",
+			err:       fmt.Errorf("429 Too Many Requests"),
+			status:    http.StatusTooManyRequests,
+			header:    http.Header{"X-Rate-Limit-Reset": {strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)}},
+			retryable: true,
+			wait:      11 * time.Second,
+		},
+		{
+			name:   "okta validation error",
+			err:    fmt.Errorf("400 Bad Request"),
+			status: http.StatusBadRequest,
+			header: http.Header{"X-Rate-Limit-Reset": {strconv.FormatInt(now.Add(10*time.Second).Unix(), 10)}},
+		},
+		{
+			name:      "graph unavailable",
+			err:       &graphError{StatusCode: http.StatusServiceUnavailable},
+			retryable: true,
+		},
+		{
+			name:   "canceled",
+			err:    context.Canceled,
+			status: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retryable, wait := classify(tt.err, &attempt{status: tt.status, header: tt.header}, now)
+			require.Equal(t, tt.retryable, retryable)
+			require.Equal(t, tt.wait, wait)
+		})
+	}
+}
+
+func TestRetry_MetadataFetch(t *testing.T) {
+	metadata, err := os.ReadFile("testdata/idp_metadata.xml")
+	require.NoError(t, err)
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(metadata)
+	}))
+	defer server.Close()
+
+	s := &OktaSAMLConnectionBootstraper{
+		IdP:   NewMetadataProvider(server.URL, nil),
+		Retry: &config.RetryConf{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+
+	var got *config.OktaSsoParameters
+	err = s.retry(context.Background(), "fetch metadata", func(ctx context.Context) (err error) {
+		got, err = s.IdP.FetchMetadata(ctx, "")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+	require.Equal(t, "http://www.okta.com/exk0000000000000000", got.IdpEntityID)
+}
+
+func TestRetryCreate(t *testing.T) {
+	// The server creates the resource then answers with the status
+	created := 0
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusTooManyRequests {
+			created++
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s := &OktaSAMLConnectionBootstraper{
+		Retry: &config.RetryConf{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	client := NewHTTPClient()
+	create := func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+	found := func(context.Context) (bool, error) { return created > 0, nil }
+
+	tests := []struct {
+		name    string
+		status  int
+		find    func(context.Context) (bool, error)
+		err     bool
+		created int
+	}{
+		{
+			name:    "created despite the error is found",
+			status:  http.StatusServiceUnavailable,
+			find:    found,
+			created: 1,
+		},
+		{
+			name:    "not looked up",
+			status:  http.StatusServiceUnavailable,
+			err:     true,
+			created: 1,
+		},
+		{
+			name:   "rate limited",
+			status: http.StatusTooManyRequests,
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			created, status = 0, tt.status
+			err := s.retryCreate(context.Background(), "create", create, tt.find)
+			require.Equal(t, tt.err, err != nil)
+			require.Equal(t, tt.created, created)
+			// Only the rate limited request is sent again, until the attempts are exhausted
+			if tt.status == http.StatusTooManyRequests {
+				require.True(t, isTransient(err))
+			}
+		})
+	}
+
+	// A request that could not be sent is retried
+	server.Close()
+	attempts := 0
+	err := s.retryCreate(context.Background(), "create", func(ctx context.Context) error {
+		attempts++
+		return create(ctx)
+	}, nil)
+	require.True(t, isTransient(err))
+	require.Equal(t, 3, attempts)
+}
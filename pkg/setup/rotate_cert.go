@@ -0,0 +1,121 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+// RotateCertificate rotates the signing certificate of the IdP application
+// The new certificate is trusted by Stytch before the IdP starts using it,
+// the old one stays trusted until FinalizeCertificateRotation removes it
+func (s *OktaSAMLConnectionBootstraper) RotateCertificate(ctx context.Context, validityYears int) (string, error) {
+	rotator, ok := s.IdP.(SigningKeyRotator)
+	if !ok {
+		return "", fmt.Errorf("%s does not support certificate rotation", s.IdP.Name())
+	}
+
+	ctx = journal.WithStep(ctx, "rotate-cert")
+
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return "", fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	if conf.StytchResult.ConnectionID == "" || conf.OktaResult.ApplicationID == "" {
+		return "", fmt.Errorf("nothing to rotate, did you complete the setup?")
+	}
+
+	// Step 1: Generate a new key credential on the IdP application
+	kid, cert, err := rotator.GenerateSigningKey(ctx, conf.OktaResult.ApplicationID, validityYears)
+	targets := map[string]string{"application_id": conf.OktaResult.ApplicationID}
+	request := map[string]int{"validity_years": validityYears}
+	if err != nil {
+		s.Journal.Record(ctx, s.IdP.Name()+" GenerateSigningKey", targets, request, nil, err)
+		return "", fmt.Errorf("error generating %s signing key %w", s.IdP.Name(), err)
+	}
+	s.Journal.Record(ctx, s.IdP.Name()+" GenerateSigningKey", targets, request, map[string]string{"kid": kid}, nil)
+
+	// Step 2: Trust the new certificate in Stytch, alongside the current one
+	current, err := s.IdP.FetchMetadata(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return kid, fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+	next := *current
+	next.X509Certificate = cert
+	mapping := s.IdP.AttributeMapping(conf.SetupInput)
+	if err := s.updateStytchConnection(ctx, conf.OrganizationID, conf.ConnectionID, &next, mapping); err != nil {
+		return kid, fmt.Errorf("error adding the new certificate to the SSO SAML Connection %w", err)
+	}
+
+	// Step 3: Attach the new key to the IdP application
+	err = rotator.ActivateSigningKey(ctx, conf.OktaResult.ApplicationID, kid)
+	s.Journal.Record(ctx, s.IdP.Name()+" ActivateSigningKey", map[string]string{"application_id": conf.OktaResult.ApplicationID, "kid": kid}, nil, nil, err)
+	if err != nil {
+		return kid, fmt.Errorf("error activating %s signing key %s %w", s.IdP.Name(), kid, err)
+	}
+
+	// Step 4: Re-fetch the metadata and push it so the connection matches what the IdP publishes
+	conf.OktaResult.SsoParameters, err = s.IdP.FetchMetadata(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return kid, fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+	if normalizeCertificate(conf.OktaResult.SsoParameters.X509Certificate) != normalizeCertificate(cert) {
+		return kid, fmt.Errorf("%s metadata does not publish the new certificate yet, run the rotation again", s.IdP.Name())
+	}
+	if err := s.updateStytchConnection(ctx, conf.OrganizationID, conf.ConnectionID, conf.OktaResult.SsoParameters, mapping); err != nil {
+		return kid, fmt.Errorf("error updating SSO SAML Connection %w", err)
+	}
+
+	// The result records the certificate in use, cert-status and verify read it from there
+	if err := s.ConfProvider.Save(); err != nil {
+		return kid, fmt.Errorf("error saving Configuration %w", err)
+	}
+
+	return kid, nil
+}
+
+// FinalizeCertificateRotation removes from the Stytch connection every verification certificate
+// other than the one currently published in the IdP metadata, it returns the number of removed certificates
+func (s *OktaSAMLConnectionBootstraper) FinalizeCertificateRotation(ctx context.Context) (int, error) {
+	ctx = journal.WithStep(ctx, "finalize-cert-rotation")
+
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return 0, fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	current, err := s.IdP.FetchMetadata(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+
+	connection, err := s.getStytchConnection(ctx, conf.OrganizationID, conf.ConnectionID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching SSO SAML Connection %w", err)
+	}
+
+	// Never leave the connection without the certificate in use
+	active := normalizeCertificate(current.X509Certificate)
+	trusted := false
+	for _, cert := range connection.VerificationCertificates {
+		trusted = trusted || normalizeCertificate(cert.Certificate) == active
+	}
+	if !trusted {
+		return 0, fmt.Errorf("the SSO SAML Connection does not trust the certificate published by %s, run the rotation first", s.IdP.Name())
+	}
+
+	removed := 0
+	for _, cert := range connection.VerificationCertificates {
+		if normalizeCertificate(cert.Certificate) == active {
+			continue
+		}
+		if err := s.deleteStytchVerificationCertificate(ctx, conf.OrganizationID, conf.ConnectionID, cert.CertificateID); err != nil {
+			return removed, fmt.Errorf("error deleting verification certificate %s %w", cert.CertificateID, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
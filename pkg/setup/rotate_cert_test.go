@@ -0,0 +1,46 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+func TestRotateCertificate_TrustsBothCertificatesUntilFinalized(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeSetup(t)
+	ctx := context.Background()
+	appID := provider.conf.OktaResult.ApplicationID
+	previous := provider.conf.OktaResult.SsoParameters.X509Certificate
+	saves := provider.saves
+
+	kid, err := s.RotateCertificate(ctx, 2)
+	require.NoError(t, err)
+	require.NotEmpty(t, kid)
+
+	// The result records the new certificate
+	rotated, ok := fakeOkta.SigningCertificate(appID)
+	require.True(t, ok)
+	require.Equal(t, saves+1, provider.saves)
+	require.Equal(t, normalizeCertificate(rotated), normalizeCertificate(provider.conf.OktaResult.SsoParameters.X509Certificate))
+	require.NotEqual(t, normalizeCertificate(previous), normalizeCertificate(rotated))
+
+	// Stytch trusts both certificates until the rotation is finalized
+	conn, ok := fakeStytch.Connection(provider.conf.StytchResult.ConnectionID)
+	require.True(t, ok)
+	require.Len(t, conn.VerificationCertificates, 2)
+}
+
+func TestRotateCertificate_Unsupported(t *testing.T) {
+	s := &OktaSAMLConnectionBootstraper{
+		IdP:          NewMetadataProvider("testdata/idp_metadata.xml", nil),
+		ConfProvider: &memoryConfig{conf: &config.SetupConfig{SetupInput: &config.SetupInput{}, SetupResult: &config.SetupResult{}}},
+	}
+	_, err := s.RotateCertificate(context.Background(), 2)
+	require.ErrorContains(t, err, "does not support certificate rotation")
+
+	s.IdP = &OktaProvider{}
+	_, err = s.RotateCertificate(context.Background(), 2)
+	require.ErrorContains(t, err, "nothing to rotate")
+}
@@ -2,100 +2,72 @@ package setup
 
 import (
 	"context"
-	"log"
+	"fmt"
 
 	"github.com/okta/okta-sdk-golang/v4/okta"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/b2bstytchapi"
 	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
 )
 
-// Set up an Okta SAML Connection
+// Protocols of the SSO connection created by Setup
+const (
+	ProtocolSAML = "saml"
+	ProtocolOIDC = "oidc"
+)
+
+// Set up a SAML Connection between Stytch and an IdP (Okta by default)
 // ref: https://stytch.com/docs/b2b/guides/sso/okta-saml
 type OktaSAMLConnectionBootstraper struct {
 	// Clients
 	StytchClient *b2bstytchapi.API
-	OktaClient   *okta.APIClient
+	IdP          IdentityProvider
+	// Protocol of the SSO connection, ProtocolSAML when empty
+	Protocol string
+	// Retry is the policy of the API calls made by Setup, DefaultRetryConf when nil
+	Retry *config.RetryConf
+	// FromStep re-runs a step and all the following ones, OnlyStep re-runs a single step
+	// By default only the steps not yet done are run (see StepNames)
+	FromStep string
+	OnlyStep string
+	// Journal records every mutating API call, nil disables it
+	Journal *journal.Journal
+	// Secrets keeps the credentials created by Setup, such as the SCIM bearer token
+	Secrets config.SecretStore
 	// Persistent config (Those will be needed in the )
 	ConfProvider SetupConfig
 }
 
 func NewOktaSAMLConnectionBootstraper(stytch *b2bstytchapi.API, okta *okta.APIClient) *OktaSAMLConnectionBootstraper {
+	return NewSAMLConnectionBootstraper(stytch, NewOktaProvider(okta))
+}
+
+func NewSAMLConnectionBootstraper(stytch *b2bstytchapi.API, idp IdentityProvider) *OktaSAMLConnectionBootstraper {
 	return &OktaSAMLConnectionBootstraper{
 		StytchClient: stytch,
-		OktaClient:   okta,
-		ConfProvider: &config.ViperConfigProvider{},
+		IdP:          idp,
+		ConfProvider: config.NewViperConfigProvider(config.NewFileStateStore(config.StateFile)),
 	}
 }
 
 // SetupConfig is a abstraction to help us retrive our configuration data
 // For testing purposed thay can be stored in YAML file
-// But in a live application we might rely on a config server or a vault
+// But in a live application we might rely on a config server or a vault, see config.VaultConfigProvider
 type SetupConfig interface {
 	Save() error
 	Load() (*config.SetupConfig, error)
 }
 
 // Setup will Perform the bootstraping oprations between Stych and Okta
-// To ensure idempotency of this function, after each step is performed the resulting configuration is persisted
+// To ensure idempotency of this function, after each step is performed its status is persisted in the SetupResult
 // This means that if run a second time only the steps not yet completed will be played again
+// A failing step is returned as a *StepError
 func (s *OktaSAMLConnectionBootstraper) Setup(ctx context.Context) (err error) {
 	// Load our configuration file, this file is empty if we start from scrath
 	conf, err := s.ConfProvider.Load()
 	if err != nil {
-		log.Fatalf("error loading Configuration %s", err)
-		return err
-	}
-
-	// Step 0. Create a New Organisation
-	if conf.StytchResult.OrganizationID == "" {
-		conf.StytchResult.OrganizationID, err = s.setupStytchOrganisation(ctx, &conf.StytchSetupInput)
-
-		if err != nil {
-			log.Fatalf("error creating Organizations %s", err)
-			return err
-		}
-
-		s.ConfProvider.Save()
-	}
-
-	// Step 1. Create a new SAML connection
-	if conf.StytchResult.ConnectionID == "" {
-		conf.StytchResult.ConnectionID, conf.StytchResult.SsoParameters, err = s.createStytchConnection(ctx,
-			&conf.StytchSetupInput, conf.StytchResult.OrganizationID)
-
-		if err != nil {
-			log.Fatalf("error creating SSO SAML Connection %s", err)
-			return
-		}
-
-		s.ConfProvider.Save()
-	}
-
-	// Step 2: Create and configure a new Okta Application
-	if conf.OktaResult.ApplicationID == "" {
-		conf.OktaResult.ApplicationID, err = s.setupOktaSamlApplication(ctx, &conf.OktaSetupInput, conf.StytchResult.SsoParameters)
-
-		if err != nil {
-			log.Fatalf("error creating Okta Application %s", err)
-			return
-		}
-
-		s.ConfProvider.Save()
-	}
-
-	// Step 3: Fetch Okta SAML Metdata
-	conf.OktaResult.SsoParameters, err = s.getOktaSamlApplicationMetada(ctx, conf.ApplicationID)
-	if err != nil {
-		log.Fatalf("error fetch Okta Application SSO metadata %s", err)
-		return
-	}
-
-	// Step 4: Update Stych SSO Connactions
-	err = s.updateStytchConnection(ctx, conf.OrganizationID, conf.ConnectionID, conf.OktaResult.SsoParameters)
-	if err != nil {
-		log.Fatalf("error updating SSO SAML Connection %s", err)
-		return
+		return fmt.Errorf("error loading Configuration %w", err)
 	}
 
-	return
+	return s.runSteps(ctx, conf)
 }
@@ -0,0 +1,400 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+const (
+	graphBaseURL              = "https://graph.microsoft.com/v1.0"
+	entraLoginURL             = "https://login.microsoftonline.com"
+	graphTokenScope           = "https://graph.microsoft.com/.default"
+	entraProvisioningAttempts = 5 // number of attempts while the service principal is being provisioned
+
+	// The "non-gallery" template is the one behind "Create your own application" in the Entra portal
+	// ref: https://learn.microsoft.com/en-us/graph/application-saml-sso-configure-api
+	entraNonGalleryTemplateID = "8adf8e6e-67b2-4cf2-a259-e3dc5476c621"
+)
+
+// EntraProvider implements IdentityProvider with the Microsoft Graph API
+// ref: https://learn.microsoft.com/en-us/graph/application-saml-sso-configure-api
+// The ApplicationID recorded in the SetupResult is the object ID of the application registration
+type EntraProvider struct {
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+
+	HTTPClient *http.Client
+	// BaseURL and LoginURL can be overridden to target a national cloud or a test server
+	BaseURL  string
+	LoginURL string
+
+	// followUpBackoff is the wait before the first retry of a call following the instantiation, 1s when unset
+	followUpBackoff time.Duration
+
+	// the token is shared when several tenants are bootstrapped concurrently
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func NewEntraProvider(conf *config.EntraConf) *EntraProvider {
+	return &EntraProvider{
+		TenantID:     conf.TenantID,
+		ClientID:     conf.ClientID,
+		ClientSecret: conf.ClientSecret,
+		HTTPClient:   NewHTTPClient(),
+		BaseURL:      graphBaseURL,
+		LoginURL:     entraLoginURL,
+	}
+}
+
+func (p *EntraProvider) Name() string {
+	return "Entra ID"
+}
+
+// entraInstantiateResponse is the result of instantiating an application template
+type entraInstantiateResponse struct {
+	Application struct {
+		ID    string `json:"id"`
+		AppID string `json:"appId"`
+	} `json:"application"`
+	ServicePrincipal struct {
+		ID string `json:"id"`
+	} `json:"servicePrincipal"`
+}
+
+type entraSigningCertificate struct {
+	Thumbprint string `json:"thumbprint"`
+}
+
+// CreateSAMLApplication instantiates a non-gallery application, switches its service principal to SAML
+// then registers Stytch ACS URL and Audience and a token signing certificate
+// The instantiation is sent once, when a following call fails the application is deleted so no orphan is left behind
+func (p *EntraProvider) CreateSAMLApplication(ctx context.Context, input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, error) {
+	var app entraInstantiateResponse
+	err := p.do(ctx, http.MethodPost, "/applicationTemplates/"+entraNonGalleryTemplateID+"/instantiate",
+		map[string]any{"displayName": input.SAMLAppLabel}, &app)
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.configureSAMLApplication(ctx, &app, input, stytchConf); err != nil {
+		// the application is deleted even when the run was interrupted
+		if deleteErr := p.DeleteApplication(context.WithoutCancel(ctx), app.Application.ID); deleteErr != nil {
+			return "", errors.Join(err, fmt.Errorf("error deleting the partially created application %s %w", app.Application.ID, deleteErr))
+		}
+		return "", err
+	}
+
+	return app.Application.ID, nil
+}
+
+// configureSAMLApplication runs the calls following the instantiation, each one is retried on its own
+func (p *EntraProvider) configureSAMLApplication(ctx context.Context, app *entraInstantiateResponse, input *config.SetupInput, stytchConf *config.StychSsoParameters) error {
+	err := p.retryFollowUp(ctx, func() error {
+		return p.do(ctx, http.MethodPatch, "/servicePrincipals/"+app.ServicePrincipal.ID,
+			map[string]any{"preferredSingleSignOnMode": "saml"}, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = p.retryFollowUp(ctx, func() error {
+		return p.do(ctx, http.MethodPatch, "/applications/"+app.Application.ID, entraApplicationSettings(stytchConf), nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	var cert entraSigningCertificate
+	err = p.retryFollowUp(ctx, func() error {
+		return p.do(ctx, http.MethodPost, "/servicePrincipals/"+app.ServicePrincipal.ID+"/addTokenSigningCertificate",
+			map[string]any{"displayName": "CN=" + input.SAMLAppLabel}, &cert)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.retryFollowUp(ctx, func() error {
+		return p.do(ctx, http.MethodPatch, "/servicePrincipals/"+app.ServicePrincipal.ID,
+			map[string]any{"preferredTokenSigningKeyThumbprint": cert.Thumbprint}, nil)
+	})
+}
+
+// entraApplicationSettings wires the application registration to the Stytch connection
+func entraApplicationSettings(stytchConf *config.StychSsoParameters) map[string]any {
+	return map[string]any{
+		"identifierUris": []string{stytchConf.Audience},
+		"web": map[string]any{
+			"redirectUris": []string{stytchConf.AcsUrl},
+		},
+		// Emit the groups claim so we can use implicit group assignements
+		"groupMembershipClaims": "SecurityGroup",
+	}
+}
+
+func (p *EntraProvider) SAMLApplicationPayload(input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, any) {
+	return "Microsoft Graph applicationTemplates.instantiate + applications.update", map[string]any{
+		"templateId":                entraNonGalleryTemplateID,
+		"displayName":               input.SAMLAppLabel,
+		"preferredSingleSignOnMode": "saml",
+		"application":               entraApplicationSettings(stytchConf),
+	}
+}
+
+// FetchMetadata downloads the tenant federation metadata scoped to the application
+func (p *EntraProvider) FetchMetadata(ctx context.Context, appID string) (*config.OktaSsoParameters, error) {
+	var app struct {
+		AppID string `json:"appId"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/applications/"+appID+"?$select=appId", nil, &app); err != nil {
+		return nil, err
+	}
+
+	metadataURL := fmt.Sprintf("%s/%s/federationmetadata/2007-06/federationmetadata.xml?appid=%s",
+		p.LoginURL, p.TenantID, url.QueryEscape(app.AppID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	metadata, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching Entra ID metadata: %s", resp.Status)
+	}
+
+	return ssoParametersFromMetadata(string(metadata), "")
+}
+
+// FindSAMLApplication looks up the application registration with this display name
+func (p *EntraProvider) FindSAMLApplication(ctx context.Context, label string) (string, error) {
+	var apps struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	// OData escapes quotes by doubling them
+	filter := "displayName eq '" + strings.ReplaceAll(label, "'", "''") + "'"
+	if err := p.do(ctx, http.MethodGet, "/applications?$select=id&$filter="+url.QueryEscape(filter), nil, &apps); err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, app := range apps.Value {
+		ids = append(ids, app.ID)
+	}
+	return uniqueMatch("Entra ID applications", label, ids)
+}
+
+// GetSAMLApplication reads back the identifier and reply URL of the application registration
+// Entra claims are not exposed, Verify only compares the URLs
+func (p *EntraProvider) GetSAMLApplication(ctx context.Context, appID string) (*SAMLApplication, error) {
+	var app struct {
+		IdentifierUris []string `json:"identifierUris"`
+		Web            struct {
+			RedirectUris []string `json:"redirectUris"`
+		} `json:"web"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/applications/"+appID+"?$select=identifierUris,web", nil, &app); err != nil {
+		return nil, err
+	}
+
+	result := &SAMLApplication{}
+	if len(app.IdentifierUris) > 0 {
+		result.Audience = app.IdentifierUris[0]
+	}
+	if len(app.Web.RedirectUris) > 0 {
+		result.AcsUrl = app.Web.RedirectUris[0]
+	}
+	return result, nil
+}
+
+// AttributeMapping maps the default claims issued by Entra ID to Stytch member fields
+// Entra emits fixed claim names, the attributes section of the input only applies to Okta
+// ref: https://learn.microsoft.com/en-us/entra/identity-platform/reference-saml-tokens
+func (p *EntraProvider) AttributeMapping(input *config.SetupInput) map[string]any {
+	return map[string]any{
+		"email":      "NameID",
+		"first_name": "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+		"last_name":  "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+		"groups":     "http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+	}
+}
+
+// DeleteApplication deletes the application registration, Entra removes the service principal with it
+func (p *EntraProvider) DeleteApplication(ctx context.Context, appID string) error {
+	err := p.do(ctx, http.MethodDelete, "/applications/"+appID, nil, nil)
+	if isGraphNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// graphError is returned for any non 2xx answer of Microsoft Graph
+type graphError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *graphError) Error() string {
+	return fmt.Sprintf("Microsoft Graph Error - status code: %d, code: %s, message: %s", e.StatusCode, e.Code, e.Message)
+}
+
+func isGraphNotFound(err error) bool {
+	var graphErr *graphError
+	return errors.As(err, &graphErr) && graphErr.StatusCode == http.StatusNotFound
+}
+
+// retryFollowUp retries a call on the application just instantiated
+// The service principal takes a few seconds to be available after instantiation and answers 404 meanwhile,
+// transient errors are retried too since the application would otherwise be deleted and created again
+func (p *EntraProvider) retryFollowUp(ctx context.Context, call func() error) (err error) {
+	for i := 0; i < entraProvisioningAttempts; i++ {
+		err = call()
+		var graphErr *graphError
+		if !isGraphNotFound(err) && !(errors.As(err, &graphErr) && retryableStatus(graphErr.StatusCode)) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.followUpWait(i)):
+		}
+	}
+	return err
+}
+
+// followUpWait grows linearly, the service principal is usually available after a few seconds
+func (p *EntraProvider) followUpWait(i int) time.Duration {
+	wait := p.followUpBackoff
+	if wait == 0 {
+		wait = time.Second
+	}
+	return time.Duration(i+1) * wait
+}
+
+// do sends an authenticated request to Microsoft Graph and decodes the JSON answer into out
+func (p *EntraProvider) do(ctx context.Context, method, path string, in, out any) error {
+	token, err := p.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var graphErr struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&graphErr)
+		return &graphError{
+			StatusCode: resp.StatusCode,
+			Code:       graphErr.Error.Code,
+			Message:    graphErr.Error.Message,
+		}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// accessToken uses the client credentials flow, the token is cached until it expires
+// ref: https://learn.microsoft.com/en-us/entra/identity-platform/v2-oauth2-client-creds-grant-flow
+func (p *EntraProvider) accessToken(ctx context.Context) (string, error) {
+	p.tokenMu.Lock()
+	defer p.tokenMu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"scope":         {graphTokenScope},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/%s/oauth2/v2.0/token", p.LoginURL, p.TenantID), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error obtaining Entra ID access token: %s %s", resp.Status, token.ErrorDescription)
+	}
+
+	p.token = token.AccessToken
+	// Keep a margin so the token does not expire in the middle of a request
+	p.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return p.token, nil
+}
@@ -0,0 +1,187 @@
+package setup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// fakeGraph implements the token endpoint, the federation metadata and the Graph calls used by EntraProvider
+type fakeGraph struct {
+	mu           sync.Mutex
+	tokens       int
+	instantiated []string
+	deleted      []string
+	settings     map[string]any
+	// notFound is the number of 404 answered by the service principal while it is provisioned
+	notFound int
+	// failSettings answers the application update with this status when set
+	failSettings int
+}
+
+func newFakeGraph(t *testing.T) (*fakeGraph, *httptest.Server) {
+	metadata, err := os.ReadFile("testdata/idp_metadata.xml")
+	require.NoError(t, err)
+
+	f := &fakeGraph{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tenant-test/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.tokens++
+		f.mu.Unlock()
+		if r.FormValue("client_secret") != "client-secret-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error_description": "invalid client secret"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token-test", "expires_in": 3600})
+	})
+	mux.HandleFunc("GET /tenant-test/federationmetadata/2007-06/federationmetadata.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("appid") != "app-client-test" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(metadata)
+	})
+
+	graph := http.NewServeMux()
+	graph.HandleFunc("POST /applicationTemplates/{id}/instantiate", func(w http.ResponseWriter, r *http.Request) {
+		f.instantiated = append(f.instantiated, r.PathValue("id"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"application":      map[string]any{"id": "app-object-test", "appId": "app-client-test"},
+			"servicePrincipal": map[string]any{"id": "sp-test"},
+		})
+	})
+	graph.HandleFunc("PATCH /servicePrincipals/sp-test", func(w http.ResponseWriter, r *http.Request) {
+		if f.notFound > 0 {
+			f.notFound--
+			writeGraphError(w, http.StatusNotFound, "Request_ResourceNotFound")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	graph.HandleFunc("POST /servicePrincipals/sp-test/addTokenSigningCertificate", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"thumbprint": "thumbprint-test"})
+	})
+	graph.HandleFunc("PATCH /applications/app-object-test", func(w http.ResponseWriter, r *http.Request) {
+		if f.failSettings != 0 {
+			writeGraphError(w, f.failSettings, "Request_BadRequest")
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&f.settings)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	graph.HandleFunc("GET /applications/app-object-test", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"appId": "app-client-test"})
+	})
+	graph.HandleFunc("DELETE /applications/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "app-object-test" {
+			writeGraphError(w, http.StatusNotFound, "Request_ResourceNotFound")
+			return
+		}
+		f.deleted = append(f.deleted, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/graph/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-test" {
+			writeGraphError(w, http.StatusUnauthorized, "InvalidAuthenticationToken")
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		http.StripPrefix("/graph", graph).ServeHTTP(w, r)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+func writeGraphError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": code, "message": code}})
+}
+
+func newTestEntraProvider(server *httptest.Server) *EntraProvider {
+	return &EntraProvider{
+		TenantID:        "tenant-test",
+		ClientID:        "client-test",
+		ClientSecret:    "client-secret-test",
+		HTTPClient:      server.Client(),
+		BaseURL:         server.URL + "/graph",
+		LoginURL:        server.URL,
+		followUpBackoff: 1,
+	}
+}
+
+var entraTestInput = &config.SetupInput{OktaSetupInput: config.OktaSetupInput{SAMLAppLabel: "Acme SAML App"}}
+
+var entraTestSsoParameters = &config.StychSsoParameters{
+	AcsUrl:   "https://test.stytch.com/v1/b2b/sso/callback/saml-connection-test",
+	Audience: "https://test.stytch.com/saml-connection-test",
+}
+
+func TestEntraProvider_CreatesFetchesAndDeletesTheApplication(t *testing.T) {
+	fake, server := newFakeGraph(t)
+	// The service principal is not available right after the instantiation
+	fake.notFound = 2
+	p := newTestEntraProvider(server)
+	ctx := context.Background()
+
+	appID, err := p.CreateSAMLApplication(ctx, entraTestInput, entraTestSsoParameters)
+	require.NoError(t, err)
+	require.Equal(t, "app-object-test", appID)
+	require.Equal(t, []string{entraNonGalleryTemplateID}, fake.instantiated)
+	require.Equal(t, []any{entraTestSsoParameters.Audience}, fake.settings["identifierUris"])
+	require.Equal(t, 0, fake.notFound)
+
+	got, err := p.FetchMetadata(ctx, appID)
+	require.NoError(t, err)
+	require.Equal(t, "http://www.okta.com/exk0000000000000000", got.IdpEntityID)
+	require.NotEmpty(t, got.X509Certificate)
+
+	// The token is cached across the calls
+	require.Equal(t, 1, fake.tokens)
+
+	require.NoError(t, p.DeleteApplication(ctx, appID))
+	require.Equal(t, []string{appID}, fake.deleted)
+
+	// An application already gone is deleted, even when the Graph error is wrapped
+	require.NoError(t, p.DeleteApplication(ctx, "app-object-missing"))
+	require.True(t, isGraphNotFound(fmt.Errorf("error deleting application %w", &graphError{StatusCode: http.StatusNotFound})))
+}
+
+func TestEntraProvider_PartialFailure(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			fake, server := newFakeGraph(t)
+			fake.failSettings = status
+			p := newTestEntraProvider(server)
+
+			appID, err := p.CreateSAMLApplication(context.Background(), entraTestInput, entraTestSsoParameters)
+			require.ErrorContains(t, err, "Request_BadRequest")
+			require.Empty(t, appID)
+
+			// The application is instantiated once, whatever the retries of the following calls, then deleted
+			require.Len(t, fake.instantiated, 1)
+			require.Equal(t, []string{"app-object-test"}, fake.deleted)
+		})
+	}
+}
+
+func TestEntraProvider_AccessTokenError(t *testing.T) {
+	_, server := newFakeGraph(t)
+	p := newTestEntraProvider(server)
+	p.ClientSecret = "wrong"
+
+	_, err := p.FetchMetadata(context.Background(), "app-object-test")
+	require.ErrorContains(t, err, "invalid client secret")
+}
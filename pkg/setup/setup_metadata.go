@@ -0,0 +1,115 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// MetadataProvider implements IdentityProvider for IdPs we have no API access to (ADFS, PingFederate, Shibboleth...)
+// The customer's admin creates the application by hand, we only consume the metadata document they hand over.
+// The ApplicationID stays empty in the SetupResult since there is nothing for us to manage on the IdP side.
+type MetadataProvider struct {
+	// Source is a path to a metadata XML file or an http(s) URL
+	Source string
+	// EntityID selects the IdP when Source is an EntitiesDescriptor listing several entities
+	EntityID string
+	// Out receives the instructions for the customer's admin
+	Out io.Writer
+
+	HTTPClient *http.Client
+}
+
+func NewMetadataProvider(source string, out io.Writer) *MetadataProvider {
+	return &MetadataProvider{
+		Source:     source,
+		Out:        out,
+		HTTPClient: NewHTTPClient(),
+	}
+}
+
+func (p *MetadataProvider) Name() string {
+	return "IdP metadata"
+}
+
+// CreateSAMLApplication does not create anything, it prints what the customer's admin needs to configure their IdP
+func (p *MetadataProvider) CreateSAMLApplication(ctx context.Context, input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, error) {
+	if stytchConf == nil {
+		return "", fmt.Errorf("missing Stytch SSO parameters, the SAML connection must be created first")
+	}
+
+	fmt.Fprintf(p.Out, "Configure the SAML application %q in your IdP with:\n", input.SAMLAppLabel)
+	fmt.Fprintf(p.Out, "  ACS URL (Single sign on URL): %s\n", stytchConf.AcsUrl)
+	fmt.Fprintf(p.Out, "  Audience (SP Entity ID):      %s\n", stytchConf.Audience)
+	fmt.Fprintln(p.Out, "  Attributes released to Stytch:")
+	mapping := p.AttributeMapping(input)
+	fields := make([]string, 0, len(mapping))
+	for field := range mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Fprintf(p.Out, "    %-10s <- %v\n", field, mapping[field])
+	}
+
+	return "", nil
+}
+
+func (p *MetadataProvider) SAMLApplicationPayload(input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, any) {
+	return "manual IdP configuration (no API call)", map[string]any{
+		"label":    input.SAMLAppLabel,
+		"acsUrl":   stytchConf.AcsUrl,
+		"audience": stytchConf.Audience,
+	}
+}
+
+// FetchMetadata reads the metadata document from Source, appID is ignored
+func (p *MetadataProvider) FetchMetadata(ctx context.Context, appID string) (*config.OktaSsoParameters, error) {
+	metadata, err := p.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading IdP metadata from %s: %w", p.Source, err)
+	}
+
+	return ssoParametersFromMetadata(string(metadata), p.EntityID)
+}
+
+func (p *MetadataProvider) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(p.Source, "http://") && !strings.HasPrefix(p.Source, "https://") {
+		return os.ReadFile(p.Source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/samlmetadata+xml, application/xml")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// AttributeMapping uses the attributes section of the input
+// they have to be released by the customer's IdP
+func (p *MetadataProvider) AttributeMapping(input *config.SetupInput) map[string]any {
+	return config.StytchAttributeMapping(input.Attributes)
+}
+
+// DeleteApplication is a no-op, the application is owned by the customer's admin
+func (p *MetadataProvider) DeleteApplication(ctx context.Context, appID string) error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package setup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataProvider_FetchMetadata(t *testing.T) {
+	metadata, err := os.ReadFile("testdata/idp_metadata.xml")
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(metadata)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		source string
+	}{
+		{
+			name:   "from file",
+			source: "testdata/idp_metadata.xml",
+		},
+		{
+			name:   "from url",
+			source: server.URL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewMetadataProvider(tt.source, nil)
+			got, err := p.FetchMetadata(context.Background(), "")
+			require.NoError(t, err)
+			require.Equal(t, "http://www.okta.com/exk0000000000000000", got.IdpEntityID)
+			require.Equal(t, "https://dev-000000.okta.com/app/dev-000000_examplesamlapp_1/exk0000000000000000/sso/saml", got.IdpSSOURL)
+			require.NotEmpty(t, got.X509Certificate)
+		})
+	}
+}
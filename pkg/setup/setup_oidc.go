@@ -0,0 +1,134 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/oidc"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// oidcClientSecretKey is the SecretStore key of the client secret of an OIDC connection
+func oidcClientSecretKey(connectionID string) string {
+	return "oidc/" + connectionID + "/client_secret"
+}
+
+// createStytchOIDCConnection creates an OIDC connection, its redirect URL is the callback of the IdP application
+func (s *OktaSAMLConnectionBootstraper) createStytchOIDCConnection(ctx context.Context, organizationID, displayName string) (*config.OIDCResult, error) {
+	params := stytchOIDCConnectionParams(organizationID, displayName)
+	resp, err := s.StytchClient.SSO.OIDC.CreateConnection(ctx, params)
+
+	targets := map[string]string{"organization_id": organizationID}
+	if err != nil {
+		s.Journal.Record(ctx, "Stytch SSO.OIDC.CreateConnection", targets, params, nil, err)
+		return nil, err
+	}
+
+	s.Journal.Record(ctx, "Stytch SSO.OIDC.CreateConnection", targets, params,
+		map[string]string{"connection_id": resp.Connection.ConnectionID}, nil)
+
+	return &config.OIDCResult{
+		ConnectionID: resp.Connection.ConnectionID,
+		RedirectURL:  resp.Connection.RedirectURL,
+	}, nil
+}
+
+// createOIDCApplication creates the IdP application and stores its client secret, the other credentials are recorded in result
+func (s *OktaSAMLConnectionBootstraper) createOIDCApplication(ctx context.Context, organizationID, label string, result *config.OIDCResult) error {
+	creator, ok := s.IdP.(OIDCApplicationCreator)
+	if !ok {
+		return fmt.Errorf("%s OIDC applications cannot be created through its API, register the redirect URL %s by hand",
+			s.IdP.Name(), result.RedirectURL)
+	}
+	if s.Secrets == nil {
+		return errors.New("no secret store to keep the OIDC client secret")
+	}
+
+	app, err := creator.CreateOIDCApplication(ctx, label, result.RedirectURL)
+
+	api, payload := creator.OIDCApplicationPayload(label, result.RedirectURL)
+	var recorded map[string]string
+	if err == nil {
+		recorded = map[string]string{"application_id": app.ID, "client_id": app.ClientID}
+	}
+	s.Journal.Record(ctx, api, stytchTargets(organizationID, result.ConnectionID), payload, recorded, err)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Secrets.SetSecret(oidcClientSecretKey(result.ConnectionID), app.ClientSecret); err != nil {
+		err = fmt.Errorf("error storing the client secret of %s application %s %w", s.IdP.Name(), app.ID, err)
+		// The client secret is only returned on creation, without it the application is of no use
+		deleteCtx := context.WithoutCancel(ctx)
+		deleteErr := s.IdP.DeleteApplication(deleteCtx, app.ID)
+		s.Journal.Record(deleteCtx, s.IdP.Name()+" DeleteApplication", map[string]string{"application_id": app.ID}, nil, nil, deleteErr)
+		if deleteErr != nil {
+			return errors.Join(err, fmt.Errorf("error deleting %s application %s %w", s.IdP.Name(), app.ID, deleteErr))
+		}
+		return err
+	}
+
+	result.ApplicationID = app.ID
+	result.Issuer = app.Issuer
+	result.ClientID = app.ClientID
+	return nil
+}
+
+// updateStytchOIDCConnection writes the issuer and client credentials of the IdP application to the connection
+// Stytch discovers the other endpoints from the issuer
+func (s *OktaSAMLConnectionBootstraper) updateStytchOIDCConnection(ctx context.Context, organizationID string, result *config.OIDCResult) error {
+	if s.Secrets == nil {
+		return errors.New("no secret store to read the OIDC client secret from")
+	}
+	secret, err := s.Secrets.GetSecret(oidcClientSecretKey(result.ConnectionID))
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("no client secret for OIDC connection %s in the secret store", result.ConnectionID)
+	}
+
+	params := stytchUpdateOIDCConnectionParams(organizationID, result, secret)
+	resp, err := s.StytchClient.SSO.OIDC.UpdateConnection(ctx, params)
+
+	s.Journal.Record(ctx, "Stytch SSO.OIDC.UpdateConnection", stytchTargets(organizationID, result.ConnectionID), params, nil, err)
+	if err != nil {
+		return err
+	}
+
+	if resp.Warning != "" {
+		log.Printf("OIDC connection %s: %s", result.ConnectionID, resp.Warning)
+	}
+	return nil
+}
+
+// deleteStytchOIDCConnection deletes the connection then forgets its client secret
+func (s *OktaSAMLConnectionBootstraper) deleteStytchOIDCConnection(ctx context.Context, organizationID, connectionID string) error {
+	if err := s.deleteStytchConnection(ctx, organizationID, connectionID); err != nil {
+		return err
+	}
+
+	if s.Secrets == nil {
+		return nil
+	}
+	return s.Secrets.DeleteSecret(oidcClientSecretKey(connectionID))
+}
+
+func stytchOIDCConnectionParams(organizationID, displayName string) *oidc.CreateConnectionParams {
+	return &oidc.CreateConnectionParams{
+		OrganizationID: organizationID,
+		DisplayName:    displayName,
+	}
+}
+
+func stytchUpdateOIDCConnectionParams(organizationID string, result *config.OIDCResult, clientSecret string) *oidc.UpdateConnectionParams {
+	return &oidc.UpdateConnectionParams{
+		OrganizationID: organizationID,
+		ConnectionID:   result.ConnectionID,
+		Issuer:         result.Issuer,
+		ClientID:       result.ClientID,
+		ClientSecret:   clientSecret,
+	}
+}
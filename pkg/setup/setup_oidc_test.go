@@ -0,0 +1,66 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCSetup_CreatesThenDestroysTheConnectionAndApplication(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeBootstraper(t)
+	s.Protocol = ProtocolOIDC
+	secrets := s.Secrets
+
+	ctx := context.Background()
+	require.NoError(t, s.Setup(ctx))
+
+	// No SAML resource is created
+	result := provider.conf.OIDCResult
+	require.Empty(t, provider.conf.StytchResult.ConnectionID)
+	require.Empty(t, provider.conf.OktaResult.ApplicationID)
+
+	app, ok := fakeOkta.OIDCApplication(result.ApplicationID)
+	require.True(t, ok)
+	require.Equal(t, "Acme OIDC App", app.GetLabel())
+	require.Equal(t, []string{result.RedirectURL}, app.Settings.OauthClient.RedirectUris)
+
+	// The client secret is only kept in the secret store
+	conn, ok := fakeStytch.OIDCConnection(result.ConnectionID)
+	require.True(t, ok)
+	require.Equal(t, "active", conn.Status)
+	require.Equal(t, conn.RedirectURL, result.RedirectURL)
+	require.Equal(t, fakeOkta.URL, conn.Issuer)
+	require.Equal(t, app.Credentials.OauthClient.GetClientId(), conn.ClientID)
+	require.Equal(t, app.Credentials.OauthClient.GetClientSecret(), conn.ClientSecret)
+	secret, err := secrets.GetSecret(oidcClientSecretKey(result.ConnectionID))
+	require.NoError(t, err)
+	require.Equal(t, conn.ClientSecret, secret)
+
+	require.NoError(t, s.Destroy(ctx))
+	_, ok = fakeStytch.OIDCConnection(result.ConnectionID)
+	require.False(t, ok)
+	_, ok = fakeOkta.OIDCApplication(result.ApplicationID)
+	require.False(t, ok)
+	secret, err = secrets.GetSecret(oidcClientSecretKey(result.ConnectionID))
+	require.NoError(t, err)
+	require.Empty(t, secret)
+	require.Empty(t, provider.conf.OIDCResult)
+}
+
+func TestOIDCSetup_DeletesTheApplicationWhenTheSecretCannotBeStored(t *testing.T) {
+	s, provider, _, fakeOkta := newFakeBootstraper(t)
+	s.Protocol = ProtocolOIDC
+	s.Secrets = &failingSecrets{}
+	oktaClient, err := fakeOkta.Client()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.ErrorContains(t, s.Setup(ctx), "secret store is read-only")
+
+	// The application whose client secret could not be kept is deleted
+	apps, _, err := oktaClient.ApplicationAPI.ListApplications(ctx).Execute()
+	require.NoError(t, err)
+	require.Empty(t, apps)
+	require.Empty(t, provider.conf.OIDCResult.ApplicationID)
+}
@@ -4,16 +4,180 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/okta/okta-sdk-golang/v4/okta"
 	"github.com/xNok/go-stytch-demo/pkg/config"
 )
 
-func (s *OktaSAMLConnectionBootstraper) setupOktaSamlApplication(ctx context.Context, oktaConf *config.OktaSetupInput, stytchConf *config.StychSsoParameters) (string, error) {
+// OktaProvider implements IdentityProvider with the Okta management API
+// ref: https://stytch.com/docs/b2b/guides/sso/okta-saml
+type OktaProvider struct {
+	Client *okta.APIClient
+}
+
+func NewOktaProvider(client *okta.APIClient) *OktaProvider {
+	return &OktaProvider{
+		Client: client,
+	}
+}
+
+func (p *OktaProvider) Name() string {
+	return "Okta"
+}
+
+// CreateSAMLApplication creates a new Okta SAML application pointing to Stytch
+func (p *OktaProvider) CreateSAMLApplication(ctx context.Context, input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, error) {
+	oktaApp, _, err := p.Client.ApplicationAPI.CreateApplication(ctx).Application(
+		okta.ListApplications200ResponseInner{
+			SamlApplication: oktaSamlApplication(input, stytchConf),
+		},
+	).Execute()
+
+	if err != nil {
+		return "", err
+	}
+
+	return *oktaApp.SamlApplication.Id, nil
+}
+
+func (p *OktaProvider) SAMLApplicationPayload(input *config.SetupInput, stytchConf *config.StychSsoParameters) (string, any) {
+	return "Okta ApplicationAPI.CreateApplication", oktaSamlApplication(input, stytchConf)
+}
+
+// AttributeMapping maps the attribute statements of oktaSamlApplication to Stytch member fields
+func (p *OktaProvider) AttributeMapping(input *config.SetupInput) map[string]any {
+	return config.StytchAttributeMapping(input.Attributes)
+}
+
+// FindSAMLApplication looks up the SAML application with this exact label
+// The q parameter of Okta matches label prefixes, the label is compared afterwards
+func (p *OktaProvider) FindSAMLApplication(ctx context.Context, label string) (string, error) {
+	apps, _, err := p.Client.ApplicationAPI.ListApplications(ctx).Q(label).Execute()
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, app := range apps {
+		if app.SamlApplication != nil && app.SamlApplication.GetLabel() == label {
+			ids = append(ids, app.SamlApplication.GetId())
+		}
+	}
+
+	return uniqueMatch("Okta SAML applications", label, ids)
+}
+
+// GetSAMLApplication reads back the sign on settings of the Okta application
+func (p *OktaProvider) GetSAMLApplication(ctx context.Context, oktaAppID string) (*SAMLApplication, error) {
+	app, _, err := p.Client.ApplicationAPI.GetApplication(ctx, oktaAppID).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	if app.SamlApplication == nil || app.SamlApplication.Settings == nil || app.SamlApplication.Settings.SignOn == nil {
+		return nil, fmt.Errorf("okta application %s is not a SAML application", oktaAppID)
+	}
+	signOn := app.SamlApplication.Settings.SignOn
+
+	result := &SAMLApplication{
+		AcsUrl:     signOn.GetSsoAcsUrl(),
+		Audience:   signOn.GetAudience(),
+		Attributes: []config.SAMLAttribute{},
+	}
+	for _, statement := range signOn.AttributeStatements {
+		attr := config.SAMLAttribute{
+			Name:      statement.GetName(),
+			Namespace: statement.GetNamespace(),
+		}
+		if statement.GetType() == "GROUP" {
+			attr.GroupFilter = &config.GroupFilter{Type: statement.GetFilterType(), Value: statement.GetFilterValue()}
+		} else {
+			attr.Expression = strings.Join(statement.Values, ",")
+		}
+		result.Attributes = append(result.Attributes, attr)
+	}
+
+	return result, nil
+}
+
+// GenerateSigningKey generates a new application key credential, Okta keeps signing with the current one
+// ref: https://developer.okta.com/docs/guides/sign-your-own-saml-csr/main/
+func (p *OktaProvider) GenerateSigningKey(ctx context.Context, oktaAppID string, validityYears int) (string, string, error) {
+	key, _, err := p.Client.ApplicationCredentialsAPI.GenerateApplicationKey(ctx, oktaAppID).ValidityYears(int32(validityYears)).Execute()
+	if err != nil {
+		return "", "", err
+	}
+
+	if key.Kid == nil || len(key.X5c) == 0 {
+		return "", "", fmt.Errorf("okta returned a key without kid or certificate")
+	}
+
+	return *key.Kid, key.X5c[0], nil
+}
+
+// ActivateSigningKey switches the application signing credential to the given key
+func (p *OktaProvider) ActivateSigningKey(ctx context.Context, oktaAppID string, kid string) error {
+	app, _, err := p.Client.ApplicationAPI.GetApplication(ctx, oktaAppID).Execute()
+	if err != nil {
+		return err
+	}
+
+	if app.SamlApplication == nil {
+		return fmt.Errorf("okta application %s is not a SAML application", oktaAppID)
+	}
+	if app.SamlApplication.Credentials == nil {
+		app.SamlApplication.Credentials = okta.NewApplicationCredentials()
+	}
+	if app.SamlApplication.Credentials.Signing == nil {
+		app.SamlApplication.Credentials.Signing = okta.NewApplicationCredentialsSigning()
+	}
+	app.SamlApplication.Credentials.Signing.Kid = okta.PtrString(kid)
+
+	_, _, err = p.Client.ApplicationAPI.ReplaceApplication(ctx, oktaAppID).Application(*app).Execute()
+	return err
+}
+
+// oktaAttributeStatements converts the attributes section of the input to Okta attribute statements
+// The subject (NameID) is configured by SubjectNameIdTemplate and is not a statement
+func oktaAttributeStatements(attributes []config.SAMLAttribute) []okta.SamlAttributeStatement {
+	statements := []okta.SamlAttributeStatement{}
+	for _, attr := range attributes {
+		if attr.Name == config.NameID {
+			continue
+		}
+
+		statement := okta.SamlAttributeStatement{
+			Name: okta.PtrString(attr.Name),
+		}
+		if attr.GroupFilter != nil {
+			statement.Type = okta.PtrString("GROUP")
+			statement.FilterType = okta.PtrString(attr.GroupFilter.Type)
+			statement.FilterValue = okta.PtrString(attr.GroupFilter.Value)
+			statement.Namespace = okta.PtrString(namespaceOrDefault(attr.Namespace, config.NamespaceUnspecified))
+		} else {
+			statement.Type = okta.PtrString("EXPRESSION")
+			statement.Values = []string{attr.Expression}
+			statement.Namespace = okta.PtrString(namespaceOrDefault(attr.Namespace, config.NamespaceBasic))
+		}
+
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+func namespaceOrDefault(namespace, fallback string) string {
+	if namespace == "" {
+		return fallback
+	}
+	return namespace
+}
+
+// oktaSamlApplication builds the Okta SAML application wired to the Stytch connection
+func oktaSamlApplication(input *config.SetupInput, stytchConf *config.StychSsoParameters) *okta.SamlApplication {
 	samlApp := okta.NewSamlApplication()
-	samlApp.Label = &oktaConf.SAMLAppLabel
+	samlApp.Label = &input.SAMLAppLabel
 	samlApp.SignOnMode = okta.PtrString("SAML_2_0")
 	samlApp.Visibility = okta.NewApplicationVisibility()
 	samlApp.Settings = okta.NewSamlApplicationSettingsWithDefaults()
@@ -24,29 +188,7 @@ func (s *OktaSAMLConnectionBootstraper) setupOktaSamlApplication(ctx context.Con
 		Recipient:   &stytchConf.AcsUrl,
 		Destination: &stytchConf.AcsUrl,
 		// Attributes you want to send to Stytch
-		AttributeStatements: []okta.SamlAttributeStatement{
-			{
-				Type:      okta.PtrString("EXPRESSION"),
-				Name:      okta.PtrString("firstName"),
-				Namespace: okta.PtrString("urn:oasis:names:tc:SAML:2.0:attrname-format:basic"),
-				Values: []string{
-					"user.firstName",
-				},
-			}, {
-				Type:      okta.PtrString("EXPRESSION"),
-				Name:      okta.PtrString("lastName"),
-				Namespace: okta.PtrString("urn:oasis:names:tc:SAML:2.0:attrname-format:basic"),
-				Values: []string{
-					"user.lastName",
-				},
-			}, {
-				FilterType:  okta.PtrString("REGEX"),
-				FilterValue: okta.PtrString(".*billing.*"),
-				Name:        okta.PtrString("groups"),
-				Namespace:   okta.PtrString("urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"),
-				Type:        okta.PtrString("GROUP"),
-			},
-		},
+		AttributeStatements: oktaAttributeStatements(input.Attributes),
 		// Default value required in the request
 		DefaultRelayState:     okta.PtrString(""),
 		IdpIssuer:             okta.PtrString("http://www.okta.com/${org.externalKey}"),
@@ -61,55 +203,38 @@ func (s *OktaSAMLConnectionBootstraper) setupOktaSamlApplication(ctx context.Con
 		SpIssuer:              nil,
 	}
 
-	oktaApp, _, err := s.OktaClient.ApplicationAPI.CreateApplication(ctx).Application(
-		okta.ListApplications200ResponseInner{
-			SamlApplication: samlApp,
-		},
-	).Execute()
-
-	if err != nil {
-		return "", err
-	}
-
-	return *oktaApp.SamlApplication.Id, nil
+	return samlApp
 }
 
-func (s *OktaSAMLConnectionBootstraper) getOktaSamlApplicationMetada(ctx context.Context, oktaAppID string) (*config.OktaSsoParameters, error) {
-	// Fetch the SAML metdata we need to configure Stych
+// FetchMetadata fetches the SAML metdata we need to configure Stych
+func (p *OktaProvider) FetchMetadata(ctx context.Context, oktaAppID string) (*config.OktaSsoParameters, error) {
 	// The okta SDK is broken it does set the Content-Type as application/xml
-	// metadata, _, err := s.OktaClient.ApplicationSSOAPI.PreviewSAMLmetadataForApplication(ctx, oktaAppID).Execute()
-
-	metadata, err := previewSAMLmetadataForApplication(ctx, s.OktaClient, oktaAppID)
-
-	if err != nil {
-		return nil, err
-	}
+	// metadata, _, err := p.Client.ApplicationSSOAPI.PreviewSAMLmetadataForApplication(ctx, oktaAppID).Execute()
 
-	// Parse the SAML metadata XML
-	SAML, err := parseXML(metadata)
+	metadata, err := previewSAMLmetadataForApplication(ctx, p.Client, oktaAppID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	result := &config.OktaSsoParameters{
-		IdpEntityID:     SAML.EntityID,
-		IdpSSOURL:       SAML.IDPSSODescriptor.SingleSignOnServices[0].Location,
-		X509Certificate: SAML.IDPSSODescriptor.KeyDescriptors[0].KeyInfo.X509Data.X509Certificate,
-	}
-
-	return result, nil
+	return ssoParametersFromMetadata(metadata, "")
 }
 
 // previewSAMLmetadataForApplicationm replace the oktaSDK that doesn't send the right header
 // We are forced to used this until they fix their SDK
+// The request goes through the HTTP client of the SDK so it shares its transport (retries, tests)
 func previewSAMLmetadataForApplication(ctx context.Context, oktaClient *okta.APIClient, appId string) (string, error) {
-	client := &http.Client{}
+	cfg := oktaClient.GetConfig()
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
 
-	url := "https://" + oktaClient.GetConfig().Host + fmt.Sprintf("/api/v1/apps/%s/sso/saml/metadata", appId)
+	url := oktaOrgURL(oktaClient) + fmt.Sprintf("/api/v1/apps/%s/sso/saml/metadata", appId)
 
 	var key string
-	if auth, ok := oktaClient.GetConfig().Context.Value(okta.ContextAPIKeys).(map[string]okta.APIKey); ok {
+	if auth, ok := cfg.Context.Value(okta.ContextAPIKeys).(map[string]okta.APIKey); ok {
 		if apiKey, ok := auth["API_Token"]; ok {
 			if apiKey.Prefix != "" {
 				key = apiKey.Prefix + " " + apiKey.Key
@@ -133,15 +258,145 @@ func previewSAMLmetadataForApplication(ctx context.Context, oktaClient *okta.API
 	// Send the request
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("Error sending request to API endpoint: %v", err)
+		return "", fmt.Errorf("error sending request to API endpoint %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read the response body
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
+		return "", fmt.Errorf("error reading response body %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching the SAML metadata of %s: %s %s", appId, resp.Status, responseBody)
 	}
 
 	return string(responseBody), nil
 }
+
+// oktaOrgURL is the URL of the Okta org the client points to, the SDK only keeps its host
+func oktaOrgURL(oktaClient *okta.APIClient) string {
+	cfg := oktaClient.GetConfig()
+
+	scheme := cfg.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	return scheme + "://" + cfg.Host
+}
+
+// CreateOIDCApplication creates a new Okta OIDC web application redirecting to Stytch
+// Its tokens are issued by the org authorization server, whose issuer is the org URL
+// ref: https://stytch.com/docs/b2b/guides/sso/okta-oidc
+func (p *OktaProvider) CreateOIDCApplication(ctx context.Context, label, redirectURL string) (*OIDCApplication, error) {
+	oktaApp, _, err := p.Client.ApplicationAPI.CreateApplication(ctx).Application(
+		okta.ListApplications200ResponseInner{
+			OpenIdConnectApplication: oktaOIDCApplication(label, redirectURL),
+		},
+	).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	app := oktaApp.OpenIdConnectApplication
+	if app == nil || app.Credentials == nil || app.Credentials.OauthClient == nil {
+		return nil, fmt.Errorf("okta returned an application without OAuth client credentials")
+	}
+
+	return &OIDCApplication{
+		ID:           app.GetId(),
+		Issuer:       oktaOrgURL(p.Client),
+		ClientID:     app.Credentials.OauthClient.GetClientId(),
+		ClientSecret: app.Credentials.OauthClient.GetClientSecret(),
+	}, nil
+}
+
+func (p *OktaProvider) OIDCApplicationPayload(label, redirectURL string) (string, any) {
+	return "Okta ApplicationAPI.CreateApplication", oktaOIDCApplication(label, redirectURL)
+}
+
+// oktaOIDCApplication builds the Okta OIDC web application using the authorization code flow with Stytch
+func oktaOIDCApplication(label, redirectURL string) *okta.OpenIdConnectApplication {
+	oidcApp := okta.NewOpenIdConnectApplication()
+	oidcApp.Label = okta.PtrString(label)
+	oidcApp.SignOnMode = okta.PtrString("OPENID_CONNECT")
+	oidcApp.Credentials = &okta.OAuthApplicationCredentials{
+		OauthClient: &okta.ApplicationCredentialsOAuthClient{
+			AutoKeyRotation:         okta.PtrBool(true),
+			TokenEndpointAuthMethod: okta.PtrString("client_secret_basic"),
+		},
+	}
+	oidcApp.Settings = &okta.OpenIdConnectApplicationSettings{
+		OauthClient: &okta.OpenIdConnectApplicationSettingsClient{
+			ApplicationType: okta.PtrString("web"),
+			ConsentMethod:   okta.PtrString("REQUIRED"),
+			GrantTypes:      []string{"authorization_code"},
+			ResponseTypes:   []string{"code"},
+			// Data coming from Stytch
+			RedirectUris: []string{redirectURL},
+		},
+	}
+
+	return oidcApp
+}
+
+// EnableProvisioning sets the SCIM connection of the Okta application then turns on the provisioning to Stytch:
+// create members, push profile updates and deactivate members unassigned in Okta
+// ref: https://developer.okta.com/docs/api/openapi/okta-management/management/tag/ApplicationConnections/
+func (p *OktaProvider) EnableProvisioning(ctx context.Context, oktaAppID, baseURL, bearerToken string) error {
+	connection := okta.NewProvisioningConnectionTokenRequest(*okta.NewProvisioningConnectionProfileToken("TOKEN", bearerToken))
+	// baseUrl is accepted by the API but missing from the SDK model
+	connection.AdditionalProperties = map[string]any{"baseUrl": baseURL}
+
+	_, _, err := p.Client.ApplicationConnectionsAPI.UpdateDefaultProvisioningConnectionForApplication(ctx, oktaAppID).
+		UpdateDefaultProvisioningConnectionForApplicationRequest(okta.ProvisioningConnectionTokenRequestAsUpdateDefaultProvisioningConnectionForApplicationRequest(connection)).
+		Activate(true).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("error setting the provisioning connection %w", err)
+	}
+
+	enabled := okta.PtrString("ENABLED")
+	_, _, err = p.Client.ApplicationFeaturesAPI.UpdateFeatureForApplication(ctx, oktaAppID, "USER_PROVISIONING").
+		UpdateFeatureForApplicationRequest(okta.CapabilitiesObjectAsUpdateFeatureForApplicationRequest(&okta.CapabilitiesObject{
+			Create: &okta.CapabilitiesCreateObject{
+				LifecycleCreate: &okta.LifecycleCreateSettingObject{Status: enabled},
+			},
+			Update: &okta.CapabilitiesUpdateObject{
+				Profile:             &okta.ProfileSettingObject{Status: enabled},
+				LifecycleDeactivate: &okta.LifecycleDeactivateSettingObject{Status: enabled},
+			},
+		})).
+		Execute()
+	if err != nil {
+		return fmt.Errorf("error enabling the provisioning features %w", err)
+	}
+
+	return nil
+}
+
+// DeleteApplication deactivates then deletes the Okta application
+// Okta refuses to delete an application that is still active
+func (p *OktaProvider) DeleteApplication(ctx context.Context, oktaAppID string) error {
+	resp, err := p.Client.ApplicationAPI.DeactivateApplication(ctx, oktaAppID).Execute()
+	if err != nil {
+		if isOktaNotFound(resp) {
+			return nil
+		}
+		return err
+	}
+
+	resp, err = p.Client.ApplicationAPI.DeleteApplication(ctx, oktaAppID).Execute()
+	if err != nil && !isOktaNotFound(resp) {
+		return err
+	}
+
+	return nil
+}
+
+// isOktaNotFound reports whether the Okta API answered with a 404
+// We treat it as success when deleting resources so destroy can be re-run
+func isOktaNotFound(resp *okta.APIResponse) bool {
+	return resp != nil && resp.Response != nil && resp.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,76 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/oktatest"
+)
+
+func TestOktaProvider_ConfiguresRotatesAndDeletesTheApplication(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeSetup(t)
+	ctx := context.Background()
+
+	// The payload sent to Okta
+	appID := provider.conf.OktaResult.ApplicationID
+	app, ok := fakeOkta.Application(appID)
+	require.True(t, ok)
+	signOn := app.Settings.SignOn
+	require.Equal(t, provider.conf.StytchResult.SsoParameters.AcsUrl, signOn.GetSsoAcsUrl())
+	require.Equal(t, "RSA_SHA256", signOn.GetSignatureAlgorithm())
+	require.True(t, signOn.GetAssertionSigned())
+	require.Len(t, signOn.AttributeStatements, 3)
+	require.Equal(t, "REGEX", signOn.AttributeStatements[2].GetFilterType())
+
+	// The metadata pushed to Stytch
+	cert, ok := fakeOkta.SigningCertificate(appID)
+	require.True(t, ok)
+	conn, ok := fakeStytch.Connection(provider.conf.StytchResult.ConnectionID)
+	require.True(t, ok)
+	require.Equal(t, "active", conn.Status)
+	require.Equal(t, cert, conn.VerificationCertificates[0].Certificate)
+
+	drifts, err := s.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	// Rotate the signing certificate then drop the old one
+	_, err = s.RotateCertificate(ctx, 2)
+	require.NoError(t, err)
+	rotated, _ := fakeOkta.SigningCertificate(appID)
+	require.NotEqual(t, cert, rotated)
+	removed, err := s.FinalizeCertificateRotation(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	conn, _ = fakeStytch.Connection(provider.conf.StytchResult.ConnectionID)
+	require.Len(t, conn.VerificationCertificates, 1)
+	require.Equal(t, rotated, conn.VerificationCertificates[0].Certificate)
+
+	require.NoError(t, s.Destroy(ctx))
+	_, ok = fakeOkta.Application(appID)
+	require.False(t, ok)
+	// Deleting a missing application is not an error so destroy can be re-run
+	require.NoError(t, s.IdP.DeleteApplication(ctx, appID))
+}
+
+func TestOktaProvider_ReportsARejectedPayload(t *testing.T) {
+	fakeOkta := oktatest.NewServer()
+	defer fakeOkta.Close()
+
+	oktaClient, err := fakeOkta.Client()
+	require.NoError(t, err)
+
+	input := &config.SetupInput{
+		OktaSetupInput: config.OktaSetupInput{SAMLAppLabel: "Acme SAML App"},
+		Attributes: []config.SAMLAttribute{
+			{Name: "groups", StytchField: "groups", GroupFilter: &config.GroupFilter{Type: "REGEX", Value: "(billing"}},
+		},
+	}
+	_, err = NewOktaProvider(oktaClient).CreateSAMLApplication(context.Background(), input, &config.StychSsoParameters{
+		AcsUrl:   "https://test.stytch.com/v1/b2b/sso/callback/saml-connection-test",
+		Audience: "https://test.stytch.com/v1/b2b/sso/callback/saml-connection-test",
+	})
+	require.ErrorContains(t, err, "400")
+}
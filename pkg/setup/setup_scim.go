@@ -0,0 +1,125 @@
+package setup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// stytchSCIMConnection is the SCIM connection object of the Stytch API
+// stytch-go v12 has no SCIM client, the requests go through the transport of the SDK
+// ref: https://stytch.com/docs/b2b/api/scim-connection-object
+type stytchSCIMConnection struct {
+	OrganizationID       string `json:"organization_id"`
+	ConnectionID         string `json:"connection_id"`
+	Status               string `json:"status"`
+	DisplayName          string `json:"display_name"`
+	BaseURL              string `json:"base_url"`
+	BearerToken          string `json:"bearer_token"`
+	BearerTokenExpiresAt string `json:"bearer_token_expires_at"`
+}
+
+type stytchSCIMConnectionResponse struct {
+	RequestID  string               `json:"request_id"`
+	Connection stytchSCIMConnection `json:"connection"`
+	StatusCode int                  `json:"status_code"`
+}
+
+// scimTokenKey is the SecretStore key of the bearer token of a SCIM connection
+func scimTokenKey(connectionID string) string {
+	return "scim/" + connectionID + "/bearer_token"
+}
+
+// createStytchSCIMConnection creates the SCIM connection and stores its bearer token, Stytch only returns it once
+func (s *OktaSAMLConnectionBootstraper) createStytchSCIMConnection(ctx context.Context, organizationID, displayName string) (*config.SCIMResult, error) {
+	if s.Secrets == nil {
+		return nil, errors.New("no secret store to keep the SCIM bearer token")
+	}
+
+	params := map[string]string{"display_name": displayName}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp stytchSCIMConnectionResponse
+	err = s.StytchClient.Organizations.C.NewRequest(ctx, http.MethodPost,
+		fmt.Sprintf("/v1/b2b/scim/%s/connection", organizationID), nil, body, &resp, map[string][]string{})
+
+	targets := map[string]string{"organization_id": organizationID}
+	if err != nil {
+		s.Journal.Record(ctx, "Stytch SCIM.CreateConnection", targets, params, nil, err)
+		return nil, err
+	}
+	s.Journal.Record(ctx, "Stytch SCIM.CreateConnection", targets, params,
+		map[string]string{"scim_connection_id": resp.Connection.ConnectionID, "base_url": resp.Connection.BaseURL}, nil)
+
+	if err := s.Secrets.SetSecret(scimTokenKey(resp.Connection.ConnectionID), resp.Connection.BearerToken); err != nil {
+		err = fmt.Errorf("error storing the bearer token of SCIM connection %s %w", resp.Connection.ConnectionID, err)
+		// The bearer token is only returned on creation, without it the connection is of no use
+		if deleteErr := s.deleteSCIMConnection(context.WithoutCancel(ctx), organizationID, resp.Connection.ConnectionID); deleteErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("error deleting SCIM connection %s %w", resp.Connection.ConnectionID, deleteErr))
+		}
+		return nil, err
+	}
+
+	return &config.SCIMResult{
+		ConnectionID:         resp.Connection.ConnectionID,
+		BaseURL:              resp.Connection.BaseURL,
+		BearerTokenExpiresAt: resp.Connection.BearerTokenExpiresAt,
+	}, nil
+}
+
+// enableProvisioning hands the SCIM base URL and bearer token over to the IdP application
+func (s *OktaSAMLConnectionBootstraper) enableProvisioning(ctx context.Context, appID string, scim *config.SCIMResult) error {
+	provisioner, ok := s.IdP.(SCIMProvisioner)
+	if !ok {
+		return fmt.Errorf("%s provisioning cannot be configured through its API, set it up by hand with base URL %s and the bearer token stored under %s",
+			s.IdP.Name(), scim.BaseURL, scimTokenKey(scim.ConnectionID))
+	}
+
+	if s.Secrets == nil {
+		return errors.New("no secret store to read the SCIM bearer token from")
+	}
+	token, err := s.Secrets.GetSecret(scimTokenKey(scim.ConnectionID))
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("no bearer token for SCIM connection %s in the secret store", scim.ConnectionID)
+	}
+
+	err = provisioner.EnableProvisioning(ctx, appID, scim.BaseURL, token)
+	s.Journal.Record(ctx, s.IdP.Name()+" EnableProvisioning", map[string]string{"application_id": appID, "scim_connection_id": scim.ConnectionID},
+		map[string]string{"base_url": scim.BaseURL, "bearer_token": token}, nil, err)
+	return err
+}
+
+// deleteStytchSCIMConnection deletes the SCIM connection then forgets its bearer token
+func (s *OktaSAMLConnectionBootstraper) deleteStytchSCIMConnection(ctx context.Context, organizationID, connectionID string) error {
+	if err := s.deleteSCIMConnection(ctx, organizationID, connectionID); err != nil {
+		return err
+	}
+
+	if s.Secrets == nil {
+		return nil
+	}
+	return s.Secrets.DeleteSecret(scimTokenKey(connectionID))
+}
+
+// deleteSCIMConnection deletes the SCIM connection from Stytch, a connection already gone is not an error
+func (s *OktaSAMLConnectionBootstraper) deleteSCIMConnection(ctx context.Context, organizationID, connectionID string) error {
+	var resp stytchSCIMConnectionResponse
+	err := s.StytchClient.Organizations.C.NewRequest(ctx, http.MethodDelete,
+		fmt.Sprintf("/v1/b2b/scim/%s/connection/%s", organizationID, connectionID), nil, nil, &resp, map[string][]string{})
+	s.Journal.Record(ctx, "Stytch SCIM.DeleteConnection", map[string]string{"organization_id": organizationID, "scim_connection_id": connectionID}, nil, nil, err)
+
+	if err != nil && !isStytchNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package setup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/oktatest"
+)
+
+func TestSCIMProvisioning_EnablesThenDestroysProvisioning(t *testing.T) {
+	s, provider, fakeStytch, fakeOkta := newFakeBootstraper(t)
+	provider.conf.SCIM = config.SCIMSetupInput{Enabled: true, ConnectionDisplayName: "Okta SCIM"}
+	secrets := s.Secrets
+
+	ctx := context.Background()
+	require.NoError(t, s.Setup(ctx))
+
+	// The bearer token is only kept in the secret store
+	result := provider.conf.SCIMResult
+	scim, ok := fakeStytch.SCIMConnection(result.ConnectionID)
+	require.True(t, ok)
+	require.Equal(t, scim.BaseURL, result.BaseURL)
+	require.Equal(t, scim.BearerTokenExpiresAt, result.BearerTokenExpiresAt)
+	token, err := secrets.GetSecret(scimTokenKey(result.ConnectionID))
+	require.NoError(t, err)
+	require.Equal(t, scim.BearerToken, token)
+
+	provisioning, ok := fakeOkta.Provisioning(provider.conf.OktaResult.ApplicationID)
+	require.True(t, ok)
+	require.Equal(t, oktatest.Provisioning{
+		BaseURL:         scim.BaseURL,
+		Token:           scim.BearerToken,
+		Status:          "ENABLED",
+		CreateUsers:     true,
+		UpdateProfiles:  true,
+		DeactivateUsers: true,
+	}, provisioning)
+
+	require.NoError(t, s.Destroy(ctx))
+	_, ok = fakeStytch.SCIMConnection(result.ConnectionID)
+	require.False(t, ok)
+	token, err = secrets.GetSecret(scimTokenKey(result.ConnectionID))
+	require.NoError(t, err)
+	require.Empty(t, token)
+	require.Empty(t, provider.conf.SCIMResult)
+}
+
+func TestSCIMProvisioning_DeletesTheConnectionWhenTheTokenCannotBeStored(t *testing.T) {
+	s, provider, fakeStytch, _ := newFakeSetup(t)
+	secrets := &failingSecrets{}
+	s.Secrets = secrets
+	provider.conf.SCIM = config.SCIMSetupInput{Enabled: true, ConnectionDisplayName: "Okta SCIM"}
+
+	err := s.Setup(context.Background())
+	require.ErrorContains(t, err, "secret store is read-only")
+
+	// The connection whose bearer token could not be kept is deleted
+	require.Len(t, secrets.keys, 1)
+	connectionID := strings.Split(secrets.keys[0], "/")[1]
+	_, ok := fakeStytch.SCIMConnection(connectionID)
+	require.False(t, ok)
+	require.Empty(t, provider.conf.SCIMResult)
+}
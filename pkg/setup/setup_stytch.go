@@ -2,60 +2,257 @@ package setup
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
 
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
 	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+	"github.com/stytchauth/stytch-go/v12/stytch/stytcherror"
 	"github.com/xNok/go-stytch-demo/pkg/config"
 )
 
 func (s *OktaSAMLConnectionBootstraper) setupStytchOrganisation(ctx context.Context, stytchConf *config.StytchSetupInput) (string, error) {
-	org, err := s.StytchClient.Organizations.Create(ctx, &organizations.CreateParams{
-		OrganizationName: stytchConf.OrganizationName,
-		OrganizationSlug: stytchConf.OrganizationSlug,
-	})
+	params := stytchOrganisationParams(stytchConf)
+	org, err := s.StytchClient.Organizations.Create(ctx, params)
 
 	if err != nil {
+		s.Journal.Record(ctx, "Stytch Organizations.Create", nil, params, nil, err)
 		return "", err
 	}
 
+	s.Journal.Record(ctx, "Stytch Organizations.Create", nil, params,
+		map[string]string{"organization_id": org.Organization.OrganizationID}, nil)
 	return org.Organization.OrganizationID, nil
 }
 
 func (s *OktaSAMLConnectionBootstraper) createStytchConnection(ctx context.Context, stytchConf *config.StytchSetupInput, organizationID string) (string, *config.StychSsoParameters, error) {
-	sso, err := s.StytchClient.SSO.SAML.CreateConnection(ctx,
-		&saml.CreateConnectionParams{
-			DisplayName:    stytchConf.ConnectionDisplayName,
-			OrganizationID: organizationID,
-		},
-	)
+	params := stytchConnectionParams(stytchConf, organizationID)
+	sso, err := s.StytchClient.SSO.SAML.CreateConnection(ctx, params)
 
+	targets := map[string]string{"organization_id": organizationID}
 	if err != nil {
+		s.Journal.Record(ctx, "Stytch SSO.SAML.CreateConnection", targets, params, nil, err)
 		return "", nil, err
 	}
 
+	s.Journal.Record(ctx, "Stytch SSO.SAML.CreateConnection", targets, params,
+		map[string]string{"connection_id": sso.Connection.ConnectionID}, nil)
+
 	return sso.Connection.ConnectionID, &config.StychSsoParameters{
 		AcsUrl:   sso.Connection.AcsURL,
 		Audience: sso.Connection.AudienceURI,
 	}, nil
 }
 
-func (s *OktaSAMLConnectionBootstraper) updateStytchConnection(ctx context.Context, organizationID, connectionID string, conf *config.OktaSsoParameters) error {
-	_, err := s.StytchClient.SSO.SAML.UpdateConnection(ctx,
-		&saml.UpdateConnectionParams{
-			ConnectionID:    connectionID,
-			OrganizationID:  organizationID,
-			IdpEntityID:     conf.IdpEntityID,
-			IdpSSOURL:       conf.IdpSSOURL,
-			X509Certificate: conf.X509Certificate,
-			AttributeMapping: map[string]any{
-				"email":      "NameID",
-				"first_name": "firstName",
-				"last_name":  "lastName",
-				// This allows us to use implicit group assignements
-				// ref: https://stytch.com/docs/b2b/guides/rbac/role-assignment
-				"groups": "groups",
-			},
-		})
+func (s *OktaSAMLConnectionBootstraper) updateStytchConnection(ctx context.Context, organizationID, connectionID string, conf *config.OktaSsoParameters, attributeMapping map[string]any) error {
+	// Catch expired or broken certificates before Stytch does, with a readable error
+	if err := validateCertificate(conf.X509Certificate, time.Now()); err != nil {
+		return err
+	}
+
+	params := stytchUpdateConnectionParams(organizationID, connectionID, conf, attributeMapping)
+	_, err := s.StytchClient.SSO.SAML.UpdateConnection(ctx, params)
+
+	s.Journal.Record(ctx, "Stytch SSO.SAML.UpdateConnection", stytchTargets(organizationID, connectionID), params, nil, err)
+	return err
+}
+
+func stytchOrganisationParams(stytchConf *config.StytchSetupInput) *organizations.CreateParams {
+	settings := &stytchConf.Settings
+	return &organizations.CreateParams{
+		OrganizationName:     stytchConf.OrganizationName,
+		OrganizationSlug:     stytchConf.OrganizationSlug,
+		TrustedMetadata:      sessionDurationMetadata(nil, settings.SessionDurationMinutes),
+		SSOJITProvisioning:   settings.SSOJITProvisioning,
+		EmailAllowedDomains:  settings.EmailAllowedDomains,
+		EmailJITProvisioning: settings.EmailJITProvisioning,
+		AuthMethods:          settings.AuthMethods,
+		AllowedAuthMethods:   settings.AllowedAuthMethods,
+		MFAPolicy:            settings.MFAPolicy,
+		MFAMethods:           settings.MFAMethods,
+		AllowedMFAMethods:    settings.AllowedMFAMethods,
+	}
+}
+
+// reconcileStytchOrganisation updates the organization settings that differ from the input
+// Nothing is sent when the organization already matches
+func (s *OktaSAMLConnectionBootstraper) reconcileStytchOrganisation(ctx context.Context, organizationID, connectionID string, settings *config.OrganizationSettings) error {
+	resp, err := s.StytchClient.Organizations.Get(ctx, &organizations.GetParams{
+		OrganizationID: organizationID,
+	})
+	if err != nil {
+		return err
+	}
 
+	params := stytchOrganisationSettingsDrift(&resp.Organization, connectionID, settings)
+	if params == nil {
+		return nil
+	}
+
+	_, err = s.StytchClient.Organizations.Update(ctx, params)
+	s.Journal.Record(ctx, "Stytch Organizations.Update", map[string]string{"organization_id": organizationID}, params, nil, err)
 	return err
 }
+
+// stytchOrganisationSettingsDrift returns an update with only the settings differing from the organization, or nil
+// With RESTRICTED SSO JIT provisioning the connection created by setup is added to the allowed connections
+func stytchOrganisationSettingsDrift(org *organizations.Organization, connectionID string, settings *config.OrganizationSettings) *organizations.UpdateParams {
+	params := &organizations.UpdateParams{OrganizationID: org.OrganizationID}
+	drifted := false
+	setString := func(dst *string, want, actual string) {
+		if want != "" && want != actual {
+			*dst = want
+			drifted = true
+		}
+	}
+	setSlice := func(dst *[]string, want, actual []string) {
+		if len(want) > 0 && !sameElements(want, actual) {
+			*dst = want
+			drifted = true
+		}
+	}
+
+	setSlice(&params.EmailAllowedDomains, settings.EmailAllowedDomains, org.EmailAllowedDomains)
+	setString(&params.EmailJITProvisioning, settings.EmailJITProvisioning, org.EmailJITProvisioning)
+	setString(&params.AuthMethods, settings.AuthMethods, org.AuthMethods)
+	setSlice(&params.AllowedAuthMethods, settings.AllowedAuthMethods, org.AllowedAuthMethods)
+	setString(&params.SSOJITProvisioning, settings.SSOJITProvisioning, org.SSOJITProvisioning)
+	setString(&params.MFAPolicy, settings.MFAPolicy, org.MFAPolicy)
+	setString(&params.MFAMethods, settings.MFAMethods, org.MFAMethods)
+	setSlice(&params.AllowedMFAMethods, settings.AllowedMFAMethods, org.AllowedMFAMethods)
+
+	if settings.SSOJITProvisioning == "RESTRICTED" && connectionID != "" && !slices.Contains(org.SSOJITProvisioningAllowedConnections, connectionID) {
+		params.SSOJITProvisioningAllowedConnections = append(slices.Clone(org.SSOJITProvisioningAllowedConnections), connectionID)
+		drifted = true
+	}
+
+	// JSON numbers are decoded as float64
+	if want := settings.SessionDurationMinutes; want != 0 {
+		if actual, _ := org.TrustedMetadata[config.SessionDurationKey].(float64); int32(actual) != want {
+			params.TrustedMetadata = sessionDurationMetadata(org.TrustedMetadata, want)
+			drifted = true
+		}
+	}
+
+	if !drifted {
+		return nil
+	}
+	return params
+}
+
+// sessionDurationMetadata returns a copy of the trusted metadata with the session duration, nil when there is none
+func sessionDurationMetadata(metadata map[string]any, minutes int32) map[string]any {
+	if minutes == 0 {
+		return nil
+	}
+	updated := maps.Clone(metadata)
+	if updated == nil {
+		updated = map[string]any{}
+	}
+	updated[config.SessionDurationKey] = minutes
+	return updated
+}
+
+// sameElements compares two lists ignoring the order
+func sameElements(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+func stytchConnectionParams(stytchConf *config.StytchSetupInput, organizationID string) *saml.CreateConnectionParams {
+	return &saml.CreateConnectionParams{
+		DisplayName:    stytchConf.ConnectionDisplayName,
+		OrganizationID: organizationID,
+	}
+}
+
+func stytchUpdateConnectionParams(organizationID, connectionID string, conf *config.OktaSsoParameters, attributeMapping map[string]any) *saml.UpdateConnectionParams {
+	return &saml.UpdateConnectionParams{
+		ConnectionID:     connectionID,
+		OrganizationID:   organizationID,
+		IdpEntityID:      conf.IdpEntityID,
+		IdpSSOURL:        conf.IdpSSOURL,
+		X509Certificate:  conf.X509Certificate,
+		AttributeMapping: attributeMapping,
+	}
+}
+
+func (s *OktaSAMLConnectionBootstraper) deleteStytchConnection(ctx context.Context, organizationID, connectionID string) error {
+	_, err := s.StytchClient.SSO.DeleteConnection(ctx, &sso.DeleteConnectionParams{
+		OrganizationID: organizationID,
+		ConnectionID:   connectionID,
+	})
+	s.Journal.Record(ctx, "Stytch SSO.DeleteConnection", stytchTargets(organizationID, connectionID), nil, nil, err)
+
+	if err != nil && !isStytchNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *OktaSAMLConnectionBootstraper) deleteStytchOrganisation(ctx context.Context, organizationID string) error {
+	_, err := s.StytchClient.Organizations.Delete(ctx, &organizations.DeleteParams{
+		OrganizationID: organizationID,
+	})
+	s.Journal.Record(ctx, "Stytch Organizations.Delete", map[string]string{"organization_id": organizationID}, nil, nil, err)
+
+	if err != nil && !isStytchNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// isStytchNotFound reports whether the Stytch API answered with a 404
+// We treat it as success when deleting resources so destroy can be re-run
+func isStytchNotFound(err error) bool {
+	var stytchErr stytcherror.Error
+	return errors.As(err, &stytchErr) && stytchErr.StatusCode == http.StatusNotFound
+}
+
+// getStytchConnection looks up the SAML connection among the organization connections
+func (s *OktaSAMLConnectionBootstraper) getStytchConnection(ctx context.Context, organizationID, connectionID string) (*sso.SAMLConnection, error) {
+	resp, err := s.StytchClient.SSO.GetConnections(ctx, &sso.GetConnectionsParams{
+		OrganizationID: organizationID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range resp.SAMLConnections {
+		if resp.SAMLConnections[i].ConnectionID == connectionID {
+			return &resp.SAMLConnections[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("SAML connection %s not found in organization %s", connectionID, organizationID)
+}
+
+func (s *OktaSAMLConnectionBootstraper) deleteStytchVerificationCertificate(ctx context.Context, organizationID, connectionID, certificateID string) error {
+	_, err := s.StytchClient.SSO.SAML.DeleteVerificationCertificate(ctx, &saml.DeleteVerificationCertificateParams{
+		OrganizationID: organizationID,
+		ConnectionID:   connectionID,
+		CertificateID:  certificateID,
+	})
+
+	targets := stytchTargets(organizationID, connectionID)
+	targets["certificate_id"] = certificateID
+	s.Journal.Record(ctx, "Stytch SSO.SAML.DeleteVerificationCertificate", targets, nil, nil, err)
+	return err
+}
+
+// stytchTargets are the journal targets of a call on a SAML connection
+func stytchTargets(organizationID, connectionID string) map[string]string {
+	return map[string]string{
+		"organization_id": organizationID,
+		"connection_id":   connectionID,
+	}
+}
@@ -0,0 +1,103 @@
+package setup
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/stytchtest"
+)
+
+func TestStytchOrganisationSettingsDrift(t *testing.T) {
+	org := &organizations.Organization{
+		OrganizationID:      "organization-test",
+		EmailAllowedDomains: []string{"acme.com", "acme.io"},
+		AuthMethods:         "ALL_ALLOWED",
+		SSOJITProvisioning:  "ALL_ALLOWED",
+		MFAPolicy:           "OPTIONAL",
+		TrustedMetadata:     map[string]any{"plan": "enterprise", config.SessionDurationKey: float64(60)},
+	}
+
+	tests := []struct {
+		name     string
+		settings config.OrganizationSettings
+		want     *organizations.UpdateParams
+	}{
+		{
+			name: "no settings",
+		},
+		{
+			name:     "in sync",
+			settings: config.OrganizationSettings{EmailAllowedDomains: []string{"acme.io", "acme.com"}, MFAPolicy: "OPTIONAL", SessionDurationMinutes: 60},
+		},
+		{
+			name:     "drifted fields only",
+			settings: config.OrganizationSettings{EmailAllowedDomains: []string{"acme.com", "acme.io"}, MFAPolicy: "REQUIRED_FOR_ALL", AuthMethods: "RESTRICTED", AllowedAuthMethods: []string{"sso"}},
+			want:     &organizations.UpdateParams{OrganizationID: "organization-test", MFAPolicy: "REQUIRED_FOR_ALL", AuthMethods: "RESTRICTED", AllowedAuthMethods: []string{"sso"}},
+		},
+		{
+			name:     "restricted SSO JIT provisioning",
+			settings: config.OrganizationSettings{SSOJITProvisioning: "RESTRICTED"},
+			want: &organizations.UpdateParams{OrganizationID: "organization-test", SSOJITProvisioning: "RESTRICTED",
+				SSOJITProvisioningAllowedConnections: []string{"saml-connection-test"}},
+		},
+		{
+			name:     "session duration keeps the other metadata",
+			settings: config.OrganizationSettings{SessionDurationMinutes: 480},
+			want: &organizations.UpdateParams{OrganizationID: "organization-test",
+				TrustedMetadata: map[string]any{"plan": "enterprise", config.SessionDurationKey: int32(480)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stytchOrganisationSettingsDrift(org, "saml-connection-test", &tt.settings)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReconcileOrganisationSettings_RestoresDriftedSettings(t *testing.T) {
+	fake := stytchtest.NewServer()
+	defer fake.Close()
+
+	client, err := fake.Client()
+	require.NoError(t, err)
+
+	settings := config.OrganizationSettings{
+		EmailAllowedDomains:    []string{"acme.com"},
+		EmailJITProvisioning:   "RESTRICTED",
+		SSOJITProvisioning:     "RESTRICTED",
+		MFAPolicy:              "REQUIRED_FOR_ALL",
+		SessionDurationMinutes: 480,
+	}
+	provider := &memoryConfig{conf: &config.SetupConfig{
+		SetupInput: &config.SetupInput{
+			StytchSetupInput: config.StytchSetupInput{OrganizationName: "Acme Corp", OrganizationSlug: "acme", ConnectionDisplayName: "Okta", Settings: settings},
+		},
+		SetupResult: &config.SetupResult{},
+	}}
+	s := NewSAMLConnectionBootstraper(client, NewMetadataProvider("testdata/idp_metadata.xml", io.Discard))
+	s.ConfProvider = provider
+
+	ctx := context.Background()
+	require.NoError(t, s.Setup(ctx))
+
+	// Applied on create, the connection is allowed to JIT provision once it exists
+	organizationID := provider.conf.OrganizationID
+	org, _ := fake.Organization(organizationID)
+	require.Equal(t, []string{"acme.com"}, org.EmailAllowedDomains)
+	require.Equal(t, "RESTRICTED", org.EmailJITProvisioning)
+	require.Equal(t, "REQUIRED_FOR_ALL", org.MFAPolicy)
+	require.Equal(t, float64(480), org.TrustedMetadata[config.SessionDurationKey])
+	require.Equal(t, []string{provider.conf.ConnectionID}, org.SSOJITProvisioningAllowedConnections)
+
+	// Changed in the Stytch dashboard, the next run puts it back
+	_, err = client.Organizations.Update(ctx, &organizations.UpdateParams{OrganizationID: organizationID, MFAPolicy: "OPTIONAL"})
+	require.NoError(t, err)
+	require.NoError(t, s.Setup(ctx))
+	org, _ = fake.Organization(organizationID)
+	require.Equal(t, "REQUIRED_FOR_ALL", org.MFAPolicy)
+}
@@ -0,0 +1,110 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/oktatest"
+	"github.com/xNok/go-stytch-demo/pkg/stytchtest"
+)
+
+// newFakeBootstraper sets up Acme Corp against the fake Stytch and Okta servers, without running the setup
+// Tests change the input, the protocol or the secret store before calling Setup
+func newFakeBootstraper(t *testing.T) (*OktaSAMLConnectionBootstraper, *memoryConfig, *stytchtest.Server, *oktatest.Server) {
+	fakeStytch := stytchtest.NewServer()
+	t.Cleanup(fakeStytch.Close)
+	fakeOkta := oktatest.NewServer()
+	t.Cleanup(fakeOkta.Close)
+
+	stytchClient, err := fakeStytch.Client()
+	require.NoError(t, err)
+	oktaClient, err := fakeOkta.Client()
+	require.NoError(t, err)
+
+	provider := &memoryConfig{conf: &config.SetupConfig{
+		SetupInput:  newAcmeInput(),
+		SetupResult: &config.SetupResult{},
+	}}
+	s := NewOktaSAMLConnectionBootstraper(stytchClient, oktaClient)
+	s.ConfProvider = provider
+	s.Secrets = config.NewFileSecretStore(filepath.Join(t.TempDir(), "setup.secrets.json"))
+
+	return s, provider, fakeStytch, fakeOkta
+}
+
+// newFakeSetup completes the setup of Acme Corp against the fake Stytch and Okta servers
+func newFakeSetup(t *testing.T) (*OktaSAMLConnectionBootstraper, *memoryConfig, *stytchtest.Server, *oktatest.Server) {
+	s, provider, fakeStytch, fakeOkta := newFakeBootstraper(t)
+	require.NoError(t, s.Setup(context.Background()))
+	return s, provider, fakeStytch, fakeOkta
+}
+
+func newAcmeInput() *config.SetupInput {
+	return &config.SetupInput{
+		StytchSetupInput: config.StytchSetupInput{OrganizationName: "Acme Corp", OrganizationSlug: "acme", ConnectionDisplayName: "Okta"},
+		OktaSetupInput:   config.OktaSetupInput{SAMLAppLabel: "Acme SAML App"},
+		Attributes:       config.DefaultSAMLAttributes(),
+		OIDC:             config.OIDCSetupInput{ConnectionDisplayName: "Okta OIDC", AppLabel: "Acme OIDC App"},
+	}
+}
+
+// failingSecrets is a secret store that cannot be written, it records the keys it was asked to set
+type failingSecrets struct {
+	keys []string
+}
+
+func (f *failingSecrets) GetSecret(key string) (string, error) { return "", nil }
+func (f *failingSecrets) DeleteSecret(key string) error        { return nil }
+func (f *failingSecrets) SetSecret(key, value string) error {
+	f.keys = append(f.keys, key)
+	return errors.New("secret store is read-only")
+}
+
+func TestSetup_ConfiguresTheConnectionFromMetadata(t *testing.T) {
+	fake := stytchtest.NewServer()
+	defer fake.Close()
+
+	client, err := fake.Client()
+	require.NoError(t, err)
+
+	provider := &memoryConfig{conf: &config.SetupConfig{
+		SetupInput: &config.SetupInput{
+			StytchSetupInput: config.StytchSetupInput{
+				OrganizationName:      "Acme Corp",
+				OrganizationSlug:      "acme",
+				ConnectionDisplayName: "Okta",
+			},
+		},
+		SetupResult: &config.SetupResult{},
+	}}
+	s := NewSAMLConnectionBootstraper(client, NewMetadataProvider("testdata/idp_metadata.xml", io.Discard))
+	s.ConfProvider = provider
+
+	ctx := context.Background()
+	require.NoError(t, s.Setup(ctx))
+
+	result := provider.conf.StytchResult
+	org, ok := fake.Organization(result.OrganizationID)
+	require.True(t, ok)
+	require.Equal(t, "acme", org.OrganizationSlug)
+
+	conn, ok := fake.Connection(result.ConnectionID)
+	require.True(t, ok)
+	require.Equal(t, "active", conn.Status)
+	require.Equal(t, "http://www.okta.com/exk0000000000000000", conn.IdpEntityID)
+	require.Len(t, conn.VerificationCertificates, 1)
+	require.Equal(t, conn.AcsURL, result.SsoParameters.AcsUrl)
+
+	_, err = fake.SSOLogin(result.ConnectionID, "jane@acme.com")
+	require.NoError(t, err)
+
+	// Every step is done, a second run must not call Stytch again
+	saves := provider.saves
+	require.NoError(t, s.Setup(ctx))
+	require.Equal(t, saves, provider.saves)
+}
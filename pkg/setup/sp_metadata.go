@@ -0,0 +1,97 @@
+package setup
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// SAML namespaces and formats used in the SP metadata
+const (
+	MetadataNamespace = "urn:oasis:names:tc:SAML:2.0:metadata"
+	ProtocolNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	NameIDFormatEmail       = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatUnspecified = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	NameIDFormatPersistent  = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+
+	attrNameFormatURI = "urn:oasis:names:tc:SAML:2.0:attrname-format:uri"
+)
+
+// SPMetadata builds the SAML metadata of the Stytch connection, for IdPs configured by hand
+// It needs the create-connection step to be done
+func (s *OktaSAMLConnectionBootstraper) SPMetadata(nameIDFormat string) ([]byte, error) {
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	if conf.StytchResult.ConnectionID == "" || conf.StytchResult.SsoParameters == nil {
+		return nil, fmt.Errorf("no Stytch SAML connection yet, run setup up to the %s step first", StepCreateConnection)
+	}
+
+	return buildSPMetadata(conf.StytchResult.SsoParameters, nameIDFormat, conf.Attributes)
+}
+
+// buildSPMetadata renders an EntityDescriptor with a SPSSODescriptor
+// The Audience is the entity ID of Stytch, the attributes are requested under the names of the attribute mapping
+func buildSPMetadata(sp *config.StychSsoParameters, nameIDFormat string, attributes []config.SAMLAttribute) ([]byte, error) {
+	if sp.AcsUrl == "" || sp.Audience == "" {
+		return nil, fmt.Errorf("the Stytch connection has no ACS URL or Audience")
+	}
+
+	service := AttributeConsumingService{
+		Index:        0,
+		ServiceNames: []LocalizedName{{Lang: "en", Value: "Stytch"}},
+	}
+	for _, attr := range attributes {
+		// The subject is requested with NameIDFormat, not as an attribute
+		if attr.Name == config.NameID {
+			continue
+		}
+		service.RequestedAttributes = append(service.RequestedAttributes, RequestedAttribute{
+			Name:         attr.Name,
+			NameFormat:   attrNameFormat(attr),
+			FriendlyName: attr.StytchField,
+		})
+	}
+
+	descriptor := EntityDescriptor{
+		Xmlns:    MetadataNamespace,
+		EntityID: sp.Audience,
+		SPSSODescriptor: &SPSSODescriptor{
+			AuthnRequestsSigned:        "false",
+			WantAssertionsSigned:       "true",
+			ProtocolSupportEnumeration: ProtocolNamespace,
+			NameIDFormats:              []string{nameIDFormat},
+			AssertionConsumerServices: []AssertionConsumerService{
+				{Binding: BindingHTTPPost, Location: sp.AcsUrl, Index: 0, IsDefault: true},
+			},
+		},
+	}
+	if len(service.RequestedAttributes) > 0 {
+		descriptor.SPSSODescriptor.AttributeConsumingServices = []AttributeConsumingService{service}
+	}
+
+	out, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error rendering SP metadata %w", err)
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+// attrNameFormat uses the namespace of the attribute, URI names (Entra claims) default to the uri format
+func attrNameFormat(attr config.SAMLAttribute) string {
+	switch {
+	case attr.Namespace != "":
+		return attr.Namespace
+	case strings.Contains(attr.Name, "://") || strings.HasPrefix(attr.Name, "urn:"):
+		return attrNameFormatURI
+	case attr.GroupFilter != nil:
+		return config.NamespaceUnspecified
+	default:
+		return config.NamespaceBasic
+	}
+}
@@ -0,0 +1,72 @@
+package setup
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+func TestBuildSPMetadata(t *testing.T) {
+	sp := &config.StychSsoParameters{
+		AcsUrl:   "https://test.stytch.com/v1/b2b/sso/callback/saml-connection-test",
+		Audience: "https://test.stytch.com/v1/b2b/sso/callback/saml-connection-test",
+	}
+
+	tests := []struct {
+		name       string
+		sp         *config.StychSsoParameters
+		attributes []config.SAMLAttribute
+		want       []RequestedAttribute
+		wantErr    string
+	}{
+		{
+			name:       "default attributes",
+			sp:         sp,
+			attributes: config.DefaultSAMLAttributes(),
+			want: []RequestedAttribute{
+				{Name: "firstName", NameFormat: config.NamespaceBasic, FriendlyName: "first_name"},
+				{Name: "lastName", NameFormat: config.NamespaceBasic, FriendlyName: "last_name"},
+				{Name: "groups", NameFormat: config.NamespaceUnspecified, FriendlyName: "groups"},
+			},
+		},
+		{
+			name: "claim URIs",
+			sp:   sp,
+			attributes: []config.SAMLAttribute{
+				{Name: config.NameID, StytchField: "email"},
+				{Name: "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname", StytchField: "first_name"},
+			},
+			want: []RequestedAttribute{
+				{Name: "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname", NameFormat: attrNameFormatURI, FriendlyName: "first_name"},
+			},
+		},
+		{
+			name:    "connection not created",
+			sp:      &config.StychSsoParameters{},
+			wantErr: "no ACS URL or Audience",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := buildSPMetadata(tt.sp, NameIDFormatEmail, tt.attributes)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var got EntityDescriptor
+			require.NoError(t, xml.Unmarshal(out, &got))
+			require.Equal(t, MetadataNamespace, got.XMLName.Space)
+			require.Equal(t, sp.Audience, got.EntityID)
+			require.Nil(t, got.IDPSSODescriptor)
+			require.Equal(t, []string{NameIDFormatEmail}, got.SPSSODescriptor.NameIDFormats)
+			require.Equal(t, sp.AcsUrl, got.SPSSODescriptor.AssertionConsumerServices[0].Location)
+			require.Equal(t, BindingHTTPPost, got.SPSSODescriptor.AssertionConsumerServices[0].Binding)
+			require.Equal(t, tt.want, got.SPSSODescriptor.AttributeConsumingServices[0].RequestedAttributes)
+			require.Equal(t, "en", got.SPSSODescriptor.AttributeConsumingServices[0].ServiceNames[0].Lang)
+		})
+	}
+}
@@ -0,0 +1,453 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+	"github.com/xNok/go-stytch-demo/pkg/journal"
+)
+
+// Names of the setup steps, in the order Setup runs them
+const (
+	StepCreateOrganization = "create-organization"
+	StepCreateConnection   = "create-connection"
+	StepCreateApplication  = "create-application"
+	StepFetchMetadata      = "fetch-metadata"
+	StepUpdateConnection   = "update-connection"
+	// The OIDC steps replace the SAML ones above with the oidc protocol
+	StepCreateOIDCConnection  = "create-oidc-connection"
+	StepCreateOIDCApplication = "create-oidc-application"
+	StepUpdateOIDCConnection  = "update-oidc-connection"
+	StepCreateSCIM            = "create-scim-connection"
+	StepEnableProvisioning    = "enable-provisioning"
+	StepUpdateOrganization    = "update-organization"
+)
+
+// StepNames lists the setup steps in order
+var StepNames = []string{
+	StepCreateOrganization,
+	StepCreateConnection,
+	StepCreateApplication,
+	StepFetchMetadata,
+	StepUpdateConnection,
+	StepCreateOIDCConnection,
+	StepCreateOIDCApplication,
+	StepUpdateOIDCConnection,
+	StepCreateSCIM,
+	StepEnableProvisioning,
+	StepUpdateOrganization,
+}
+
+// StepError is returned by Setup when a step fails
+type StepError struct {
+	Step string
+	Err  error
+	// Retryable is true when the failure is transient (rate limit, outage...) and running setup again may succeed
+	Retryable bool
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("setup step %s failed: %v", e.Step, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// step is one idempotent operation of Setup
+type step struct {
+	name string
+	// done tells whether a previous run completed the step, it is then skipped unless selected explicitly
+	done func(conf *config.SetupConfig) bool
+	// enabled is set on optional steps, a disabled step never runs and does not block the following ones
+	enabled func(conf *config.SetupConfig) bool
+	run     func(ctx context.Context, conf *config.SetupConfig) error
+}
+
+func (s *OktaSAMLConnectionBootstraper) steps() []step {
+	return []step{
+		{
+			// Step 0. Create a New Organisation
+			name: StepCreateOrganization,
+			done: func(conf *config.SetupConfig) bool { return conf.StytchResult.OrganizationID != "" },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retryCreate(ctx, StepCreateOrganization, func(ctx context.Context) (err error) {
+					conf.StytchResult.OrganizationID, err = s.setupStytchOrganisation(ctx, &conf.StytchSetupInput)
+					return err
+				}, func(ctx context.Context) (_ bool, err error) {
+					conf.StytchResult.OrganizationID, err = s.findStytchOrganisation(ctx, conf.StytchSetupInput.OrganizationSlug)
+					return conf.StytchResult.OrganizationID != "", err
+				})
+				if isStytchDuplicateSlug(err) {
+					// The SetupResult was lost, pick up the resources of the previous run instead
+					log.Printf("organization slug %s already used, adopting the existing resources", conf.StytchSetupInput.OrganizationSlug)
+					if adoptErr := s.adopt(ctx, conf); adoptErr != nil {
+						return fmt.Errorf("error adopting Organization %w", adoptErr)
+					}
+					if conf.StytchResult.OrganizationID == "" {
+						return fmt.Errorf("error creating Organizations %w", err)
+					}
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("error creating Organizations %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 1. Create a new SAML connection
+			name:    StepCreateConnection,
+			enabled: s.samlEnabled,
+			done:    func(conf *config.SetupConfig) bool { return conf.StytchResult.ConnectionID != "" },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retryCreate(ctx, StepCreateConnection, func(ctx context.Context) (err error) {
+					conf.StytchResult.ConnectionID, conf.StytchResult.SsoParameters, err = s.createStytchConnection(ctx,
+						&conf.StytchSetupInput, conf.StytchResult.OrganizationID)
+					return err
+				}, func(ctx context.Context) (bool, error) {
+					connection, err := s.findStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchSetupInput.ConnectionDisplayName)
+					if err != nil || connection == nil {
+						return false, err
+					}
+					conf.StytchResult.ConnectionID = connection.ConnectionID
+					conf.StytchResult.SsoParameters = &config.StychSsoParameters{AcsUrl: connection.AcsURL, Audience: connection.AudienceURI}
+					return true, nil
+				})
+				if err != nil {
+					return fmt.Errorf("error creating SSO SAML Connection %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 2: Create and configure a new IdP Application
+			// The metadata provider has no application, its completion is only known from the step status
+			name:    StepCreateApplication,
+			enabled: s.samlEnabled,
+			done: func(conf *config.SetupConfig) bool {
+				return conf.OktaResult.ApplicationID != "" || conf.StepDone(StepCreateApplication)
+			},
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				// The application is not looked up, it may be left half configured by the failed call
+				err := s.retryCreate(ctx, StepCreateApplication, func(ctx context.Context) (err error) {
+					conf.OktaResult.ApplicationID, err = s.IdP.CreateSAMLApplication(ctx, conf.SetupInput, conf.StytchResult.SsoParameters)
+
+					api, payload := s.IdP.SAMLApplicationPayload(conf.SetupInput, conf.StytchResult.SsoParameters)
+					var result map[string]string
+					if err == nil {
+						result = map[string]string{"application_id": conf.OktaResult.ApplicationID}
+					}
+					s.Journal.Record(ctx, api, stytchTargets(conf.OrganizationID, conf.ConnectionID), payload, result, err)
+					return err
+				}, nil)
+				if err != nil {
+					return fmt.Errorf("error creating %s Application %w", s.IdP.Name(), err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 3: Fetch IdP SAML Metdata
+			name:    StepFetchMetadata,
+			enabled: s.samlEnabled,
+			done: func(conf *config.SetupConfig) bool {
+				return conf.StepDone(StepFetchMetadata) && conf.OktaResult.SsoParameters != nil && conf.OktaResult.SsoParameters.X509Certificate != ""
+			},
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retry(ctx, StepFetchMetadata, func(ctx context.Context) (err error) {
+					conf.OktaResult.SsoParameters, err = s.IdP.FetchMetadata(ctx, conf.ApplicationID)
+					return err
+				})
+				if err != nil {
+					return fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 4: Update Stych SSO Connactions
+			name:    StepUpdateConnection,
+			enabled: s.samlEnabled,
+			done:    func(conf *config.SetupConfig) bool { return conf.StepDone(StepUpdateConnection) },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retry(ctx, StepUpdateConnection, func(ctx context.Context) error {
+					return s.updateStytchConnection(ctx, conf.OrganizationID, conf.ConnectionID, conf.OktaResult.SsoParameters, s.IdP.AttributeMapping(conf.SetupInput))
+				})
+				if err != nil {
+					return fmt.Errorf("error updating SSO SAML Connection %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 5: Create a new OIDC connection, only with the oidc protocol
+			name:    StepCreateOIDCConnection,
+			enabled: s.oidcEnabled,
+			done:    func(conf *config.SetupConfig) bool { return conf.OIDCResult.ConnectionID != "" },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retryCreate(ctx, StepCreateOIDCConnection, func(ctx context.Context) error {
+					result, err := s.createStytchOIDCConnection(ctx, conf.OrganizationID, conf.OIDC.ConnectionDisplayName)
+					if err == nil {
+						conf.OIDCResult = *result
+					}
+					return err
+				}, func(ctx context.Context) (bool, error) {
+					connection, err := s.findStytchOIDCConnection(ctx, conf.OrganizationID, conf.OIDC.ConnectionDisplayName)
+					if err != nil || connection == nil {
+						return false, err
+					}
+					conf.OIDCResult = config.OIDCResult{ConnectionID: connection.ConnectionID, RedirectURL: connection.RedirectURL}
+					return true, nil
+				})
+				if err != nil {
+					return fmt.Errorf("error creating SSO OIDC Connection %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 6: Create an IdP OIDC web application redirecting to the Stytch callback
+			name:    StepCreateOIDCApplication,
+			enabled: s.oidcEnabled,
+			done:    func(conf *config.SetupConfig) bool { return conf.OIDCResult.ApplicationID != "" },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				// The client secret is only returned on creation, an application found afterwards would be of no use
+				err := s.retryCreate(ctx, StepCreateOIDCApplication, func(ctx context.Context) error {
+					return s.createOIDCApplication(ctx, conf.OrganizationID, conf.OIDC.AppLabel, &conf.OIDCResult)
+				}, nil)
+				if err != nil {
+					return fmt.Errorf("error creating %s OIDC Application %w", s.IdP.Name(), err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 7: Update the Stytch OIDC connection with the issuer and client credentials
+			name:    StepUpdateOIDCConnection,
+			enabled: s.oidcEnabled,
+			done:    func(conf *config.SetupConfig) bool { return conf.StepDone(StepUpdateOIDCConnection) },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retry(ctx, StepUpdateOIDCConnection, func(ctx context.Context) error {
+					return s.updateStytchOIDCConnection(ctx, conf.OrganizationID, &conf.OIDCResult)
+				})
+				if err != nil {
+					return fmt.Errorf("error updating SSO OIDC Connection %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 8: Create the SCIM connection, only when SCIM is enabled
+			name:    StepCreateSCIM,
+			enabled: func(conf *config.SetupConfig) bool { return conf.SCIM.Enabled },
+			done:    func(conf *config.SetupConfig) bool { return conf.SCIMResult.ConnectionID != "" },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				// Same for the bearer token of the SCIM connection
+				err := s.retryCreate(ctx, StepCreateSCIM, func(ctx context.Context) error {
+					scim, err := s.createStytchSCIMConnection(ctx, conf.OrganizationID, conf.SCIM.ConnectionDisplayName)
+					if err == nil {
+						conf.SCIMResult = *scim
+					}
+					return err
+				}, nil)
+				if err != nil {
+					return fmt.Errorf("error creating SCIM Connection %w", err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 9: Provision members from the IdP application through the SCIM connection
+			name:    StepEnableProvisioning,
+			enabled: func(conf *config.SetupConfig) bool { return conf.SCIM.Enabled },
+			done:    func(conf *config.SetupConfig) bool { return conf.StepDone(StepEnableProvisioning) },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retry(ctx, StepEnableProvisioning, func(ctx context.Context) error {
+					return s.enableProvisioning(ctx, s.applicationID(conf), &conf.SCIMResult)
+				})
+				if err != nil {
+					return fmt.Errorf("error enabling %s provisioning %w", s.IdP.Name(), err)
+				}
+				return nil
+			},
+		},
+		{
+			// Step 10: Reconcile the Organization settings, they may have changed in the input or in Stytch
+			// It runs every time, without settings there is nothing to reconcile
+			name:    StepUpdateOrganization,
+			enabled: func(conf *config.SetupConfig) bool { return !conf.StytchSetupInput.Settings.IsZero() },
+			done:    func(conf *config.SetupConfig) bool { return false },
+			run: func(ctx context.Context, conf *config.SetupConfig) error {
+				err := s.retry(ctx, StepUpdateOrganization, func(ctx context.Context) error {
+					return s.reconcileStytchOrganisation(ctx, conf.OrganizationID, s.connectionID(conf), &conf.StytchSetupInput.Settings)
+				})
+				if err != nil {
+					return fmt.Errorf("error updating Organization settings %w", err)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// selectSteps returns which steps will run, by name
+// By default the steps already done are skipped, FromStep forces a step and the following ones,
+// OnlyStep forces a single step. In both cases the previous steps must be done.
+// A step that runs resets the status of the following ones, which then run as well.
+func (s *OktaSAMLConnectionBootstraper) selectSteps(steps []step, conf *config.SetupConfig) (map[string]bool, error) {
+	if s.FromStep != "" && s.OnlyStep != "" {
+		return nil, errors.New("from-step and only-step are mutually exclusive")
+	}
+
+	forced := s.FromStep
+	if s.OnlyStep != "" {
+		forced = s.OnlyStep
+	}
+	if s.Protocol != "" && s.Protocol != ProtocolSAML && s.Protocol != ProtocolOIDC {
+		return nil, fmt.Errorf("unknown protocol %q, expected %s or %s", s.Protocol, ProtocolSAML, ProtocolOIDC)
+	}
+	if forced != "" && !isStep(forced) {
+		return nil, fmt.Errorf("unknown step %q, expected one of [%s]", forced, strings.Join(StepNames, ", "))
+	}
+
+	selected := map[string]bool{}
+	reached := forced == ""
+	for _, step := range steps {
+		if step.name == forced && !step.isEnabled(conf) {
+			return nil, fmt.Errorf("step %s is disabled by the configuration or the protocol", forced)
+		}
+	}
+
+	// The statuses as they will be when each step is reached
+	result := *conf.SetupResult
+	result.Steps = maps.Clone(conf.Steps)
+	planned := &config.SetupConfig{SetupInput: conf.SetupInput, SetupResult: &result}
+
+	for i, step := range steps {
+		if !step.isEnabled(conf) {
+			continue
+		}
+
+		switch {
+		case step.name == forced:
+			reached = true
+			selected[step.name] = true
+		case !reached:
+			if !step.done(conf) {
+				return nil, fmt.Errorf("step %s requires step %s to be done first", forced, step.name)
+			}
+		case s.OnlyStep != "":
+			// every other step is skipped
+		case s.FromStep != "":
+			selected[step.name] = true
+		default:
+			selected[step.name] = !step.done(planned)
+			if selected[step.name] && i+1 < len(steps) {
+				resetSteps(planned, steps[i+1].name)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+func (st step) isEnabled(conf *config.SetupConfig) bool {
+	return st.enabled == nil || st.enabled(conf)
+}
+
+func (s *OktaSAMLConnectionBootstraper) samlEnabled(*config.SetupConfig) bool {
+	return s.Protocol != ProtocolOIDC
+}
+
+func (s *OktaSAMLConnectionBootstraper) oidcEnabled(*config.SetupConfig) bool {
+	return s.Protocol == ProtocolOIDC
+}
+
+// connectionID is the Stytch SSO connection created with the protocol in use
+func (s *OktaSAMLConnectionBootstraper) connectionID(conf *config.SetupConfig) string {
+	if s.Protocol == ProtocolOIDC {
+		return conf.OIDCResult.ConnectionID
+	}
+	return conf.StytchResult.ConnectionID
+}
+
+// applicationID is the IdP application created with the protocol in use
+func (s *OktaSAMLConnectionBootstraper) applicationID(conf *config.SetupConfig) string {
+	if s.Protocol == ProtocolOIDC {
+		return conf.OIDCResult.ApplicationID
+	}
+	return conf.OktaResult.ApplicationID
+}
+
+func isStep(name string) bool {
+	for _, step := range StepNames {
+		if step == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runSteps runs the selected steps in order and records their status in the SetupResult
+// The configuration is persisted after each step, so a failed run resumes where it stopped
+func (s *OktaSAMLConnectionBootstraper) runSteps(ctx context.Context, conf *config.SetupConfig) error {
+	steps := s.steps()
+	selected, err := s.selectSteps(steps, conf)
+	if err != nil {
+		return err
+	}
+
+	if conf.Steps == nil {
+		conf.Steps = map[string]config.StepStatus{}
+	}
+
+	for i, step := range steps {
+		// By default whether a step is done is checked when it is reached: a previous step may complete it,
+		// e.g. when create-organization adopts existing resources, or reset it so it runs again
+		if s.FromStep == "" && s.OnlyStep == "" {
+			if !step.isEnabled(conf) || step.done(conf) {
+				continue
+			}
+		} else if !selected[step.name] {
+			continue
+		}
+
+		if err := step.run(journal.WithStep(ctx, step.name), conf); err != nil {
+			conf.Steps[step.name] = config.StepStatus{Status: config.StepFailed, At: time.Now().UTC().Format(time.RFC3339), Error: err.Error()}
+			if saveErr := s.ConfProvider.Save(); saveErr != nil {
+				err = errors.Join(err, fmt.Errorf("error saving Configuration %w", saveErr))
+			}
+			return &StepError{Step: step.name, Err: err, Retryable: isTransient(err)}
+		}
+
+		// The following steps depend on this one, they have to run again
+		if i+1 < len(steps) {
+			resetSteps(conf, steps[i+1].name)
+		}
+		markStepDone(conf, step.name)
+
+		if err := s.ConfProvider.Save(); err != nil {
+			return &StepError{Step: step.name, Err: fmt.Errorf("error saving Configuration %w", err)}
+		}
+	}
+
+	return nil
+}
+
+// resetSteps forgets the status of the step and all the following ones
+func resetSteps(conf *config.SetupConfig, from string) {
+	reset := false
+	for _, name := range StepNames {
+		reset = reset || name == from
+		if reset {
+			delete(conf.Steps, name)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package setup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// memoryConfig is a SetupConfig keeping the configuration in memory
+type memoryConfig struct {
+	conf  *config.SetupConfig
+	saves int
+}
+
+func (m *memoryConfig) Load() (*config.SetupConfig, error) { return m.conf, nil }
+func (m *memoryConfig) Save() error                        { m.saves++; return nil }
+
+func TestSelectSteps(t *testing.T) {
+	created := &config.SetupConfig{
+		SetupInput: &config.SetupInput{},
+		SetupResult: &config.SetupResult{
+			StytchResult: config.StytchResult{OrganizationID: "organization-test", ConnectionID: "saml-connection-test"},
+			OktaResult:   config.OktaResult{ApplicationID: "0oa000"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		conf     *config.SetupConfig
+		protocol string
+		fromStep string
+		onlyStep string
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "from scratch",
+			conf: &config.SetupConfig{SetupInput: &config.SetupInput{}, SetupResult: &config.SetupResult{}},
+			want: []string{StepCreateOrganization, StepCreateConnection, StepCreateApplication, StepFetchMetadata, StepUpdateConnection},
+		},
+		{
+			name: "from scratch with SCIM and organization settings",
+			conf: &config.SetupConfig{
+				SetupInput: &config.SetupInput{
+					StytchSetupInput: config.StytchSetupInput{Settings: config.OrganizationSettings{MFAPolicy: "REQUIRED_FOR_ALL"}},
+					SCIM:             config.SCIMSetupInput{Enabled: true},
+				},
+				SetupResult: &config.SetupResult{},
+			},
+			want: []string{StepCreateOrganization, StepCreateConnection, StepCreateApplication, StepFetchMetadata, StepUpdateConnection,
+				StepCreateSCIM, StepEnableProvisioning, StepUpdateOrganization},
+		},
+		{
+			name:     "from scratch with OIDC",
+			conf:     &config.SetupConfig{SetupInput: &config.SetupInput{}, SetupResult: &config.SetupResult{}},
+			protocol: ProtocolOIDC,
+			want:     []string{StepCreateOrganization, StepCreateOIDCConnection, StepCreateOIDCApplication, StepUpdateOIDCConnection},
+		},
+		{
+			name: "resume after the IdP application",
+			conf: created,
+			want: []string{StepFetchMetadata, StepUpdateConnection},
+		},
+		{
+			name: "a step that runs resets the following ones",
+			conf: &config.SetupConfig{
+				SetupInput: &config.SetupInput{},
+				SetupResult: &config.SetupResult{
+					StytchResult: created.StytchResult,
+					OktaResult:   created.OktaResult,
+					Steps:        map[string]config.StepStatus{StepUpdateConnection: {Status: config.StepDone}},
+				},
+			},
+			want: []string{StepFetchMetadata, StepUpdateConnection},
+		},
+		{
+			name:     "from step",
+			conf:     created,
+			fromStep: StepCreateApplication,
+			want:     []string{StepCreateApplication, StepFetchMetadata, StepUpdateConnection},
+		},
+		{
+			name:     "only step",
+			conf:     created,
+			onlyStep: StepCreateConnection,
+			want:     []string{StepCreateConnection},
+		},
+		{
+			name:     "previous step not done",
+			conf:     created,
+			onlyStep: StepUpdateConnection,
+			wantErr:  "step update-connection requires step fetch-metadata to be done first",
+		},
+		{
+			name:     "disabled step",
+			conf:     created,
+			onlyStep: StepCreateSCIM,
+			wantErr:  "step create-scim-connection is disabled",
+		},
+		{
+			name:     "SAML step with OIDC",
+			conf:     created,
+			protocol: ProtocolOIDC,
+			onlyStep: StepCreateConnection,
+			wantErr:  "step create-connection is disabled",
+		},
+		{
+			name:     "unknown protocol",
+			conf:     created,
+			protocol: "ws-fed",
+			wantErr:  `unknown protocol "ws-fed"`,
+		},
+		{
+			name:     "unknown step",
+			conf:     created,
+			fromStep: "create-everything",
+			wantErr:  `unknown step "create-everything"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &OktaSAMLConnectionBootstraper{IdP: NewMetadataProvider("", nil), Protocol: tt.protocol, FromStep: tt.fromStep, OnlyStep: tt.onlyStep}
+			steps := s.steps()
+			selected, err := s.selectSteps(steps, tt.conf)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var got []string
+			for _, step := range steps {
+				if selected[step.name] {
+					got = append(got, step.name)
+				}
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRunSteps_RecordsStatus(t *testing.T) {
+	provider := &memoryConfig{conf: &config.SetupConfig{
+		SetupInput: &config.SetupInput{},
+		SetupResult: &config.SetupResult{
+			StytchResult: config.StytchResult{OrganizationID: "organization-test", ConnectionID: "saml-connection-test"},
+			Steps: map[string]config.StepStatus{
+				StepCreateApplication: {Status: config.StepDone},
+				StepUpdateConnection:  {Status: config.StepDone},
+			},
+		},
+	}}
+
+	s := &OktaSAMLConnectionBootstraper{
+		IdP:          NewMetadataProvider("testdata/idp_metadata.xml", nil),
+		ConfProvider: provider,
+		OnlyStep:     StepFetchMetadata,
+	}
+
+	require.NoError(t, s.Setup(context.Background()))
+	require.True(t, provider.conf.StepDone(StepFetchMetadata))
+	require.False(t, provider.conf.StepDone(StepUpdateConnection), "the following steps must run again")
+	require.Equal(t, "http://www.okta.com/exk0000000000000000", provider.conf.OktaResult.SsoParameters.IdpEntityID)
+	require.Equal(t, 1, provider.saves)
+
+	s.IdP = NewMetadataProvider("testdata/missing.xml", nil)
+	err := s.Setup(context.Background())
+
+	var stepErr *StepError
+	require.True(t, errors.As(err, &stepErr))
+	require.Equal(t, StepFetchMetadata, stepErr.Step)
+	require.False(t, stepErr.Retryable)
+	require.Equal(t, config.StepFailed, provider.conf.Steps[StepFetchMetadata].Status)
+}
+
+func TestRunSteps_RunsResetStepsInTheSameRun(t *testing.T) {
+	s, provider, _, _ := newFakeSetup(t)
+
+	// Fetching the metadata again resets the update of the connection, which was done
+	delete(provider.conf.Steps, StepFetchMetadata)
+	saves := provider.saves
+	require.NoError(t, s.Setup(context.Background()))
+
+	require.True(t, provider.conf.StepDone(StepFetchMetadata))
+	require.True(t, provider.conf.StepDone(StepUpdateConnection))
+	require.Equal(t, 2, provider.saves-saves)
+}
@@ -0,0 +1,55 @@
+package setup
+
+import (
+	"context"
+	"sync"
+)
+
+// SetupTenants runs Setup for every tenant with at most concurrency setups in flight
+// Each bootstraper persists its own results, a failing tenant does not stop the others
+// The returned map holds the error of every tenant that failed
+func SetupTenants(ctx context.Context, tenants map[string]*OktaSAMLConnectionBootstraper, concurrency int) map[string]error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = map[string]error{}
+		sem  = make(chan struct{}, concurrency)
+	)
+
+	for name, bootstraper := range tenants {
+		wg.Add(1)
+		go func(name string, bootstraper *OktaSAMLConnectionBootstraper) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				mu.Lock()
+				errs[name] = ctx.Err()
+				mu.Unlock()
+				return
+			}
+
+			if err := bootstraper.Setup(ctx); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name, bootstraper)
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// ForTenant returns a copy of the bootstraper using another configuration provider
+func (s *OktaSAMLConnectionBootstraper) ForTenant(provider SetupConfig) *OktaSAMLConnectionBootstraper {
+	tenant := *s
+	tenant.ConfProvider = provider
+	return &tenant
+}
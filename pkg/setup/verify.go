@@ -0,0 +1,131 @@
+package setup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+// Drift is a field whose current value differs from what the setup configured
+type Drift struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: expected %q, got %q", d.Field, d.Expected, d.Actual)
+}
+
+// Verify compares the Stytch SAML connection and the IdP application with the SetupResult
+// and with each other, it returns one Drift per field that no longer matches
+func (s *OktaSAMLConnectionBootstraper) Verify(ctx context.Context) ([]Drift, error) {
+	conf, err := s.ConfProvider.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading Configuration %w", err)
+	}
+
+	if conf.StytchResult.OrganizationID == "" || conf.StytchResult.ConnectionID == "" {
+		return nil, fmt.Errorf("nothing to verify, did you complete the setup?")
+	}
+
+	connection, err := s.getStytchConnection(ctx, conf.StytchResult.OrganizationID, conf.StytchResult.ConnectionID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching SSO SAML Connection %w", err)
+	}
+
+	var drifts []Drift
+	compare := func(field, expected, actual string) {
+		if expected != actual {
+			drifts = append(drifts, Drift{Field: field, Expected: expected, Actual: actual})
+		}
+	}
+
+	// Stytch connection against the recorded result
+	if sp := conf.StytchResult.SsoParameters; sp != nil {
+		compare("stytch.acs_url", sp.AcsUrl, connection.AcsURL)
+		compare("stytch.audience", sp.Audience, connection.AudienceURI)
+	}
+	compare("stytch.attribute_mapping", formatMapping(s.IdP.AttributeMapping(conf.SetupInput)), formatMapping(connection.AttributeMapping))
+
+	// Stytch connection against the IdP metadata
+	idpSso, err := s.IdP.FetchMetadata(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetch %s Application SSO metadata %w", s.IdP.Name(), err)
+	}
+	compare("stytch.idp_entity_id", idpSso.IdpEntityID, connection.IdpEntityID)
+	compare("stytch.idp_sso_url", idpSso.IdpSSOURL, connection.IdpSSOURL)
+
+	certificates := make([]string, 0, len(connection.VerificationCertificates))
+	for _, cert := range connection.VerificationCertificates {
+		certificates = append(certificates, normalizeCertificate(cert.Certificate))
+	}
+	if !contains(certificates, normalizeCertificate(idpSso.X509Certificate)) {
+		actual := make([]string, 0, len(certificates))
+		for _, cert := range certificates {
+			actual = append(actual, certificateFingerprint(cert))
+		}
+		compare("stytch.x509_certificate", certificateFingerprint(idpSso.X509Certificate), strings.Join(actual, ","))
+	}
+
+	// IdP application against the Stytch connection
+	reader, ok := s.IdP.(SAMLApplicationReader)
+	if !ok || conf.OktaResult.ApplicationID == "" {
+		return drifts, nil
+	}
+
+	app, err := reader.GetSAMLApplication(ctx, conf.OktaResult.ApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s Application %w", s.IdP.Name(), err)
+	}
+	compare("idp.acs_url", connection.AcsURL, app.AcsUrl)
+	compare("idp.audience", connection.AudienceURI, app.Audience)
+	if app.Attributes != nil {
+		compare("idp.attribute_statements", formatStatements(conf.Attributes), formatStatements(app.Attributes))
+	}
+
+	return drifts, nil
+}
+
+// formatMapping renders an attribute mapping with sorted keys so it can be compared
+func formatMapping(mapping map[string]any) string {
+	keys := make([]string, 0, len(mapping))
+	for k := range mapping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, mapping[k]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// formatStatements renders the attribute statements sent by the IdP, the subject and Stytch fields are ignored
+func formatStatements(attributes []config.SAMLAttribute) string {
+	parts := []string{}
+	for _, attr := range oktaAttributeStatements(attributes) {
+		part := fmt.Sprintf("%s[%s]", attr.GetName(), attr.GetType())
+		if attr.GetType() == "GROUP" {
+			part += fmt.Sprintf("%s:%s", attr.GetFilterType(), attr.GetFilterValue())
+		} else {
+			part += strings.Join(attr.Values, ",")
+		}
+		parts = append(parts, part+"@"+attr.GetNamespace())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ";")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
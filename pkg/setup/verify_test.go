@@ -0,0 +1,65 @@
+package setup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+	"github.com/xNok/go-stytch-demo/pkg/config"
+)
+
+func TestVerify_ReportsDrifts(t *testing.T) {
+	s, provider, _, _ := newFakeSetup(t)
+	ctx := context.Background()
+
+	drifts, err := s.Verify(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	// The recorded result no longer matches the connection
+	acsURL := provider.conf.StytchResult.SsoParameters.AcsUrl
+	provider.conf.StytchResult.SsoParameters.AcsUrl = "https://test.stytch.com/v1/b2b/sso/callback/other"
+	drifts, err = s.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Drift{{Field: "stytch.acs_url", Expected: "https://test.stytch.com/v1/b2b/sso/callback/other", Actual: acsURL}}, drifts)
+	provider.conf.StytchResult.SsoParameters.AcsUrl = acsURL
+
+	// The attributes of the input changed since the setup
+	provider.conf.Attributes = provider.conf.Attributes[:1]
+	drifts, err = s.Verify(ctx)
+	require.NoError(t, err)
+	var fields []string
+	for _, drift := range drifts {
+		fields = append(fields, drift.Field)
+	}
+	require.Equal(t, []string{"stytch.attribute_mapping", "idp.attribute_statements"}, fields)
+
+	provider.conf.SetupResult = &config.SetupResult{}
+	_, err = s.Verify(ctx)
+	require.ErrorContains(t, err, "nothing to verify")
+}
+
+func TestVerify_ReportsChangesMadeOutsideTheSetup(t *testing.T) {
+	s, provider, fakeStytch, _ := newFakeSetup(t)
+	ctx := context.Background()
+	organizationID, connectionID := provider.conf.StytchResult.OrganizationID, provider.conf.StytchResult.ConnectionID
+
+	// The connection is edited in the Stytch dashboard and loses the certificate of the IdP
+	_, err := s.StytchClient.SSO.SAML.UpdateConnection(ctx, &saml.UpdateConnectionParams{
+		OrganizationID: organizationID,
+		ConnectionID:   connectionID,
+		IdpSSOURL:      "https://acme.okta.com/app/other/sso/saml",
+	})
+	require.NoError(t, err)
+	conn, ok := fakeStytch.Connection(connectionID)
+	require.True(t, ok)
+	require.NoError(t, s.deleteStytchVerificationCertificate(ctx, organizationID, connectionID, conn.VerificationCertificates[0].CertificateID))
+
+	drifts, err := s.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []Drift{
+		{Field: "stytch.idp_sso_url", Expected: provider.conf.OktaResult.SsoParameters.IdpSSOURL, Actual: "https://acme.okta.com/app/other/sso/saml"},
+		{Field: "stytch.x509_certificate", Expected: certificateFingerprint(provider.conf.OktaResult.SsoParameters.X509Certificate), Actual: ""},
+	}, drifts)
+}
@@ -0,0 +1,89 @@
+package stytchtest
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/oidc"
+)
+
+func (s *Server) createOIDCConnection(w http.ResponseWriter, r *http.Request) {
+	var params oidc.CreateConnectionParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	id := newID("oidc-connection-test")
+	conn := &sso.OIDCConnection{
+		OrganizationID: org.OrganizationID,
+		ConnectionID:   id,
+		Status:         "pending",
+		DisplayName:    params.DisplayName,
+		RedirectURL:    fmt.Sprintf("%s/v1/b2b/sso/callback/%s", s.URL, id),
+	}
+	s.oidcConnections[id] = conn
+
+	writeJSON(w, oidc.CreateConnectionResponse{
+		RequestID:  newID("request-id-test"),
+		Connection: conn,
+		StatusCode: http.StatusOK,
+	})
+}
+
+// updateOIDCConnection merges the fields set, the endpoints are not discovered from the issuer
+// The connection becomes active once the issuer and the client credentials are known
+func (s *Server) updateOIDCConnection(w http.ResponseWriter, r *http.Request) {
+	var params oidc.UpdateConnectionParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organization(w, r.PathValue("organization_id")); !ok {
+		return
+	}
+	conn, ok := s.oidcConnections[r.PathValue("connection_id")]
+	if !ok || conn.OrganizationID != r.PathValue("organization_id") {
+		writeError(w, http.StatusNotFound, "connection_not_found", "Connection could not be found.")
+		return
+	}
+
+	params.OrganizationID = conn.OrganizationID
+	params.ConnectionID = conn.ConnectionID
+	if err := merge(conn, params); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_body", err.Error())
+		return
+	}
+	if conn.Issuer != "" && conn.ClientID != "" && conn.ClientSecret != "" {
+		conn.Status = "active"
+	}
+
+	writeJSON(w, oidc.UpdateConnectionResponse{
+		RequestID:  newID("request-id-test"),
+		Connection: conn,
+		StatusCode: http.StatusOK,
+	})
+}
+
+// OIDCConnection returns a copy of an OIDC connection, client secret included, for assertions
+func (s *Server) OIDCConnection(id string) (sso.OIDCConnection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.oidcConnections[id]
+	if !ok {
+		return sso.OIDCConnection{}, false
+	}
+	return *conn, true
+}
@@ -0,0 +1,220 @@
+package stytchtest
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+)
+
+func (s *Server) createOrganization(w http.ResponseWriter, r *http.Request) {
+	var params organizations.CreateParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if params.OrganizationName == "" {
+		writeError(w, http.StatusBadRequest, "organization_name_required", "organization_name is required.")
+		return
+	}
+	if s.organizationBySlug(params.OrganizationSlug) != nil {
+		writeError(w, http.StatusBadRequest, "organization_slug_already_used", "The organization_slug is already in use by another organization.")
+		return
+	}
+
+	org := &organizations.Organization{
+		OrganizationID:       newID("organization-test"),
+		SSOJITProvisioning:   "ALL_ALLOWED",
+		EmailJITProvisioning: "NOT_ALLOWED",
+		EmailInvites:         "ALL_ALLOWED",
+		AuthMethods:          "ALL_ALLOWED",
+		MFAPolicy:            "OPTIONAL",
+		MFAMethods:           "ALL_ALLOWED",
+	}
+	if err := merge(org, params); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_body", err.Error())
+		return
+	}
+	s.organizations[org.OrganizationID] = org
+
+	writeJSON(w, organizations.CreateResponse{
+		RequestID:    newID("request-id-test"),
+		Organization: *org,
+		StatusCode:   http.StatusOK,
+	})
+}
+
+func (s *Server) getOrganization(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	writeJSON(w, organizations.GetResponse{
+		RequestID:    newID("request-id-test"),
+		Organization: *org,
+		StatusCode:   http.StatusOK,
+	})
+}
+
+func (s *Server) updateOrganization(w http.ResponseWriter, r *http.Request) {
+	var params organizations.UpdateParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+	if params.OrganizationSlug != "" {
+		if other := s.organizationBySlug(params.OrganizationSlug); other != nil && other != org {
+			writeError(w, http.StatusBadRequest, "organization_slug_already_used", "The organization_slug is already in use by another organization.")
+			return
+		}
+	}
+
+	params.OrganizationID = org.OrganizationID
+	if err := merge(org, params); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_body", err.Error())
+		return
+	}
+
+	writeJSON(w, organizations.UpdateResponse{
+		RequestID:    newID("request-id-test"),
+		Organization: *org,
+		StatusCode:   http.StatusOK,
+	})
+}
+
+func (s *Server) deleteOrganization(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	delete(s.organizations, org.OrganizationID)
+	for id, conn := range s.connections {
+		if conn.OrganizationID == org.OrganizationID {
+			delete(s.connections, id)
+		}
+	}
+	for id, conn := range s.oidcConnections {
+		if conn.OrganizationID == org.OrganizationID {
+			delete(s.oidcConnections, id)
+		}
+	}
+	for id, conn := range s.scimConnections {
+		if conn.OrganizationID == org.OrganizationID {
+			delete(s.scimConnections, id)
+		}
+	}
+	for id, m := range s.members {
+		if m.OrganizationID == org.OrganizationID {
+			delete(s.members, id)
+		}
+	}
+
+	writeJSON(w, organizations.DeleteResponse{
+		RequestID:  newID("request-id-test"),
+		StatusCode: http.StatusOK,
+	})
+}
+
+// searchOrganizations supports the organization_ids and organization_slugs filters
+func (s *Server) searchOrganizations(w http.ResponseWriter, r *http.Request) {
+	var params organizations.SearchParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var filters []func(*organizations.Organization) bool
+	if params.Query != nil {
+		for _, operand := range params.Query.Operands {
+			values, _ := operand["filter_value"].([]any)
+			switch name := operand["filter_name"]; name {
+			case "organization_ids":
+				filters = append(filters, func(org *organizations.Organization) bool { return slices.Contains(values, any(org.OrganizationID)) })
+			case "organization_slugs":
+				filters = append(filters, func(org *organizations.Organization) bool { return slices.Contains(values, any(org.OrganizationSlug)) })
+			default:
+				writeError(w, http.StatusBadRequest, "invalid_search_query", fmt.Sprintf("filter_name %v is not supported by stytchtest.", name))
+				return
+			}
+		}
+	}
+
+	matches := []organizations.Organization{}
+	for _, org := range s.organizations {
+		if matchAll(org, filters, params.Query != nil && params.Query.Operator == "OR") {
+			matches = append(matches, *org)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].OrganizationSlug < matches[j].OrganizationSlug })
+
+	writeJSON(w, organizations.SearchResponse{
+		RequestID:       newID("request-id-test"),
+		Organizations:   matches,
+		ResultsMetadata: organizations.ResultsMetadata{Total: int32(len(matches))},
+		StatusCode:      http.StatusOK,
+	})
+}
+
+func matchAll(org *organizations.Organization, filters []func(*organizations.Organization) bool, or bool) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, filter := range filters {
+		if filter(org) == or {
+			return or
+		}
+	}
+	return !or
+}
+
+// organization looks up an organization, writing the Stytch 404 when it does not exist
+func (s *Server) organization(w http.ResponseWriter, id string) (*organizations.Organization, bool) {
+	org, ok := s.organizations[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "organization_not_found", "Organization could not be found.")
+	}
+	return org, ok
+}
+
+func (s *Server) organizationBySlug(slug string) *organizations.Organization {
+	for _, org := range s.organizations {
+		if org.OrganizationSlug == slug {
+			return org
+		}
+	}
+	return nil
+}
+
+// Organization returns a copy of an organization, for assertions
+func (s *Server) Organization(id string) (organizations.Organization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organizations[id]
+	if !ok {
+		return organizations.Organization{}, false
+	}
+	return *org, true
+}
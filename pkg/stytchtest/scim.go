@@ -0,0 +1,96 @@
+package stytchtest
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// scimTokenValidity is the lifetime of the bearer token of a new SCIM connection
+const scimTokenValidity = 180 * 24 * time.Hour
+
+// SCIMConnection is the SCIM connection object of the Stytch API, stytch-go v12 has no type for it
+// ref: https://stytch.com/docs/b2b/api/scim-connection-object
+type SCIMConnection struct {
+	OrganizationID       string `json:"organization_id"`
+	ConnectionID         string `json:"connection_id"`
+	Status               string `json:"status"`
+	DisplayName          string `json:"display_name"`
+	IdentityProvider     string `json:"identity_provider"`
+	BaseURL              string `json:"base_url"`
+	BearerToken          string `json:"bearer_token,omitempty"`
+	BearerTokenExpiresAt string `json:"bearer_token_expires_at"`
+}
+
+type scimConnectionParams struct {
+	DisplayName      string `json:"display_name"`
+	IdentityProvider string `json:"identity_provider"`
+}
+
+// createSCIMConnection returns the bearer token, like Stytch it is only sent in this answer
+func (s *Server) createSCIMConnection(w http.ResponseWriter, r *http.Request) {
+	var params scimConnectionParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	id := newID("scim-connection-test")
+	conn := &SCIMConnection{
+		OrganizationID:       org.OrganizationID,
+		ConnectionID:         id,
+		Status:               "active",
+		DisplayName:          params.DisplayName,
+		IdentityProvider:     params.IdentityProvider,
+		BaseURL:              fmt.Sprintf("%s/v1/b2b/scim/%s", s.URL, id),
+		BearerToken:          newID("scim-token-test"),
+		BearerTokenExpiresAt: time.Now().Add(scimTokenValidity).UTC().Format(time.RFC3339),
+	}
+	s.scimConnections[id] = conn
+
+	writeJSON(w, map[string]any{
+		"request_id":  newID("request-id-test"),
+		"connection":  conn,
+		"status_code": http.StatusOK,
+	})
+}
+
+func (s *Server) deleteSCIMConnection(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.organization(w, r.PathValue("organization_id")); !ok {
+		return
+	}
+	conn, ok := s.scimConnections[r.PathValue("connection_id")]
+	if !ok || conn.OrganizationID != r.PathValue("organization_id") {
+		writeError(w, http.StatusNotFound, "connection_not_found", "Connection could not be found.")
+		return
+	}
+	delete(s.scimConnections, conn.ConnectionID)
+
+	writeJSON(w, map[string]any{
+		"request_id":    newID("request-id-test"),
+		"connection_id": conn.ConnectionID,
+		"status_code":   http.StatusOK,
+	})
+}
+
+// SCIMConnection returns a copy of a SCIM connection, bearer token included, for assertions
+func (s *Server) SCIMConnection(id string) (SCIMConnection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.scimConnections[id]
+	if !ok {
+		return SCIMConnection{}, false
+	}
+	return *conn, true
+}
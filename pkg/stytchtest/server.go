@@ -0,0 +1,214 @@
+// Package stytchtest provides an in-memory fake of the Stytch B2B API for offline tests
+//
+// The fake implements the endpoints used by this project: organizations, SSO SAML and OIDC connections,
+// SCIM connections, SSO authenticate, sessions authenticate with authorization checks, the JWKS and the RBAC policy.
+// IdP logins are simulated with SSOLogin, which returns the token Stytch would send to the redirect URL.
+package stytchtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/b2bstytchapi"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/rbac"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+)
+
+// Credentials accepted by the fake, Client uses them
+const (
+	ProjectID = "project-test-00000000-0000-0000-0000-000000000000"
+	Secret    = "secret-test-fake"
+)
+
+// StytchMemberRole is assigned to every member, like in Stytch
+const StytchMemberRole = "stytch_member"
+
+// Server is a fake Stytch B2B API backed by an httptest.Server
+type Server struct {
+	*httptest.Server
+
+	// Policy is the RBAC policy used for authorization checks, tests can replace it
+	Policy *rbac.Policy
+
+	mu            sync.Mutex
+	organizations map[string]*organizations.Organization
+	connections   map[string]*sso.SAMLConnection
+	// oidcConnections share the SSO endpoints of the SAML connections
+	oidcConnections map[string]*sso.OIDCConnection
+	// scimConnections are not in the Go SDK, see SCIMConnection
+	scimConnections map[string]*SCIMConnection
+	members         map[string]*member
+	sessions        map[string]*session
+	ssoTokens       map[string]*member
+	key             *rsa.PrivateKey
+	keyID           string
+
+	requestsMu sync.Mutex
+	requests   []string
+}
+
+// member is an organization member along with what the IdP sent on its last login
+type member struct {
+	organizations.Member
+	connectionID string
+	groups       []string
+}
+
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("stytchtest: generating the JWT signing key: %v", err))
+	}
+
+	s := &Server{
+		Policy: &rbac.Policy{
+			Roles: []rbac.PolicyRole{{RoleID: StytchMemberRole}},
+		},
+		organizations:   map[string]*organizations.Organization{},
+		connections:     map[string]*sso.SAMLConnection{},
+		oidcConnections: map[string]*sso.OIDCConnection{},
+		scimConnections: map[string]*SCIMConnection{},
+		members:         map[string]*member{},
+		sessions:        map[string]*session{},
+		ssoTokens:       map[string]*member{},
+		key:             key,
+		keyID:           newID("jwk-test"),
+	}
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// Client returns a Stytch client pointed at the fake
+func (s *Server) Client(opts ...b2bstytchapi.Option) (*b2bstytchapi.API, error) {
+	opts = append([]b2bstytchapi.Option{b2bstytchapi.WithBaseURI(s.URL)}, opts...)
+	return b2bstytchapi.NewClient(ProjectID, Secret, opts...)
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("POST /v1/b2b/organizations", s.createOrganization)
+	mux.HandleFunc("POST /v1/b2b/organizations/search", s.searchOrganizations)
+	mux.HandleFunc("GET /v1/b2b/organizations/{organization_id}", s.getOrganization)
+	mux.HandleFunc("PUT /v1/b2b/organizations/{organization_id}", s.updateOrganization)
+	mux.HandleFunc("DELETE /v1/b2b/organizations/{organization_id}", s.deleteOrganization)
+
+	mux.HandleFunc("GET /v1/b2b/sso/{organization_id}", s.getConnections)
+	mux.HandleFunc("DELETE /v1/b2b/sso/{organization_id}/connections/{connection_id}", s.deleteConnection)
+	mux.HandleFunc("POST /v1/b2b/sso/saml/{organization_id}", s.createSAMLConnection)
+	mux.HandleFunc("PUT /v1/b2b/sso/saml/{organization_id}/connections/{connection_id}", s.updateSAMLConnection)
+	mux.HandleFunc("DELETE /v1/b2b/sso/saml/{organization_id}/connections/{connection_id}/verification_certificates/{certificate_id}", s.deleteVerificationCertificate)
+	mux.HandleFunc("POST /v1/b2b/sso/oidc/{organization_id}", s.createOIDCConnection)
+	mux.HandleFunc("PUT /v1/b2b/sso/oidc/{organization_id}/connections/{connection_id}", s.updateOIDCConnection)
+	mux.HandleFunc("POST /v1/b2b/sso/authenticate", s.ssoAuthenticate)
+
+	mux.HandleFunc("POST /v1/b2b/scim/{organization_id}/connection", s.createSCIMConnection)
+	mux.HandleFunc("DELETE /v1/b2b/scim/{organization_id}/connection/{connection_id}", s.deleteSCIMConnection)
+
+	mux.HandleFunc("POST /v1/b2b/sessions/authenticate", s.authenticateSession)
+	mux.HandleFunc("GET /v1/b2b/sessions/jwks/{project_id}", s.jwks)
+	mux.HandleFunc("GET /v1/b2b/rbac/policy", s.policy)
+
+	return s.authenticated(mux)
+}
+
+// authenticated checks the project credentials like Stytch, the JWKS is public
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/b2b/sessions/jwks/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		projectID, secret, ok := r.BasicAuth()
+		if !ok || projectID != ProjectID || secret != Secret {
+			writeError(w, http.StatusUnauthorized, "unauthorized_credentials", "Unauthorized credentials.")
+			return
+		}
+		s.requestsMu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.requestsMu.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Requests returns the authenticated requests received so far, as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.requestsMu.Lock()
+	defer s.requestsMu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_body", err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in the format decoded into a stytcherror.Error by the SDK
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status_code":   status,
+		"request_id":    newID("request-id-test"),
+		"error_type":    errorType,
+		"error_message": message,
+		"error_url":     fmt.Sprintf("https://stytch.com/docs/api/errors/%d", status),
+	})
+}
+
+// newID returns an ID formatted like the Stytch test IDs (prefix-uuid)
+func newID(prefix string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	h := hex.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s-%s", prefix, h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// merge applies the fields set in the patch (omitempty JSON) onto dst
+func merge(dst any, patch any) error {
+	current, err := toMap(dst)
+	if err != nil {
+		return err
+	}
+	update, err := toMap(patch)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range update {
+		current[key] = value
+	}
+
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func toMap(v any) (map[string]any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	return m, json.Unmarshal(raw, &m)
+}
@@ -0,0 +1,199 @@
+package stytchtest
+
+import (
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/rbac"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sessions"
+	consumer "github.com/stytchauth/stytch-go/v12/stytch/consumer/sessions"
+	"github.com/stytchauth/stytch-go/v12/stytch/shared"
+	"github.com/stytchauth/stytch-go/v12/stytch/stytcherror"
+)
+
+// defaultSessionDuration is used when session_duration_minutes is not set, like Stytch
+const defaultSessionDuration = 60 * time.Minute
+
+// session is a member session along with the credentials handed to the member
+type session struct {
+	sessions.MemberSession
+	token string
+	jwt   string
+}
+
+// newSession starts a session for the member and signs its JWT
+func (s *Server) newSession(m *member, org *organizations.Organization, durationMinutes int32) (*session, error) {
+	duration := defaultSessionDuration
+	if durationMinutes > 0 {
+		duration = time.Duration(durationMinutes) * time.Minute
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	expires := now.Add(duration)
+	sess := &session{
+		MemberSession: sessions.MemberSession{
+			MemberSessionID: newID("member-session-test"),
+			MemberID:        m.MemberID,
+			StartedAt:       &now,
+			LastAccessedAt:  &now,
+			ExpiresAt:       &expires,
+			AuthenticationFactors: []consumer.AuthenticationFactor{{
+				Type:           "sso",
+				DeliveryMethod: "sso_saml",
+			}},
+			OrganizationID: m.OrganizationID,
+			Roles:          roleIDs(m.Roles),
+		},
+		token: newID("session-token-test"),
+	}
+
+	claims := sessions.Claims{
+		Session: consumer.SessionClaim{
+			ID:                    sess.MemberSessionID,
+			StartedAt:             now.Format(time.RFC3339),
+			LastAccessedAt:        now.Format(time.RFC3339),
+			ExpiresAt:             expires.Format(time.RFC3339),
+			AuthenticationFactors: sess.AuthenticationFactors,
+			Roles:                 sess.Roles,
+		},
+		Organization: sessions.OrgClaim{
+			ID:   org.OrganizationID,
+			Slug: org.OrganizationSlug,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   m.MemberID,
+			Audience:  jwt.ClaimStrings{ProjectID},
+			Issuer:    "stytch.com/" + ProjectID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+
+	var err error
+	if sess.jwt, err = token.SignedString(s.key); err != nil {
+		return nil, err
+	}
+
+	s.sessions[sess.token] = sess
+	return sess, nil
+}
+
+// authenticateSession accepts either the session token or the session JWT
+// When an authorization check is requested, it is evaluated against Policy with the roles of the session
+func (s *Server) authenticateSession(w http.ResponseWriter, r *http.Request) {
+	var params sessions.AuthenticateParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess := s.session(params.SessionToken, params.SessionJWT)
+	if sess == nil || sess.ExpiresAt.Before(time.Now()) {
+		writeError(w, http.StatusNotFound, "session_not_found", "Session could not be found.")
+		return
+	}
+	m := s.members[sess.MemberID]
+	org := s.organizations[sess.OrganizationID]
+	if m == nil || org == nil {
+		writeError(w, http.StatusNotFound, "session_not_found", "Session could not be found.")
+		return
+	}
+
+	var verdict *sessions.AuthorizationVerdict
+	if check := params.AuthorizationCheck; check != nil {
+		if err := shared.PerformAuthorizationCheck(s.Policy, sess.Roles, sess.OrganizationID, check); err != nil {
+			stytchErr := err.(stytcherror.Error)
+			writeError(w, stytchErr.StatusCode, string(stytchErr.ErrorType), string(stytchErr.ErrorMessage))
+			return
+		}
+		verdict = &sessions.AuthorizationVerdict{Authorized: true, GrantingRoles: s.grantingRoles(sess.Roles, check)}
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sess.LastAccessedAt = &now
+
+	writeJSON(w, sessions.AuthenticateResponse{
+		RequestID:     newID("request-id-test"),
+		MemberSession: sess.MemberSession,
+		SessionToken:  sess.token,
+		SessionJWT:    sess.jwt,
+		Member:        m.Member,
+		Organization:  *org,
+		StatusCode:    http.StatusOK,
+		Verdict:       verdict,
+	})
+}
+
+func (s *Server) session(token, jwt string) *session {
+	if sess, ok := s.sessions[token]; ok && token != "" {
+		return sess
+	}
+	for _, sess := range s.sessions {
+		if jwt != "" && sess.jwt == jwt {
+			return sess
+		}
+	}
+	return nil
+}
+
+// grantingRoles lists the roles of the session allowing the check, PerformAuthorizationCheck only says yes or no
+func (s *Server) grantingRoles(roles []string, check *sessions.AuthorizationCheck) []string {
+	var granting []string
+	for _, roleID := range roles {
+		err := shared.PerformAuthorizationCheck(s.Policy, []string{roleID}, check.OrganizationID, check)
+		if err == nil {
+			granting = append(granting, roleID)
+		}
+	}
+	return granting
+}
+
+// jwks publishes the public key used to sign the session JWTs, the SDK fetches it in NewClient
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("project_id") != ProjectID {
+		writeError(w, http.StatusNotFound, "project_not_found", "Project could not be found.")
+		return
+	}
+
+	writeJSON(w, sessions.GetJWKSResponse{
+		Keys: []consumer.JWK{{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: s.keyID,
+			N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+		RequestID:  newID("request-id-test"),
+		StatusCode: http.StatusOK,
+	})
+}
+
+// policy returns Policy, note the SDK caches it for 5 minutes
+func (s *Server) policy(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, rbac.PolicyResponse{
+		RequestID:  newID("request-id-test"),
+		StatusCode: http.StatusOK,
+		Policy:     s.Policy,
+	})
+}
+
+func roleIDs(roles []organizations.MemberRole) []string {
+	ids := make([]string, 0, len(roles))
+	for _, role := range roles {
+		ids = append(ids, role.RoleID)
+	}
+	return ids
+}
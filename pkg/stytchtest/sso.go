@@ -0,0 +1,338 @@
+package stytchtest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/organizations"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso"
+	"github.com/stytchauth/stytch-go/v12/stytch/b2b/sso/saml"
+)
+
+func (s *Server) getConnections(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	connections := []sso.SAMLConnection{}
+	for _, conn := range s.connections {
+		if conn.OrganizationID == org.OrganizationID {
+			connections = append(connections, *conn)
+		}
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ConnectionID < connections[j].ConnectionID })
+
+	oidcConnections := []sso.OIDCConnection{}
+	for _, conn := range s.oidcConnections {
+		if conn.OrganizationID == org.OrganizationID {
+			oidcConnections = append(oidcConnections, *conn)
+		}
+	}
+	sort.Slice(oidcConnections, func(i, j int) bool { return oidcConnections[i].ConnectionID < oidcConnections[j].ConnectionID })
+
+	writeJSON(w, sso.GetConnectionsResponse{
+		RequestID:       newID("request-id-test"),
+		SAMLConnections: connections,
+		OIDCConnections: oidcConnections,
+		StatusCode:      http.StatusOK,
+	})
+}
+
+// deleteConnection deletes a SAML or an OIDC connection, like Stytch
+func (s *Server) deleteConnection(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	organizationID, connectionID := r.PathValue("organization_id"), r.PathValue("connection_id")
+	if conn, ok := s.oidcConnections[connectionID]; ok && conn.OrganizationID == organizationID {
+		delete(s.oidcConnections, connectionID)
+	} else {
+		conn, ok := s.connection(w, organizationID, connectionID)
+		if !ok {
+			return
+		}
+		delete(s.connections, conn.ConnectionID)
+	}
+
+	writeJSON(w, sso.DeleteConnectionResponse{
+		RequestID:    newID("request-id-test"),
+		ConnectionID: connectionID,
+		StatusCode:   http.StatusOK,
+	})
+}
+
+func (s *Server) createSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	var params saml.CreateConnectionParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.organization(w, r.PathValue("organization_id"))
+	if !ok {
+		return
+	}
+
+	id := newID("saml-connection-test")
+	callback := fmt.Sprintf("%s/v1/b2b/sso/callback/%s", s.URL, id)
+	conn := &sso.SAMLConnection{
+		OrganizationID: org.OrganizationID,
+		ConnectionID:   id,
+		Status:         "pending",
+		DisplayName:    params.DisplayName,
+		AcsURL:         callback,
+		AudienceURI:    callback,
+	}
+	s.connections[id] = conn
+
+	writeJSON(w, saml.CreateConnectionResponse{
+		RequestID:  newID("request-id-test"),
+		Connection: conn,
+		StatusCode: http.StatusOK,
+	})
+}
+
+// updateSAMLConnection merges the fields set, a new x509_certificate is added to the verification certificates
+// The connection becomes active once the IdP entity ID, SSO URL and a certificate are known
+func (s *Server) updateSAMLConnection(w http.ResponseWriter, r *http.Request) {
+	var params saml.UpdateConnectionParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connection(w, r.PathValue("organization_id"), r.PathValue("connection_id"))
+	if !ok {
+		return
+	}
+
+	certificate := params.X509Certificate
+	params.X509Certificate = ""
+	params.OrganizationID = conn.OrganizationID
+	params.ConnectionID = conn.ConnectionID
+	if err := merge(conn, params); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_body", err.Error())
+		return
+	}
+
+	if certificate != "" && !trusts(conn, certificate) {
+		now := time.Now().UTC()
+		conn.VerificationCertificates = append(conn.VerificationCertificates, sso.X509Certificate{
+			CertificateID: newID("saml-verification-key-test"),
+			Certificate:   certificate,
+			CreatedAt:     &now,
+		})
+	}
+	if conn.IdpEntityID != "" && conn.IdpSSOURL != "" && len(conn.VerificationCertificates) > 0 {
+		conn.Status = "active"
+	}
+
+	writeJSON(w, saml.UpdateConnectionResponse{
+		RequestID:  newID("request-id-test"),
+		Connection: conn,
+		StatusCode: http.StatusOK,
+	})
+}
+
+func (s *Server) deleteVerificationCertificate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connection(w, r.PathValue("organization_id"), r.PathValue("connection_id"))
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("certificate_id")
+	for i, cert := range conn.VerificationCertificates {
+		if cert.CertificateID == id {
+			conn.VerificationCertificates = append(conn.VerificationCertificates[:i], conn.VerificationCertificates[i+1:]...)
+			writeJSON(w, saml.DeleteVerificationCertificateResponse{
+				RequestID:     newID("request-id-test"),
+				CertificateID: id,
+				StatusCode:    http.StatusOK,
+			})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "verification_certificate_not_found", "Verification certificate could not be found.")
+}
+
+func (s *Server) ssoAuthenticate(w http.ResponseWriter, r *http.Request) {
+	var params sso.AuthenticateParams
+	if !decode(w, r, &params) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.ssoTokens[params.SSOToken]
+	if !ok {
+		writeError(w, http.StatusNotFound, "sso_token_not_found", "The SSO token could not be found, it may have been used already.")
+		return
+	}
+	// Tokens can only be used once
+	delete(s.ssoTokens, params.SSOToken)
+
+	org := s.organizations[m.OrganizationID]
+	m.Roles = s.roles(m)
+	session, err := s.newSession(m, org, params.SessionDurationMinutes)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, sso.AuthenticateResponse{
+		RequestID:           newID("request-id-test"),
+		MemberID:            m.MemberID,
+		OrganizationID:      m.OrganizationID,
+		Member:              m.Member,
+		SessionToken:        session.token,
+		SessionJWT:          session.jwt,
+		Organization:        *org,
+		MemberAuthenticated: true,
+		MemberSession:       &session.MemberSession,
+		StatusCode:          http.StatusOK,
+	})
+}
+
+// SSOLogin simulates a successful login of email at the IdP of the connection
+// It returns the SSO token Stytch appends to the redirect URL, to be exchanged with SSO.Authenticate
+// The member is provisioned just in time on its first login, groups are the ones sent in the SAML assertion
+func (s *Server) SSOLogin(connectionID, email string, groups ...string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connections[connectionID]
+	if !ok {
+		return "", fmt.Errorf("connection %s not found", connectionID)
+	}
+	if conn.Status != "active" {
+		return "", fmt.Errorf("connection %s is %s, the IdP metadata was never set", connectionID, conn.Status)
+	}
+
+	m := s.memberByEmail(conn.OrganizationID, email)
+	if m == nil {
+		m = &member{Member: organizations.Member{
+			OrganizationID:       conn.OrganizationID,
+			MemberID:             newID("member-test"),
+			EmailAddress:         email,
+			Status:               "active",
+			EmailAddressVerified: true,
+		}}
+		s.members[m.MemberID] = m
+	}
+	m.connectionID = connectionID
+	m.groups = groups
+	m.SSORegistrations = []organizations.SSORegistration{{ConnectionID: connectionID, RegistrationID: newID("sso-registration-test")}}
+
+	token := newID("sso-token-test")
+	s.ssoTokens[token] = m
+	return token, nil
+}
+
+// roles evaluates the implicit role assignments of the organization and of the connection used to log in
+// ref: https://stytch.com/docs/b2b/guides/rbac/role-assignment
+func (s *Server) roles(m *member) []organizations.MemberRole {
+	roles := []organizations.MemberRole{{
+		RoleID:  StytchMemberRole,
+		Sources: []organizations.MemberRoleSource{{Type: "authorization_check_default"}},
+	}}
+	add := func(roleID, source string, details map[string]any) {
+		for i := range roles {
+			if roles[i].RoleID == roleID {
+				roles[i].Sources = append(roles[i].Sources, organizations.MemberRoleSource{Type: source, Details: details})
+				return
+			}
+		}
+		roles = append(roles, organizations.MemberRole{RoleID: roleID, Sources: []organizations.MemberRoleSource{{Type: source, Details: details}}})
+	}
+
+	if org := s.organizations[m.OrganizationID]; org != nil {
+		domain := m.EmailAddress[strings.LastIndex(m.EmailAddress, "@")+1:]
+		for _, assignment := range org.RBACEmailImplicitRoleAssignments {
+			if strings.EqualFold(assignment.Domain, domain) {
+				add(assignment.RoleID, "email_assignment", map[string]any{"domain": domain})
+			}
+		}
+	}
+
+	if conn := s.connections[m.connectionID]; conn != nil {
+		for _, assignment := range conn.SAMLConnectionImplicitRoleAssignments {
+			add(assignment.RoleID, "sso_connection", map[string]any{"connection_id": conn.ConnectionID})
+		}
+		for _, assignment := range conn.SAMLGroupImplicitRoleAssignments {
+			for _, group := range m.groups {
+				if group == assignment.Group {
+					add(assignment.RoleID, "sso_connection_group", map[string]any{"connection_id": conn.ConnectionID, "group": group})
+				}
+			}
+		}
+	}
+
+	return roles
+}
+
+// connection looks up a connection of the organization, writing the Stytch 404 when it does not exist
+func (s *Server) connection(w http.ResponseWriter, organizationID, connectionID string) (*sso.SAMLConnection, bool) {
+	if _, ok := s.organization(w, organizationID); !ok {
+		return nil, false
+	}
+
+	conn, ok := s.connections[connectionID]
+	if !ok || conn.OrganizationID != organizationID {
+		writeError(w, http.StatusNotFound, "connection_not_found", "Connection could not be found.")
+		return nil, false
+	}
+	return conn, true
+}
+
+func (s *Server) memberByEmail(organizationID, email string) *member {
+	for _, m := range s.members {
+		if m.OrganizationID == organizationID && strings.EqualFold(m.EmailAddress, email) {
+			return m
+		}
+	}
+	return nil
+}
+
+// Connection returns a copy of a SAML connection, for assertions
+func (s *Server) Connection(id string) (sso.SAMLConnection, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn, ok := s.connections[id]
+	if !ok {
+		return sso.SAMLConnection{}, false
+	}
+	return *conn, true
+}
+
+// trusts tells whether the certificate is already a verification certificate of the connection
+func trusts(conn *sso.SAMLConnection, certificate string) bool {
+	for _, cert := range conn.VerificationCertificates {
+		if normalize(cert.Certificate) == normalize(certificate) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize strips the PEM armor and whitespaces of a certificate
+func normalize(certificate string) string {
+	certificate = strings.ReplaceAll(certificate, "-----BEGIN CERTIFICATE-----", "")
+	certificate = strings.ReplaceAll(certificate, "-----END CERTIFICATE-----", "")
+	return strings.Join(strings.Fields(certificate), "")
+}